@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"pathid_assignment/pkg/config"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// newConfigCommand defines the "config" command group for inspecting the configuration.
+func newConfigCommand(opts *options) *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the configuration merged from the config file, the environment and flags",
+	}
+
+	var format string
+	printCmd := &cobra.Command{
+		Use:   "print [command]...",
+		Short: "Print the effective settings of commands, and where each value came from",
+		Long: "Print the effective settings of the given commands, or of every command, and where each value came from.\n" +
+			"Settings are read from the config file, then TRANSFORMER_<SETTING> and TRANSFORMER_<COMMAND>_<SETTING>\n" +
+			"environment variables, then flags, each overriding the previous ones.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return badInput(fmt.Errorf("unknown format %q, expected text or json", format))
+			}
+
+			root := cmd.Root()
+			cfg, err := loadConfig(root, opts.configPath)
+			if err != nil {
+				return badInput(err)
+			}
+
+			commands := configurable(root)
+			if len(args) > 0 {
+				commands = nil
+				for _, name := range args {
+					found, _, err := root.Find([]string{name})
+					if err != nil || found == root {
+						return badInput(fmt.Errorf("unknown command %q", name))
+					}
+					commands = append(commands, found)
+				}
+			}
+
+			var settings []setting
+			for _, command := range commands {
+				values, err := commandSettings(command, cfg, opts)
+				if err != nil {
+					return err
+				}
+				settings = append(settings, values...)
+			}
+
+			out := cmd.OutOrStdout()
+			if format == "json" {
+				return writeJSON(out, map[string]interface{}{"config_file": cfg.Path, "settings": settings})
+			}
+			file := cfg.Path
+			if file == "" {
+				file = "none"
+			}
+			fmt.Fprintln(out, "Config file:", file)
+			table := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(table, "SETTING\tVALUE\tSOURCE")
+			for _, s := range settings {
+				fmt.Fprintf(table, "%s\t%s\t%s\n", s.Key, s.Value, s.Source)
+			}
+			return table.Flush()
+		},
+	}
+	printCmd.Flags().StringVarP(&format, "format", "f", "text", "Output format: text or json")
+
+	configCmd.AddCommand(printCmd)
+	return configCmd
+}
+
+// setting is the effective value of a flag of a command.
+type setting struct {
+	Key    string `json:"key"` // Command and flag, e.g. "transform.merge".
+	Value  string `json:"value"`
+	Source string `json:"source"` // default, flag, "env TRANSFORMER_..." or "file <path>".
+}
+
+// loadConfig loads the config file, checking that every setting it holds is the flag of a command.
+func loadConfig(root *cobra.Command, path string) (*config.Config, error) {
+	if path == "" {
+		path = os.Getenv(config.EnvPrefix + "CONFIG")
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg.Shared = func(key string) bool { return sharedFlag(root, key) }
+
+	return cfg, cfg.Check(func(section, key string) bool {
+		commands := configurable(root)
+		if section != "" {
+			command, _, err := root.Find([]string{section})
+			if err != nil || command == root {
+				return false
+			}
+			commands = []*cobra.Command{command}
+		}
+		for _, command := range commands {
+			if hasFlag(command, key) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// applyConfig sets the flags of a command that were not given from the config file and the environment.
+func applyConfig(cmd *cobra.Command, cfg *config.Config, opts *options) error {
+	opts.sources = make(map[*pflag.Flag]string)
+	section := commandSection(cmd)
+
+	for _, flag := range commandFlags(cmd) {
+		if flag.Changed {
+			continue
+		}
+		values, source, ok, err := cfg.Lookup(section, flag.Name, isList(flag))
+		if err != nil {
+			return badInput(err)
+		}
+		if !ok {
+			continue
+		}
+		for _, value := range values {
+			if err := flag.Value.Set(value); err != nil {
+				return badInput(fmt.Errorf("setting %s from %s: %w", flag.Name, source, err))
+			}
+		}
+		flag.Changed = true
+		opts.sources[flag] = source
+	}
+	return nil
+}
+
+// commandSettings returns the effective settings of a command, in flag name order.
+func commandSettings(cmd *cobra.Command, cfg *config.Config, opts *options) ([]setting, error) {
+	section := commandSection(cmd)
+
+	var settings []setting
+	for _, flag := range commandFlags(cmd) {
+		s := setting{Key: section + "." + flag.Name, Value: flag.Value.String(), Source: config.Flag}
+		if source, ok := opts.sources[flag]; ok {
+			s.Source = source
+		} else if !flag.Changed {
+			s.Value, s.Source = flag.DefValue, config.Default
+			values, source, ok, err := cfg.Lookup(section, flag.Name, isList(flag))
+			if err != nil {
+				return nil, badInput(err)
+			}
+			if ok {
+				s.Value, s.Source = values[0], source
+				if isList(flag) {
+					s.Value = "[" + strings.Join(values, ",") + "]"
+				}
+			}
+		}
+		settings = append(settings, s)
+	}
+	return settings, nil
+}
+
+// configurable returns the commands whose flags can be configured.
+func configurable(root *cobra.Command) []*cobra.Command {
+	var commands []*cobra.Command
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		if cmd.Name() == "help" || cmd.Name() == "completion" || cmd.Name() == "config" {
+			return
+		}
+		if cmd != root && len(commandFlags(cmd)) > 0 {
+			commands = append(commands, cmd)
+		}
+		for _, child := range cmd.Commands() {
+			walk(child)
+		}
+	}
+	walk(root)
+	return commands
+}
+
+// commandSection names the section of the config file holding the settings of a command: the top-level
+// command it belongs to.
+func commandSection(cmd *cobra.Command) string {
+	for cmd.HasParent() && cmd.Parent().HasParent() {
+		cmd = cmd.Parent()
+	}
+	if !cmd.HasParent() {
+		return ""
+	}
+	return cmd.Name()
+}
+
+// commandFlags returns the flags of a command that settings can set, sorted by name.
+func commandFlags(cmd *cobra.Command) []*pflag.Flag {
+	var flags []*pflag.Flag
+	visit := func(flag *pflag.Flag) {
+		if flag.Name != "help" && flag.Name != "config" {
+			flags = append(flags, flag)
+		}
+	}
+	cmd.LocalFlags().VisitAll(visit)
+	cmd.InheritedFlags().VisitAll(visit)
+	sort.Slice(flags, func(i, j int) bool { return flags[i].Name < flags[j].Name })
+	return flags
+}
+
+// hasFlag reports whether a command, or one of its subcommands, has a settable flag.
+func hasFlag(cmd *cobra.Command, name string) bool {
+	for _, flag := range commandFlags(cmd) {
+		if flag.Name == name {
+			return true
+		}
+	}
+	for _, child := range cmd.Commands() {
+		if hasFlag(child, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sharedFlag reports whether every command with a flag gives it the same meaning, the same type and
+// description, so that it can be set at the top level of the config file and by TRANSFORMER_<SETTING>.
+// Flags such as output or format, a directory for some commands and a file for others, are not shared.
+func sharedFlag(root *cobra.Command, name string) bool {
+	var first *pflag.Flag
+	shared := true
+	var walk func(cmd *cobra.Command)
+	walk = func(cmd *cobra.Command) {
+		if flag := cmd.LocalFlags().Lookup(name); flag != nil && name != "help" {
+			if first == nil {
+				first = flag
+			} else if flag.Usage != first.Usage || flag.Value.Type() != first.Value.Type() || flag.DefValue != first.DefValue {
+				shared = false
+			}
+		}
+		for _, child := range cmd.Commands() {
+			walk(child)
+		}
+	}
+	walk(root)
+	return shared
+}
+
+func isList(flag *pflag.Flag) bool {
+	return strings.HasSuffix(flag.Value.Type(), "Array") || strings.HasSuffix(flag.Value.Type(), "Slice")
+}
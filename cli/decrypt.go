@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"pathid_assignment/pkg/envelope"
+	"pathid_assignment/pkg/storage"
+
+	"github.com/spf13/cobra"
+)
+
+// newDecryptCommand defines the "decrypt" command, which decrypts the encrypted fields of an output directory.
+func newDecryptCommand() *cobra.Command {
+	var keyPath, outputPath string
+
+	decryptCmd := &cobra.Command{
+		Use:   "decrypt <output directory>",
+		Short: "Decrypt the encrypted fields of an output directory with the master key file",
+		Args:  positional(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireFlags(cmd, "key-file", "output"); err != nil {
+				return err
+			}
+			if filepath.Clean(outputPath) == filepath.Clean(args[0]) {
+				return badInput(fmt.Errorf("the decrypted files must be written to another directory than %s", args[0]))
+			}
+
+			kms, err := envelope.LoadFileKMS(keyPath)
+			if err != nil {
+				return badInput(err)
+			}
+			decrypted, err := storage.DecryptDirectory(kms, args[0], outputPath)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Decrypted %d values into %s\n", decrypted, outputPath)
+			return nil
+		},
+	}
+
+	decryptCmd.Flags().StringVarP(&keyPath, "key-file", "k", "", "Path to the master key file the data key was wrapped with (required)")
+	decryptCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path to the directory the decrypted files are written to (required)")
+
+	return decryptCmd
+}
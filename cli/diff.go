@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"pathid_assignment/pkg/diff"
+	"pathid_assignment/pkg/processor"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/storage"
+	"pathid_assignment/pkg/transformer"
+	"pathid_assignment/pkg/unmarshaller"
+
+	"github.com/spf13/cobra"
+)
+
+// newDiffCommand defines the "diff" command, which reports the users and sign-ins that changed between two runs.
+func newDiffCommand(opts *options) *cobra.Command {
+	var format, outputPath string
+	var inputs bool
+
+	diffCmd := &cobra.Command{
+		Use:   "diff <old> <new>",
+		Short: "Compare two output directories, or two input snapshots transformed with the same rules",
+		Long: "Compare two output directories holding users.json and signin.json, reporting added, removed and modified\n" +
+			"users with their changed fields, and sign-ins that appeared or disappeared. With --inputs, the arguments are\n" +
+			"input files or directories that are transformed with the same rules before being compared.",
+		Args: positional(cobra.ExactArgs(2)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return badInput(fmt.Errorf("unknown format %q, expected text or json", format))
+			}
+
+			var ruleSet *rules.Rules
+			if inputs {
+				var err error
+				if ruleSet, err = loadRules(opts.rulesPath, opts.logger); err != nil {
+					return badInput(fmt.Errorf("loading rules: %w", err))
+				}
+			}
+
+			outputs := make([]*storage.Output, len(args))
+			var partial error
+			for i, path := range args {
+				output, err := loadOutput(path, ruleSet, opts)
+				if output == nil {
+					return err
+				}
+				if err != nil {
+					partial = fmt.Errorf("%s: %w", path, err)
+				}
+				outputs[i] = output
+			}
+
+			out, closeOut, err := createOutput(cmd, outputPath)
+			if err != nil {
+				return err
+			}
+			defer closeOut()
+
+			report := diff.Compare(outputs[0], outputs[1])
+			if format == "json" {
+				err = writeJSON(out, report)
+			} else {
+				err = report.WriteText(out)
+			}
+			if err != nil {
+				return err
+			}
+			return partial
+		},
+	}
+
+	diffCmd.Flags().BoolVar(&inputs, "inputs", false, "Treat the arguments as inputs to transform with the rules instead of output directories")
+	diffCmd.Flags().StringVarP(&format, "format", "f", "text", "Report format: text or json")
+	diffCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path to the report file (optional, defaults to standard output)")
+
+	return diffCmd
+}
+
+// loadOutput loads the users and sign-ins of an output directory. With rules, path is an input file or
+// directory that is first transformed with them into a temporary directory. When some of its files or
+// records failed, the output is returned with the partial exit error, since the users they held are
+// missing; when all of them failed, only the error is.
+func loadOutput(path string, ruleSet *rules.Rules, opts *options) (*storage.Output, error) {
+	if ruleSet == nil {
+		output, err := storage.LoadOutput(path)
+		if err != nil {
+			return nil, badInput(err)
+		}
+		return output, nil
+	}
+
+	dir, err := os.MkdirTemp("", "transformer-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	proc := processor.NewProcessor(
+		transformer.NewKeywordTransformer(),
+		unmarshaller.NewJSONUnmarshaller(),
+		opts.newStorage(),
+	)
+	proc.Logger, proc.RunID = opts.logger, opts.runID
+	summary, err := proc.ProcessRules([]string{path}, ruleSet, dir)
+	if err != nil {
+		return nil, err
+	}
+	result := outcome(summary)
+	if exitCode(result) == exitFailure {
+		return nil, result
+	}
+	output, err := storage.LoadOutput(dir)
+	if err != nil {
+		return nil, err
+	}
+	return output, result
+}
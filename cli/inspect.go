@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pathid_assignment/pkg/discovery"
+	"pathid_assignment/pkg/inspect"
+	"pathid_assignment/pkg/unmarshaller"
+
+	"github.com/spf13/cobra"
+)
+
+// newInspectCommand defines the "inspect" command, which lists the fields found in input files.
+func newInspectCommand() *cobra.Command {
+	var format, rulesOutput string
+	var snakeCase bool
+	var inputs discovery.Options
+
+	inspectCmd := &cobra.Command{
+		Use:   "inspect <input>...",
+		Short: "List the fields found in input files, and infer starter rules from them",
+		Long: "List the fields found in input files, at every nesting level, with how often they are present, null or missing,\n" +
+			"their number of distinct values, their types and example values. With --emit-rules, starter rules mapping every\n" +
+			"field are written too, as JSON or YAML by the file extension, or as JSON to standard output for \"-\".",
+		Args: positional(cobra.MinimumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return badInput(fmt.Errorf("unknown format %q, expected text or json", format))
+			}
+			ext := strings.ToLower(filepath.Ext(rulesOutput))
+			if rulesOutput != "" && rulesOutput != "-" && ext != ".json" && ext != ".yaml" && ext != ".yml" {
+				return badInput(fmt.Errorf("starter rules are written as .json, .yaml or .yml files, not %s", rulesOutput))
+			}
+
+			records, err := readRecords(args, inputs, cmd.InOrStdin())
+			if err != nil {
+				return badInput(err)
+			}
+
+			profile := inspect.Scan(records)
+			if rulesOutput != "" {
+				naming := inspect.KeepNames
+				if snakeCase {
+					naming = inspect.SnakeCase
+				}
+				starter := profile.Rules(naming)
+
+				// Rules written to standard output replace the field inventory.
+				if rulesOutput == "-" {
+					return starter.WriteJSON(cmd.OutOrStdout())
+				}
+				if err := writeStarterRules(starter, rulesOutput); err != nil {
+					return err
+				}
+				defer fmt.Fprintf(cmd.OutOrStdout(), "Wrote starter rules for %d fields to %s\n", starter.Fields(), rulesOutput)
+			}
+
+			if format == "json" {
+				return writeJSON(cmd.OutOrStdout(), profile)
+			}
+			return profile.WriteText(cmd.OutOrStdout())
+		},
+	}
+
+	addDiscoveryFlags(inspectCmd, &inputs)
+	inspectCmd.Flags().StringVarP(&format, "format", "f", "text", "Output format: text or json")
+	inspectCmd.Flags().StringVar(&rulesOutput, "emit-rules", "", `Path to write starter rules mapping every field to, as .json, .yaml or .yml, or "-" for standard output (optional)`)
+	inspectCmd.Flags().BoolVar(&snakeCase, "snake-case", false, "Name the targets of starter rules in snake_case, like the default rules")
+
+	return inspectCmd
+}
+
+// readRecords reads the records of the input files found by the discovery options.
+func readRecords(paths []string, inputs discovery.Options, stdin io.Reader) ([]map[string]interface{}, error) {
+	files, err := inputs.Find(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []map[string]interface{}
+	for _, file := range files {
+		var data []byte
+		if file == discovery.Stdin {
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		objs, err := unmarshaller.NewJSONUnmarshaller().UnmarshalByProperty(data, nil, "value")
+		if err != nil {
+			return nil, fmt.Errorf("parsing input file %s: %w", file, err)
+		}
+		records = append(records, objs...)
+	}
+	return records, nil
+}
+
+// writeStarterRules writes starter rules into a new file, as YAML or JSON by its extension. An existing
+// file is never replaced, since it may be rules edited by hand.
+func writeStarterRules(starter *inspect.StarterRules, path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return badInput(fmt.Errorf("%s already exists, starter rules are only written to new files", path))
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = starter.WriteYAML(file)
+	default:
+		err = starter.WriteJSON(file)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
@@ -1,84 +1,220 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+
+	"pathid_assignment/pkg/discovery"
 	"pathid_assignment/pkg/processor"
 	"pathid_assignment/pkg/storage"
-	"pathid_assignment/pkg/transformer"
-	"pathid_assignment/pkg/unmarshaller"
-
-	"fmt"
-	"log"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-const defaultRulesPath = "configs/default_mapping_config.json"
-const defaultOutputPath = "data/output"
+// Exit codes of the CLI.
+const (
+	exitOK       = 0
+	exitFailure  = 1 // The command failed, or a run transformed no record at all.
+	exitBadInput = 2 // Invalid flags, arguments, rules or input paths.
+	exitPartial  = 3 // A run completed, but some records or files failed.
+)
 
-func main() {
-	var inputPath, outputPath, rulesPath string
-
-	// Define CLI command
-	var rootCmd = &cobra.Command{
-		Use:   "read",
-		Short: "Read and process input file",
-		Run: func(cmd *cobra.Command, args []string) {
-			// Validate required input flag
-			if inputPath == "" {
-				log.Fatal("Error: Input and output paths must be specified")
-			}
+// version is set at build time with -ldflags "-X main.version=v1.2.3".
+var version = "dev"
 
-			// Use default rules file if none provided
-			if rulesPath == "" {
-				fmt.Println("No rules file specified. Using default rules file:", defaultRulesPath)
-				rulesPath = defaultRulesPath
-			}
+// exitError is an error the CLI exits with a specific code for.
+type exitError struct {
+	code int
+	err  error
+}
 
-			// Use default output directory path if none provided
-			if outputPath == "" {
-				fmt.Println("No output directory path specified. Using default path:", defaultOutputPath)
-				outputPath = defaultOutputPath
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
 
-				// Check if output directory is empty and clear it if necessary
-				fmt.Println("Clearing output directory...")
-				clearOutputDirectory(outputPath)
-			}
+func (e *exitError) Unwrap() error {
+	return e.err
+}
 
-			fmt.Println("Starting processing...")
+// badInput marks an error as caused by the flags, arguments, rules or inputs given to a command.
+func badInput(err error) error {
+	return &exitError{code: exitBadInput, err: err}
+}
 
-			proc := processor.NewProcessor(
-				transformer.NewKeywordTransformer(),
-				unmarshaller.NewJSONUnmarshaller(),
-				storage.NewStorage(),
-			)
+// exitCode returns the code the CLI exits with for an error returned by a command.
+func exitCode(err error) int {
+	var exitErr *exitError
+	var inputErr *processor.InputError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.As(err, &inputErr):
+		return exitBadInput
+	}
+	return exitFailure
+}
+
+// options holds the persistent flags shared by every command.
+type options struct {
+	rulesPath  string
+	configPath string
+	logLevel   string
+	logFormat  string
+
+	// logger writes the messages of the run to stderr, and runID correlates them.
+	logger *slog.Logger
+	runID  string
+
+	// sources tells where flags set from the config file or the environment took their value from.
+	sources map[*pflag.Flag]string
+}
 
-			proc.Process([]string{inputPath}, rulesPath, outputPath)
-			fmt.Println("Processing completed successfully!")
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// newRootCommand defines the command tree.
+func newRootCommand() *cobra.Command {
+	opts := &options{}
+
+	rootCmd := &cobra.Command{
+		Use:           "transformer",
+		Short:         "Transform Microsoft Graph user exports into users and sign-in activity files",
+		Args:          positional(cobra.NoArgs),
+		SilenceUsage:  true,
+		SilenceErrors: true, // Errors are printed once by main, with their exit code.
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd.Root(), opts.configPath)
+			if err != nil {
+				return badInput(err)
+			}
+			if err := applyConfig(cmd, cfg, opts); err != nil {
+				return err
+			}
+			if opts.logger, err = newLogger(cmd.ErrOrStderr(), opts.logLevel, opts.logFormat); err != nil {
+				return badInput(err)
+			}
+			opts.runID = processor.NewRunID()
+			return nil
 		},
 	}
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return badInput(err)
+	})
+
+	rootCmd.PersistentFlags().StringVarP(&opts.rulesPath, "rules", "r", "", "Path to rules file (optional, defaults to configs/default_mapping_config.json or the built-in rules)")
+	rootCmd.PersistentFlags().StringVar(&opts.logLevel, "log-level", "info", "Lowest level of the messages logged to stderr: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&opts.logFormat, "log-format", "text", "Format of the messages logged to stderr: text or json")
+	rootCmd.PersistentFlags().StringVar(&opts.configPath, "config", "", "Path to a YAML or JSON config file (optional, defaults to $TRANSFORMER_CONFIG or transformer.yaml, .yml or .json)")
+
+	rootCmd.AddCommand(newTransformCommand(opts))
+	rootCmd.AddCommand(newValidateCommand(opts))
+	rootCmd.AddCommand(newRulesCommand(opts))
+	rootCmd.AddCommand(newInspectCommand())
+	rootCmd.AddCommand(newReverseCommand(opts))
+	rootCmd.AddCommand(newDiffCommand(opts))
+	rootCmd.AddCommand(newReportCommand(opts))
+	rootCmd.AddCommand(newDecryptCommand())
+	rootCmd.AddCommand(newConfigCommand(opts))
+	rootCmd.AddCommand(newVersionCommand())
+
+	return rootCmd
+}
 
-	// Define command flags
-	rootCmd.Flags().StringVarP(&inputPath, "input", "i", "", "Path to input file (required)")
-	rootCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path to output directory (optional), default to data/output")
-	rootCmd.Flags().StringVarP(&rulesPath, "rules", "r", "", "Path to rules file (optional, defaults to configs/default_rules.json)")
+// positional marks the errors of an argument validator as bad input.
+func positional(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := validate(cmd, args); err != nil {
+			return badInput(err)
+		}
+		return nil
+	}
+}
 
-	// Execute CLI command
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatalf("Error executing command: %v", err)
+// requireFlags reports required flags that were not given as bad input.
+func requireFlags(cmd *cobra.Command, names ...string) error {
+	for _, name := range names {
+		if !cmd.Flags().Changed(name) {
+			return badInput(fmt.Errorf("required flag %q not set", name))
+		}
 	}
+	return nil
 }
 
-func clearOutputDirectory(dir string) {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		log.Fatalf("Error reading output directory: %v", err)
+// newLogger returns a logger writing messages of the given level and above to w, as text or JSON.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var handlerOptions slog.HandlerOptions
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
 	}
+	handlerOptions.Level = lvl
 
-	for _, entry := range entries {
-		filePath := fmt.Sprintf("%s/%s", dir, entry.Name())
-		if err := os.RemoveAll(filePath); err != nil {
-			log.Fatalf("Error clearing output file %s: %v", filePath, err)
-		}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, &handlerOptions)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, &handlerOptions)), nil
 	}
+	return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+}
+
+// newStorage returns a storage logging to the logger of the run.
+func (o *options) newStorage() *storage.Storage {
+	store := storage.NewStorage()
+	store.Logger = o.logger.With("run_id", o.runID)
+	return store
+}
+
+// outcome returns the error a run exits with: a partial failure when some records or files failed,
+// and a failure when none was transformed.
+func outcome(summary processor.Summary) error {
+	if summary.Failed == 0 && summary.Rejected == 0 && summary.FailedFiles == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("%d records failed, %d were rejected and %d files could not be read", summary.Failed, summary.Rejected, summary.FailedFiles)
+	if summary.Transformed == 0 {
+		return &exitError{code: exitFailure, err: err}
+	}
+	return &exitError{code: exitPartial, err: err}
+}
+
+// createOutput opens the file a command writes its result to, or the command's standard output without a path.
+func createOutput(cmd *cobra.Command, path string) (io.Writer, func(), error) {
+	if path == "" {
+		return cmd.OutOrStdout(), func() {}, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, func() { file.Close() }, nil
+}
+
+// writeJSON writes a value as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// addDiscoveryFlags defines the flags selecting the input files found in input directories.
+func addDiscoveryFlags(cmd *cobra.Command, inputs *discovery.Options) {
+	cmd.Flags().BoolVarP(&inputs.Recursive, "recursive", "R", false, "Find input files in the subdirectories of input directories")
+	cmd.Flags().StringArrayVar(&inputs.Include, "include", nil, `Pattern matching the names of input files in directories; repeatable (default "*.json")`)
+	cmd.Flags().StringArrayVar(&inputs.Exclude, "exclude", nil, "Pattern matching the names or relative paths of files and directories to skip; repeatable")
 }
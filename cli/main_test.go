@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRules = "../configs/default_mapping_config.json"
+
+// execute runs the CLI with arguments, returning its exit code and what it wrote to stdout and stderr.
+func execute(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	t.Setenv("TRANSFORMER_CONFIG", "")
+
+	var stdout, stderr bytes.Buffer
+	cmd := newRootCommand()
+	cmd.SetArgs(args)
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetIn(strings.NewReader(""))
+	err := cmd.Execute()
+	if err != nil {
+		stderr.WriteString("Error: " + err.Error() + "\n")
+	}
+	return exitCode(err), stdout.String(), stderr.String()
+}
+
+// writeInputs writes input files into a new directory: valid ones with a user each, and corrupt ones.
+func writeInputs(t *testing.T, valid, corrupt int) string {
+	t.Helper()
+	dir := t.TempDir()
+	for i := 0; i < valid; i++ {
+		user := `{"value": [{"id": "user-` + string(rune('a'+i)) + `", "userType": "Member", "accountEnabled": true}]}`
+		writeFile(t, filepath.Join(dir, "valid-"+string(rune('a'+i))+".json"), user)
+	}
+	for i := 0; i < corrupt; i++ {
+		writeFile(t, filepath.Join(dir, "corrupt-"+string(rune('a'+i))+".json"), `{"value": [`)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     func(output string) []string
+		expected int
+	}{
+		{"success", func(output string) []string {
+			return []string{"transform", "-r", testRules, "-i", writeInputs(t, 2, 0), "-o", output}
+		}, exitOK},
+		{"unknown flag", func(output string) []string {
+			return []string{"transform", "--unknown"}
+		}, exitBadInput},
+		{"missing input flag", func(output string) []string {
+			return []string{"transform", "-r", testRules, "-o", output}
+		}, exitBadInput},
+		{"missing input path", func(output string) []string {
+			return []string{"transform", "-r", testRules, "-i", filepath.Join(t.TempDir(), "missing.json"), "-o", output}
+		}, exitBadInput},
+		{"missing rules", func(output string) []string {
+			return []string{"transform", "-r", "missing.json", "-i", writeInputs(t, 1, 0), "-o", output}
+		}, exitBadInput},
+		{"unknown log level", func(output string) []string {
+			return []string{"version", "--log-level", "verbose"}
+		}, exitBadInput},
+		{"some files failed", func(output string) []string {
+			return []string{"transform", "-r", testRules, "-i", writeInputs(t, 1, 1), "-o", output}
+		}, exitPartial},
+		{"every file failed", func(output string) []string {
+			return []string{"transform", "-r", testRules, "-i", writeInputs(t, 0, 2), "-o", output}
+		}, exitFailure},
+		{"diff of inputs with failed files", func(output string) []string {
+			return []string{"diff", "-r", testRules, "--inputs", writeInputs(t, 1, 0), writeInputs(t, 1, 1)}
+		}, exitPartial},
+		{"diff of inputs that all failed", func(output string) []string {
+			return []string{"diff", "-r", testRules, "--inputs", writeInputs(t, 1, 0), writeInputs(t, 0, 1)}
+		}, exitFailure},
+		{"report of inputs with failed files", func(output string) []string {
+			return []string{"report", "-r", testRules, "--inputs", writeInputs(t, 1, 1)}
+		}, exitPartial},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			code, _, stderr := execute(t, c.args(t.TempDir())...)
+			if code != c.expected {
+				t.Errorf("Expected exit code %d, got %d:\n%s", c.expected, code, stderr)
+			}
+		})
+	}
+}
+
+func TestTransform_ExistingOutput(t *testing.T) {
+	inputs := writeInputs(t, 1, 0)
+	transform := func(output string, flags ...string) (int, string) {
+		t.Helper()
+		code, _, stderr := execute(t, append([]string{"transform", "-r", testRules, "-i", inputs, "-o", output}, flags...)...)
+		return code, stderr
+	}
+
+	output := t.TempDir()
+	writeFile(t, filepath.Join(output, "notes.txt"), "kept")
+
+	if code, stderr := transform(output, "--timestamped=false"); code != exitBadInput || !strings.Contains(stderr, "is not empty") {
+		t.Errorf("Expected a non-empty output directory to be refused, got %d:\n%s", code, stderr)
+	}
+	if code, stderr := transform(output, "--overwrite"); code != exitBadInput || !strings.Contains(stderr, "--timestamped=false") {
+		t.Errorf("Expected --overwrite to need --timestamped=false, got %d:\n%s", code, stderr)
+	}
+	if code, stderr := transform(output, "--timestamped=false", "--overwrite"); code != exitOK {
+		t.Fatalf("Expected --overwrite to write into the directory, got %d:\n%s", code, stderr)
+	}
+	if _, err := os.Stat(filepath.Join(output, "users.json")); err != nil {
+		t.Errorf("Expected users.json to be written: %v", err)
+	}
+
+	// The stale accounts of the analytics run are still listed once overwritten by a run without analytics.
+	if code, stderr := transform(output, "--timestamped=false", "--overwrite", "--analytics"); code != exitOK {
+		t.Fatalf("Expected --overwrite to write into the directory, got %d:\n%s", code, stderr)
+	}
+	if code, stderr := transform(output, "--timestamped=false", "--overwrite"); code != exitOK {
+		t.Fatalf("Expected --overwrite to write into the directory, got %d:\n%s", code, stderr)
+	}
+	if code, stderr := transform(output, "--timestamped=false", "--clean"); code != exitOK {
+		t.Fatalf("Expected --clean to write into the directory, got %d:\n%s", code, stderr)
+	}
+	if data, err := os.ReadFile(filepath.Join(output, "notes.txt")); err != nil || string(data) != "kept" {
+		t.Errorf("Expected files the CLI did not write to be kept, got %q (%v)", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(output, "stale_accounts.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale accounts of the earlier run to be cleaned, got %v", err)
+	}
+
+	// Without a manifest, --clean refuses to replace files of the same name as the outputs.
+	own := t.TempDir()
+	writeFile(t, filepath.Join(own, "users.json"), "mine")
+	if code, stderr := transform(own, "--timestamped=false", "--clean"); code != exitBadInput || !strings.Contains(stderr, "holds users.json not written by a previous run") {
+		t.Errorf("Expected --clean to refuse replacing users.json, got %d:\n%s", code, stderr)
+	}
+	if data, err := os.ReadFile(filepath.Join(own, "users.json")); err != nil || string(data) != "mine" {
+		t.Errorf("Expected users.json to be kept, got %q (%v)", data, err)
+	}
+
+	// A run failing on bad input only removes the directories it created.
+	existing := t.TempDir()
+	if code, _, _ := execute(t, "transform", "-r", testRules, "-i", filepath.Join(inputs, "missing.json"), "-o", existing, "--timestamped=false"); code != exitBadInput {
+		t.Fatalf("Expected a missing input to be bad input, got %d", code)
+	}
+	if _, err := os.Stat(existing); err != nil {
+		t.Errorf("Expected the existing output directory to be kept: %v", err)
+	}
+	created := filepath.Join(existing, "runs", "new")
+	if code, _, _ := execute(t, "transform", "-r", testRules, "-i", filepath.Join(inputs, "missing.json"), "-o", created); code != exitBadInput {
+		t.Fatalf("Expected a missing input to be bad input, got %d", code)
+	}
+	if entries, err := os.ReadDir(existing); err != nil || len(entries) != 0 {
+		t.Errorf("Expected the created directories to be removed, got %v (%v)", entries, err)
+	}
+}
+
+func TestConfig_Unshared(t *testing.T) {
+	t.Setenv("TRANSFORMER_FORMAT", "markdown")
+	if code, _, stderr := execute(t, "config", "print", "transform"); code != exitBadInput || !strings.Contains(stderr, "use TRANSFORMER_CONFIG_FORMAT instead") {
+		t.Errorf("Expected TRANSFORMER_FORMAT to be rejected, got %d:\n%s", code, stderr)
+	}
+
+	path := filepath.Join(t.TempDir(), "transformer.yaml")
+	writeFile(t, path, "output: runs\n")
+	if code, _, stderr := execute(t, "version", "--config", path); code != exitBadInput || !strings.Contains(stderr, "settings output mean different things per command") {
+		t.Errorf("Expected the top-level output to be rejected, got %d:\n%s", code, stderr)
+	}
+}
+
+func TestReverse_SignIns(t *testing.T) {
+	inputs := t.TempDir()
+	writeFile(t, filepath.Join(inputs, "users.json"), `{"value": [{"id": "user-a", "signInActivity": {"lastSignInDateTime": "2024-01-01T00:00:00", "lastSignInRequestId": "req-1"}}]}`)
+	output := t.TempDir()
+	if code, _, stderr := execute(t, "transform", "-r", testRules, "-i", inputs, "-o", output, "--timestamped=false"); code != exitOK {
+		t.Fatalf("Expected the run to succeed, got %d:\n%s", code, stderr)
+	}
+
+	code, stdout, stderr := execute(t, "reverse", "-r", testRules, "-i", filepath.Join(output, "users.json"))
+	if code != exitOK || !strings.Contains(stdout, `"lastSignInRequestId": "req-1"`) {
+		t.Errorf("Expected the sign-ins to be joined back, got %d:\n%s\n%s", code, stdout, stderr)
+	}
+
+	code, stdout, stderr = execute(t, "reverse", "-r", testRules, "-i", filepath.Join(output, "users.json"), "--sign-ins", "")
+	if code != exitOK || strings.Contains(stdout, "signInActivity") || !strings.Contains(stderr, "field=sign_in_activity") {
+		t.Errorf("Expected the missing sign-ins to be reported, got %d:\n%s\n%s", code, stdout, stderr)
+	}
+}
+
+func TestTransform_StreamOutput(t *testing.T) {
+	code, stdout, stderr := execute(t, "transform", "-r", testRules, "-i", writeInputs(t, 2, 0), "-o", "-")
+	if code != exitOK {
+		t.Fatalf("Expected the run to succeed, got %d:\n%s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"file":"users","record":{`) {
+		t.Errorf("Expected a JSON line per user on standard output, got:\n%s", stdout)
+	}
+	if !strings.Contains(stderr, "Processing completed successfully!") {
+		t.Errorf("Expected messages on standard error, got:\n%s", stderr)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+
+	"pathid_assignment/pkg/report"
+	"pathid_assignment/pkg/rules"
+
+	"github.com/spf13/cobra"
+)
+
+// newReportCommand defines the "report" command, which aggregates the users and sign-ins of a run.
+func newReportCommand(opts *options) *cobra.Command {
+	var format, outputPath string
+	var inputs bool
+	fields := report.DefaultFields
+
+	reportCmd := &cobra.Command{
+		Use:   "report <output directory>",
+		Short: "Aggregate the users and sign-ins of an output directory, or of an input transformed with the rules",
+		Long: "Aggregate the users of an output directory by type, location and enabled state, count guests and members,\n" +
+			"and build a histogram of sign-ins by month. With --inputs, the argument is an input file or directory\n" +
+			"that is transformed with the rules first. A warning is logged for every field grouped by that the rules\n" +
+			"do not produce, or produce protected or encrypted.",
+		Args: positional(cobra.ExactArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "json" && format != "markdown" && format != "html" {
+				return badInput(fmt.Errorf("unknown format %q, expected json, markdown or html", format))
+			}
+
+			ruleSet, err := loadRules(opts.rulesPath, opts.logger)
+			if err != nil {
+				return badInput(fmt.Errorf("loading rules: %w", err))
+			}
+			for _, warning := range fields.Check(ruleSet) {
+				opts.logger.Warn(warning)
+			}
+
+			var transformWith *rules.Rules
+			if inputs {
+				transformWith = ruleSet
+			}
+			output, partial := loadOutput(args[0], transformWith, opts)
+			if output == nil {
+				return partial
+			}
+
+			out, closeOut, err := createOutput(cmd, outputPath)
+			if err != nil {
+				return err
+			}
+			defer closeOut()
+
+			summary := report.Build(output, fields)
+			switch format {
+			case "markdown":
+				err = summary.WriteMarkdown(out)
+			case "html":
+				err = summary.WriteHTML(out)
+			default:
+				err = writeJSON(out, summary)
+			}
+			if err != nil {
+				return err
+			}
+			return partial
+		},
+	}
+
+	reportCmd.Flags().BoolVar(&inputs, "inputs", false, "Treat the arguments as inputs to transform with the rules instead of output directories")
+	reportCmd.Flags().StringVarP(&format, "format", "f", "json", "Report format: json, markdown or html")
+	reportCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path to the report file (optional, defaults to standard output)")
+	reportCmd.Flags().StringVar(&fields.Type, "type-field", fields.Type, "Target field users are grouped by type with")
+	reportCmd.Flags().StringVar(&fields.Location, "location-field", fields.Location, "Target field users are grouped by location with")
+	reportCmd.Flags().StringVar(&fields.Enabled, "enabled-field", fields.Enabled, "Target field telling whether an account is enabled")
+
+	return reportCmd
+}
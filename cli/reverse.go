@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"pathid_assignment/pkg/storage"
+	"pathid_assignment/pkg/transformer"
+	"pathid_assignment/pkg/unmarshaller"
+
+	"github.com/spf13/cobra"
+)
+
+// newReverseCommand defines the "reverse" command, which builds Graph-shaped source records from target records.
+func newReverseCommand(opts *options) *cobra.Command {
+	var inputPath, outputPath, signInPath string
+
+	reverseCmd := &cobra.Command{
+		Use:   "reverse",
+		Short: "Build source-shaped records from target records (e.g. users.json) using the same rules",
+		Long: "Build source-shaped records from target records using the same rules. Sign-in activities are stored apart\n" +
+			"from users, in signin.json: they are joined back into the users by id from --sign-ins, or from the signin.json\n" +
+			"next to a users.json input. A warning is logged for every target field that no record holds.",
+		Args: positional(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireFlags(cmd, "input"); err != nil {
+				return err
+			}
+
+			ruleSet, err := loadRules(opts.rulesPath, opts.logger)
+			if err != nil {
+				return badInput(err)
+			}
+
+			inverse, err := (&transformer.KeywordTransformer{}).Invert(ruleSet)
+			if err != nil {
+				return badInput(err)
+			}
+			for _, issue := range inverse.Lossy() {
+				opts.logger.Warn("reversed values may differ from the source", "field", issue.Pointer, "reason", issue.Message)
+			}
+
+			fileData, err := os.ReadFile(inputPath)
+			if err != nil {
+				return badInput(fmt.Errorf("reading input file: %w", err))
+			}
+
+			records, err := unmarshaller.NewJSONUnmarshaller().Unmarshal(fileData, nil)
+			if err != nil {
+				return badInput(fmt.Errorf("parsing input file %s: %w", inputPath, err))
+			}
+
+			if !cmd.Flags().Changed("sign-ins") && filepath.Base(inputPath) == filepath.Base(storage.GenerateFilePath("", "users")) {
+				if sibling := storage.GenerateFilePath(filepath.Dir(inputPath), "signInActivity"); fileExists(sibling) {
+					signInPath = sibling
+				}
+			}
+			if signInPath != "" {
+				signInData, err := os.ReadFile(signInPath)
+				if err != nil {
+					return badInput(fmt.Errorf("reading sign-ins file: %w", err))
+				}
+				activities, err := unmarshaller.NewJSONUnmarshaller().Unmarshal(signInData, nil)
+				if err != nil {
+					return badInput(fmt.Errorf("parsing sign-ins file %s: %w", signInPath, err))
+				}
+				storage.RestoreSignInActivities(records, activities)
+			}
+
+			// Target fields no record holds, such as sign-ins stored apart, leave their source fields out.
+			for _, rule := range ruleSet.Fields {
+				if !anyHolds(records, rule.Target) {
+					opts.logger.Warn("no target record holds the field, its source fields are left out", "field", rule.Target)
+				}
+			}
+
+			sources := make([]map[string]interface{}, 0, len(records))
+			for i, record := range records {
+				source, err := inverse.Transform(record)
+				if err != nil {
+					return fmt.Errorf("record %d: %w", i+1, err)
+				}
+				sources = append(sources, source)
+			}
+
+			data, err := json.MarshalIndent(map[string]interface{}{"value": sources}, "", "  ")
+			if err != nil {
+				return err
+			}
+			if outputPath == "" {
+				fmt.Fprintln(cmd.OutOrStdout(), string(data))
+				return nil
+			}
+			if err := os.WriteFile(outputPath, data, 0644); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Wrote %d source records to %s\n", len(sources), outputPath)
+			return nil
+		},
+	}
+
+	reverseCmd.Flags().StringVarP(&inputPath, "input", "i", "", "Path to a JSON array of target records (required)")
+	reverseCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Path to the output file (optional, defaults to standard output)")
+	reverseCmd.Flags().StringVar(&signInPath, "sign-ins", "", "Path to the signin.json of the target records (optional, defaults to the signin.json next to a users.json input)")
+
+	return reverseCmd
+}
+
+// anyHolds reports whether any record holds a top-level field.
+func anyHolds(records []map[string]interface{}, field string) bool {
+	for _, record := range records {
+		if _, exists := record[field]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// fileExists reports whether a file exists at path.
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
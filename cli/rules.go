@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"pathid_assignment/configs"
+	"pathid_assignment/pkg/models"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/schema"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultRulesPath = "configs/default_mapping_config.json"
+
+const defaultSchema = "default"
+
+// newRulesCommand defines the "rules" command group for inspecting and validating rules files.
+func newRulesCommand(opts *options) *cobra.Command {
+	rulesCmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Inspect and validate rules files",
+	}
+
+	rulesCmd.AddCommand(&cobra.Command{
+		Use:   "validate [rules file]",
+		Short: "Validate a rules file, reporting every issue with its line and column",
+		Args:  positional(cobra.MaximumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := opts.rulesPath
+			if len(args) > 0 {
+				path = args[0]
+			}
+
+			ruleSet, err := loadRules(path, opts.logger)
+			if err != nil {
+				return badInput(err)
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Rules are valid: %d target fields\n", len(ruleSet.Targets()))
+			return nil
+		},
+	})
+
+	rulesCmd.AddCommand(&cobra.Command{
+		Use:   "show-default",
+		Short: "Print the built-in default rules",
+		Args:  positional(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := cmd.OutOrStdout().Write(configs.DefaultMapping)
+			return err
+		},
+	})
+
+	rulesCmd.AddCommand(&cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of the rules file format",
+		Args:  positional(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, err := cmd.OutOrStdout().Write(rules.Schema())
+			return err
+		},
+	})
+
+	return rulesCmd
+}
+
+// loadRules loads the given rules file. Without a path it loads the default rules file,
+// or the built-in default rules when that file is not found relative to the working directory.
+func loadRules(path string, logger *slog.Logger) (*rules.Rules, error) {
+	ruleSet, _, err := loadRulesSource(path, logger)
+	return ruleSet, err
+}
+
+// loadRulesSource loads rules like loadRules, also returning the file they were loaded from, or ""
+// for the built-in default rules.
+func loadRulesSource(path string, logger *slog.Logger) (*rules.Rules, string, error) {
+	if path == "" {
+		if _, err := os.Stat(defaultRulesPath); err != nil {
+			logger.Warn("default rules file not found, using the built-in default rules", "path", defaultRulesPath)
+			ruleSet, err := rules.Default()
+			return ruleSet, "", err
+		}
+		path = defaultRulesPath
+	}
+	ruleSet, err := rules.Load(path)
+	return ruleSet, path, err
+}
+
+// loadSchema loads the given JSON Schema file, or generates the schema of models.DefaultStructure for "default".
+func loadSchema(path string) (*schema.Schema, error) {
+	if path == defaultSchema {
+		return schema.For[models.DefaultStructure]()
+	}
+	return schema.Load(path)
+}
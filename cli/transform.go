@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"pathid_assignment/pkg/analytics"
+	"pathid_assignment/pkg/discovery"
+	"pathid_assignment/pkg/envelope"
+	"pathid_assignment/pkg/merge"
+	"pathid_assignment/pkg/preview"
+	"pathid_assignment/pkg/privacy"
+	"pathid_assignment/pkg/processor"
+	"pathid_assignment/pkg/progress"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/storage"
+	"pathid_assignment/pkg/transformer"
+	"pathid_assignment/pkg/unmarshaller"
+
+	"github.com/spf13/cobra"
+)
+
+const defaultOutputPath = "data/output"
+
+// runDirectoryFormat names the timestamped subdirectories runs are written to.
+const runDirectoryFormat = "20060102T150405Z"
+
+// newTransformCommand defines the "transform" command, which runs the transformation and stores its outputs.
+func newTransformCommand(opts *options) *cobra.Command {
+	var inputPaths []string
+	var outputPath, schemaPath, mergeStrategy, statePath, encryptKeyPath, privacyKeyPath, progressMode string
+	var workers int
+	var strict, withAnalytics, timestamped, clean, overwrite, failIfExists, dryRun bool
+	var inputs discovery.Options
+	var sample preview.Options
+	thresholds := analytics.DefaultThresholds
+	analyticsFields := analytics.DefaultFields
+
+	transformCmd := &cobra.Command{
+		Use:     "transform",
+		Aliases: []string{"read"},
+		Short:   "Transform input files and store the users and their sign-in activities",
+		Long: "Transform input files and store the users and their sign-in activities.\n" +
+			"Exits with 2 on invalid flags, rules or inputs, 3 when some records or files failed,\n" +
+			"and 1 when the run failed or no record could be transformed.",
+		Args: positional(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Streaming the outputs to standard output leaves it to them, and moves messages to standard error.
+			streaming := outputPath == "-"
+			out := cmd.OutOrStdout()
+			if streaming {
+				out = cmd.ErrOrStderr()
+			}
+			if err := requireFlags(cmd, "input"); err != nil {
+				return err
+			}
+
+			// Use default rules file if none provided, falling back to the built-in rules.
+			ruleSet, source, err := loadRulesSource(opts.rulesPath, opts.logger)
+			if err != nil {
+				return badInput(fmt.Errorf("loading rules: %w", err))
+			}
+			switch {
+			case opts.rulesPath != "":
+			case source == "":
+				fmt.Fprintln(out, "No rules file specified. Using the built-in default rules")
+			default:
+				fmt.Fprintln(out, "No rules file specified. Using default rules file:", source)
+			}
+			if privacyKeyPath != "" {
+				ruleSet.Privacy = privacy.KeySpec{File: privacyKeyPath}
+			}
+
+			store := opts.newStorage()
+			if encryptKeyPath != "" {
+				if store.Encryption, err = loadEncryption(encryptKeyPath, ruleSet); err != nil {
+					return badInput(err)
+				}
+			}
+
+			proc := processor.NewProcessor(
+				transformer.NewKeywordTransformer(),
+				unmarshaller.NewJSONUnmarshaller(),
+				store,
+			)
+			if schemaPath != "" {
+				if proc.Schema, err = loadSchema(schemaPath); err != nil {
+					return badInput(fmt.Errorf("loading schema: %w", err))
+				}
+				proc.Strict = strict
+			}
+			if proc.Merge, err = merge.ParseStrategy(mergeStrategy); err != nil {
+				return badInput(err)
+			}
+			proc.StatePath = statePath
+			if withAnalytics {
+				proc.Analytics = &analytics.Analyzer{Thresholds: thresholds, Fields: analyticsFields}
+			}
+			proc.Discovery = inputs
+			proc.Stdin = cmd.InOrStdin()
+			proc.Workers = workers
+			proc.Logger, proc.RunID = opts.logger, opts.runID
+			showProgress, err := progressEnabled(progressMode, cmd.ErrOrStderr())
+			if err != nil {
+				return badInput(err)
+			}
+			if showProgress && !dryRun {
+				proc.Progress = progress.NewTerminal(cmd.ErrOrStderr()).Update
+			}
+
+			// A dry run only previews a sample of the records, without writing anything.
+			if dryRun {
+				result, err := proc.Preview(inputPaths, ruleSet, sample)
+				if err != nil {
+					return err
+				}
+				return result.WriteText(cmd.OutOrStdout())
+			}
+
+			// Use default output directory path if none provided
+			if outputPath == "" {
+				fmt.Fprintln(out, "No output directory path specified. Using default path:", defaultOutputPath)
+				outputPath = defaultOutputPath
+			}
+			mode, err := existingMode(clean, overwrite, failIfExists)
+			if err != nil {
+				return err
+			}
+			// A timestamped run directory is always new, so there is nothing for these flags to handle.
+			if timestamped && (clean || overwrite || failIfExists) {
+				return badInput(errors.New("--clean, --overwrite and --fail-if-exists apply to the output directory itself, and need --timestamped=false"))
+			}
+			var created string
+			if streaming {
+				// Entries reach standard output as they are stored, through a buffer flushed at the end.
+				stream := bufio.NewWriter(cmd.OutOrStdout())
+				defer stream.Flush()
+				store.Stream = stream
+			} else {
+				if timestamped {
+					outputPath = filepath.Join(outputPath, time.Now().UTC().Format(runDirectoryFormat))
+				}
+				if created, err = prepareOutputDirectory(out, outputPath, mode); err != nil {
+					return err
+				}
+				fmt.Fprintln(out, "Writing outputs to", outputPath)
+			}
+			fmt.Fprintf(out, "Starting processing (run %s)...\n", opts.runID)
+
+			summary, err := proc.ProcessRules(inputPaths, ruleSet, outputPath)
+			var inputErr *processor.InputError
+			if errors.As(err, &inputErr) {
+				if !streaming {
+					removeCreated(outputPath, created)
+				}
+				return err
+			}
+			// The manifest also lists the files of failed runs, so --clean can remove them.
+			if manifestErr := store.SaveManifest(outputPath); manifestErr != nil && err == nil {
+				err = fmt.Errorf("saving manifest: %w", manifestErr)
+			}
+			fmt.Fprintf(out, "Processed %d records from %d files: %d transformed, %d filtered out, %d failed, %d rejected\n",
+				summary.Records, summary.Files, summary.Transformed, summary.Filtered, summary.Failed, summary.Rejected)
+			if statsErr := progress.WriteText(out, summary.Stats, summary.FileStats); statsErr != nil && err == nil {
+				err = statsErr
+			}
+			if err != nil {
+				return err
+			}
+			if statePath != "" {
+				fmt.Fprintf(out, "Incremental run: %d added, %d changed, %d deleted, %d unchanged\n",
+					summary.Added, summary.Changed, summary.Deleted, summary.Unchanged)
+				if !summary.StateSaved {
+					fmt.Fprintln(out, "Some files or records failed: no user was deleted and the state file was left unchanged")
+				}
+			}
+			see := func(fileType string) string {
+				if streaming {
+					return fmt.Sprintf("the %q lines of the output", storage.StreamKey(fileType))
+				}
+				return storage.GenerateFilePath(outputPath, fileType)
+			}
+			if withAnalytics {
+				fmt.Fprintf(out, "Found %d enabled but stale accounts (see %s)\n", summary.Stale, see("staleAccounts"))
+				fmt.Fprintf(out, "Found %d accounts that never signed in (see %s)\n", summary.NeverSignedIn, see("signInAnalytics"))
+			}
+			if summary.Duplicates > 0 {
+				fmt.Fprintf(out, "Merged %d duplicate records by id, %d conflicting fields (see %s)\n",
+					summary.Duplicates, summary.Conflicts, see("conflicts"))
+			}
+			if err := outcome(summary); err != nil {
+				return err
+			}
+			fmt.Fprintln(out, "Processing completed successfully!")
+			return nil
+		},
+	}
+
+	// Define command flags
+	transformCmd.Flags().StringArrayVarP(&inputPaths, "input", "i", nil, `Path to an input file or directory, a glob pattern, or "-" for standard input; repeatable (required)`)
+	addDiscoveryFlags(transformCmd, &inputs)
+	transformCmd.Flags().StringVarP(&outputPath, "output", "o", "", `Path to output directory, or "-" to stream the outputs to standard output as JSON lines, one per record (optional), default to data/output`)
+	transformCmd.Flags().BoolVar(&timestamped, "timestamped", true, "Write the run into a new subdirectory of the output directory named after its UTC start time")
+	transformCmd.Flags().BoolVar(&clean, "clean", false, "Delete the files a previous run wrote into the output directory, as listed by its manifest, keeping any other file; needs --timestamped=false")
+	transformCmd.Flags().BoolVar(&overwrite, "overwrite", false, "Write into a non-empty output directory, replacing the files of a previous run; needs --timestamped=false")
+	transformCmd.Flags().BoolVar(&failIfExists, "fail-if-exists", false, "Fail when the output directory is not empty (the default); needs --timestamped=false")
+
+	transformCmd.Flags().StringVarP(&schemaPath, "schema", "s", "", `Path to a JSON Schema validating transformed records, or "default" for the schema of the default structure (optional)`)
+	transformCmd.Flags().BoolVar(&strict, "strict", false, "Fail the run when any record violates the schema, instead of only writing it to rejects.json")
+
+	transformCmd.Flags().StringVarP(&mergeStrategy, "merge", "m", string(merge.First), "How records sharing the same id are merged: none, first, last, newest (by lastModifiedDateTime) or fields")
+
+	transformCmd.Flags().StringVar(&statePath, "state", "", "Path to a state file making the run incremental: only added, changed and deleted users are written (optional)")
+
+	transformCmd.Flags().BoolVar(&withAnalytics, "analytics", false, "Derive sign-in analytics per user into sign_in_analytics.json and write the stale_accounts.json report")
+	transformCmd.Flags().IntVar(&thresholds.Interactive, "stale-interactive-days", thresholds.Interactive, "Days without an interactive sign-in after which an enabled account is stale (0 ignores them)")
+	transformCmd.Flags().IntVar(&thresholds.NonInteractive, "stale-non-interactive-days", thresholds.NonInteractive, "Days without a non-interactive sign-in after which an enabled account is stale (0 ignores them)")
+	transformCmd.Flags().IntVar(&thresholds.Successful, "stale-successful-days", thresholds.Successful, "Days without a successful sign-in after which an enabled account is stale (0 ignores them)")
+	transformCmd.Flags().StringVar(&analyticsFields.Enabled, "enabled-field", analyticsFields.Enabled, "Target field telling whether an account is enabled")
+	transformCmd.Flags().StringVar(&analyticsFields.Interactive, "interactive-sign-in-field", analyticsFields.Interactive, "Target field of the last interactive sign-in, read by --analytics")
+	transformCmd.Flags().StringVar(&analyticsFields.NonInteractive, "non-interactive-sign-in-field", analyticsFields.NonInteractive, "Target field of the last non-interactive sign-in, read by --analytics")
+	transformCmd.Flags().StringVar(&analyticsFields.Successful, "successful-sign-in-field", analyticsFields.Successful, "Target field of the last successful sign-in, read by --analytics")
+	transformCmd.Flags().StringVar(&analyticsFields.ID, "id-field", analyticsFields.ID, "Target field of the user id, copied into the analytics reports")
+	transformCmd.Flags().StringVar(&analyticsFields.ExternalID, "external-id-field", analyticsFields.ExternalID, "Target field of the external id, copied into stale_accounts.json")
+	transformCmd.Flags().StringVar(&analyticsFields.Mail, "mail-field", analyticsFields.Mail, "Target field of the mail address, copied into stale_accounts.json")
+	transformCmd.Flags().StringVar(&analyticsFields.FirstName, "first-name-field", analyticsFields.FirstName, "Target field of the first name, copied into the display name of stale_accounts.json")
+	transformCmd.Flags().StringVar(&analyticsFields.LastName, "last-name-field", analyticsFields.LastName, "Target field of the last name, copied into the display name of stale_accounts.json")
+
+	transformCmd.Flags().StringVar(&encryptKeyPath, "encrypt-key-file", "", "Path to a master key file: fields marked with $encrypt are stored encrypted with a data key it wraps (optional)")
+	transformCmd.Flags().StringVar(&privacyKeyPath, "privacy-key-file", "", "Path to the key of hmac and tokenize protections, overriding the $privacy key of the rules (optional)")
+	transformCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Transform a sample of every input file and print it next to its source with the field coverage, without writing anything")
+	transformCmd.Flags().IntVar(&sample.Size, "sample", preview.DefaultSize, "Records sampled per input file by --dry-run")
+	transformCmd.Flags().BoolVar(&sample.Random, "sample-random", false, "Sample records at random instead of the first ones of every file")
+	transformCmd.Flags().Int64Var(&sample.Seed, "seed", 0, "Seed of random samples, to repeat a dry run (0 picks one, printed with the preview)")
+	transformCmd.Flags().IntVar(&workers, "workers", 0, "How many files and records are processed concurrently (0 uses the number of CPUs)")
+	transformCmd.Flags().StringVar(&progressMode, "progress", "auto", "Show the progress of the run on standard error: auto (when it is a terminal), always or never")
+
+	return transformCmd
+}
+
+// progressEnabled tells whether the progress of a run is shown on w for the given --progress mode.
+func progressEnabled(mode string, w io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		file, ok := w.(*os.File)
+		if !ok {
+			return false, nil
+		}
+		info, err := file.Stat()
+		return err == nil && info.Mode()&os.ModeCharDevice != 0, nil
+	}
+	return false, fmt.Errorf("unknown progress mode %q, expected auto, always or never", mode)
+}
+
+// loadEncryption prepares the encryption of the fields marked with "$encrypt", with a data key wrapped
+// by the master key file.
+func loadEncryption(keyPath string, ruleSet *rules.Rules) (*storage.Encryption, error) {
+	fields := ruleSet.Encrypted()
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no field rule is marked with %s, nothing would be encrypted", rules.EncryptDirective)
+	}
+
+	kms, err := envelope.LoadFileKMS(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	sealer, err := envelope.NewSealer(kms)
+	if err != nil {
+		return nil, err
+	}
+	return &storage.Encryption{Sealer: sealer, Fields: fields}, nil
+}
+
+// prepareOutputDirectory creates the output directory when missing, returning the topmost directory it
+// created, and otherwise handles its existing files according to the mode.
+func prepareOutputDirectory(out io.Writer, dir string, mode string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		created := dir
+		for parent := filepath.Dir(created); parent != created; parent = filepath.Dir(created) {
+			if _, err := os.Stat(parent); err == nil {
+				break
+			}
+			created = parent
+		}
+		return created, os.MkdirAll(dir, 0755)
+	}
+	if err != nil {
+		return "", badInput(fmt.Errorf("reading output directory: %w", err))
+	}
+	if len(entries) == 0 {
+		return "", nil
+	}
+
+	switch mode {
+	case "overwrite":
+		return "", nil
+	case "clean":
+		// Files of the same name as the outputs that no run wrote would be replaced, so they are refused
+		// before anything is deleted.
+		manifest, err := storage.LoadManifest(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", badInput(fmt.Errorf("cleaning output directory: %w", err))
+		}
+		listed := make(map[string]bool)
+		if manifest != nil {
+			listed[filepath.Base(storage.GenerateFilePath(dir, "manifest"))] = true
+			for _, file := range manifest.Files {
+				listed[filepath.Base(file)] = true
+			}
+		}
+		var clashing []string
+		for _, entry := range entries {
+			if storage.IsOutputFile(entry.Name()) && !listed[entry.Name()] {
+				clashing = append(clashing, entry.Name())
+			}
+		}
+		if len(clashing) > 0 {
+			return "", badInput(fmt.Errorf("output directory %s holds %s not written by a previous run, which the run would replace: move them or use --overwrite",
+				dir, strings.Join(clashing, ", ")))
+		}
+
+		kept, err := storage.CleanDirectory(dir)
+		if err != nil {
+			return "", fmt.Errorf("cleaning output directory: %w", err)
+		}
+		if len(kept) > 0 {
+			fmt.Fprintf(out, "Kept %d entries of %s not written by a previous run: %s\n", len(kept), dir, strings.Join(kept, ", "))
+		}
+		return "", nil
+	}
+	return "", badInput(fmt.Errorf("output directory %s is not empty, use --clean or --overwrite to write into it", dir))
+}
+
+// removeCreated removes the directories prepareOutputDirectory created for dir, from dir up to created,
+// as long as they were left empty. Directories that already existed are never removed.
+func removeCreated(dir, created string) {
+	if created == "" {
+		return
+	}
+	for {
+		if os.Remove(dir) != nil || dir == created {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// existingMode returns how a non-empty output directory is handled: "fail" unless --clean or --overwrite is given.
+func existingMode(clean, overwrite, failIfExists bool) (string, error) {
+	mode, given := "fail", 0
+	for flag, set := range map[string]bool{"clean": clean, "overwrite": overwrite, "fail": failIfExists} {
+		if set {
+			mode = flag
+			given++
+		}
+	}
+	if given > 1 {
+		return "", badInput(errors.New("only one of --clean, --overwrite and --fail-if-exists can be given"))
+	}
+	return mode, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"pathid_assignment/pkg/discovery"
+	"pathid_assignment/pkg/privacy"
+	"pathid_assignment/pkg/processor"
+	"pathid_assignment/pkg/schema"
+	"pathid_assignment/pkg/storage"
+	"pathid_assignment/pkg/transformer"
+	"pathid_assignment/pkg/unmarshaller"
+
+	"github.com/spf13/cobra"
+)
+
+// newValidateCommand defines the "validate" command, which checks inputs against the rules and an optional
+// schema without storing anything.
+func newValidateCommand(opts *options) *cobra.Command {
+	var schemaPath, privacyKeyPath string
+	var workers int
+	var inputs discovery.Options
+
+	validateCmd := &cobra.Command{
+		Use:   "validate <input>...",
+		Short: "Check that input files transform with the rules, and that the results satisfy a schema",
+		Long: "Transform input files, directories, glob patterns or standard input (\"-\") without storing the results,\n" +
+			"reporting records that fail to transform and, with --schema, every schema violation. Exits like transform.",
+		Args: positional(cobra.MinimumNArgs(1)),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ruleSet, err := loadRules(opts.rulesPath, opts.logger)
+			if err != nil {
+				return badInput(fmt.Errorf("loading rules: %w", err))
+			}
+			if privacyKeyPath != "" {
+				ruleSet.Privacy = privacy.KeySpec{File: privacyKeyPath}
+			}
+
+			proc := processor.NewProcessor(
+				transformer.NewKeywordTransformer(),
+				unmarshaller.NewJSONUnmarshaller(),
+				opts.newStorage(),
+			)
+			proc.Discovery = inputs
+			proc.Stdin = cmd.InOrStdin()
+			proc.Workers = workers
+			proc.Logger, proc.RunID = opts.logger, opts.runID
+			if schemaPath != "" {
+				if proc.Schema, err = loadSchema(schemaPath); err != nil {
+					return badInput(fmt.Errorf("loading schema: %w", err))
+				}
+			}
+
+			dir, err := os.MkdirTemp("", "transformer-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(dir)
+
+			summary, err := proc.ProcessRules(args, ruleSet, dir)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if summary.Rejected > 0 {
+				if err := printRejects(out, storage.GenerateFilePath(dir, "rejects")); err != nil {
+					return err
+				}
+			}
+			fmt.Fprintf(out, "Validated %d records from %d files: %d valid, %d filtered out, %d failed, %d rejected\n",
+				summary.Records, summary.Files, summary.Transformed, summary.Filtered, summary.Failed, summary.Rejected)
+			return outcome(summary)
+		},
+	}
+
+	addDiscoveryFlags(validateCmd, &inputs)
+	validateCmd.Flags().StringVarP(&schemaPath, "schema", "s", "", `Path to a JSON Schema validating transformed records, or "default" for the schema of the default structure (optional)`)
+	validateCmd.Flags().StringVar(&privacyKeyPath, "privacy-key-file", "", "Path to the key of hmac and tokenize protections, overriding the $privacy key of the rules (optional)")
+	validateCmd.Flags().IntVar(&workers, "workers", 0, "How many files and records are processed concurrently (0 uses the number of CPUs)")
+
+	return validateCmd
+}
+
+// printRejects prints the schema violations of the records in a rejects file, one per line.
+func printRejects(w io.Writer, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var rejects []struct {
+		Record     map[string]interface{} `json:"record"`
+		Violations []schema.Violation     `json:"violations"`
+	}
+	if err := json.Unmarshal(data, &rejects); err != nil {
+		return err
+	}
+
+	for _, reject := range rejects {
+		for _, violation := range reject.Violations {
+			fmt.Fprintf(w, "%v: %s\n", reject.Record["id"], violation)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+// Package configs embeds the configuration files shipped with the tool, so it works from any directory.
+package configs
+
+import _ "embed"
+
+// DefaultMapping is the default rules file, configs/default_mapping_config.json, embedded into the binary.
+//
+//go:embed default_mapping_config.json
+var DefaultMapping []byte
@@ -0,0 +1,264 @@
+package analytics
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/utils"
+)
+
+// Fields name the target fields the analytics read from transformed users, as dot-separated paths.
+type Fields struct {
+	Enabled        string `json:"enabled"`
+	Interactive    string `json:"interactive"`
+	NonInteractive string `json:"non_interactive"`
+	Successful     string `json:"successful"`
+
+	// Fields copied into the reports to identify accounts.
+	ID         string `json:"id"`
+	ExternalID string `json:"external_id"`
+	Mail       string `json:"mail"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+}
+
+// DefaultFields are the fields named by the default rules.
+var DefaultFields = Fields{
+	Enabled:        "is_enabled",
+	Interactive:    "sign_in_activity.lastSignInDateTime",
+	NonInteractive: "sign_in_activity.lastNonInteractiveSignInDateTime",
+	Successful:     "sign_in_activity.lastSuccessfulSignInDateTime",
+	ID:             "id",
+	ExternalID:     "external_id",
+	Mail:           "mail",
+	FirstName:      "first_name",
+	LastName:       "last_name",
+}
+
+// WithDefaults returns the fields with empty names replaced by those of DefaultFields.
+func (f Fields) WithDefaults() Fields {
+	for _, field := range []struct {
+		name *string
+		def  string
+	}{
+		{&f.Enabled, DefaultFields.Enabled},
+		{&f.Interactive, DefaultFields.Interactive},
+		{&f.NonInteractive, DefaultFields.NonInteractive},
+		{&f.Successful, DefaultFields.Successful},
+		{&f.ID, DefaultFields.ID},
+		{&f.ExternalID, DefaultFields.ExternalID},
+		{&f.Mail, DefaultFields.Mail},
+		{&f.FirstName, DefaultFields.FirstName},
+		{&f.LastName, DefaultFields.LastName},
+	} {
+		if *field.name == "" {
+			*field.name = field.def
+		}
+	}
+	return f
+}
+
+// Thresholds are the number of days without a kind of sign-in after which an enabled account is stale.
+// A zero threshold leaves that kind of sign-in out of the decision.
+type Thresholds struct {
+	Interactive    int `json:"interactive_days"`
+	NonInteractive int `json:"non_interactive_days"`
+	Successful     int `json:"successful_days"`
+}
+
+// DefaultThresholds consider accounts stale after 90 days without interactive and non-interactive sign-ins.
+var DefaultThresholds = Thresholds{Interactive: 90, NonInteractive: 90}
+
+// SignInAnalytics holds the fields derived from a user's sign-in activity. Day counts are nil when
+// the user never signed in that way.
+type SignInAnalytics struct {
+	DaysSinceSignIn               *int `json:"days_since_sign_in"`
+	DaysSinceNonInteractiveSignIn *int `json:"days_since_non_interactive_sign_in"`
+	DaysSinceSuccessfulSignIn     *int `json:"days_since_successful_sign_in"`
+	NeverSignedIn                 bool `json:"never_signed_in"`
+	EnabledButStale               bool `json:"enabled_but_stale"`
+}
+
+// UserAnalytics is an entry of the sign-in analytics report, which holds every user, including disabled
+// accounts that never signed in.
+type UserAnalytics struct {
+	ID interface{} `json:"id"`
+	SignInAnalytics
+}
+
+// StaleAccount is an entry of the stale accounts report.
+type StaleAccount struct {
+	ID          interface{} `json:"id"`
+	ExternalID  interface{} `json:"external_id,omitempty"`
+	Mail        interface{} `json:"mail,omitempty"`
+	DisplayName string      `json:"display_name,omitempty"`
+	SignInAnalytics
+}
+
+// Analyzer derives sign-in analytics from transformed users.
+type Analyzer struct {
+	Thresholds Thresholds
+	Fields     Fields    // Fields read from users; empty names are those of DefaultFields.
+	Now        time.Time // Reference time of the day counts; the zero value is the time of the call.
+}
+
+// fields returns the fields read from users, defaulting empty names.
+func (a *Analyzer) fields() Fields {
+	return a.Fields.WithDefaults()
+}
+
+// Check verifies that the rules produce the fields the analytics need in plain text: whether accounts are
+// enabled, and every kind of sign-in with a threshold. Without them, no account would ever be found stale.
+// Fields sealed with $encrypt are only unreadable when the run encrypts them.
+func (a *Analyzer) Check(ruleSet *rules.Rules, encrypted bool) error {
+	fields := a.fields()
+	required := []struct {
+		path      string
+		threshold int
+	}{
+		{fields.Enabled, 1},
+		{fields.Interactive, a.Thresholds.Interactive},
+		{fields.NonInteractive, a.Thresholds.NonInteractive},
+		{fields.Successful, a.Thresholds.Successful},
+	}
+
+	var errs []error
+	for _, field := range required {
+		if field.threshold <= 0 {
+			continue
+		}
+		rule := ruleSet.Field(field.path)
+		switch {
+		case rule == nil:
+			errs = append(errs, fmt.Errorf("analytics field %q is not produced by the rules", field.path))
+		case rule.Sealed() != "" && (encrypted || rule.Sealed() != rules.EncryptDirective):
+			errs = append(errs, fmt.Errorf("analytics field %q cannot be read, it is sealed with %s", field.path, rule.Sealed()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Analyze derives the sign-in analytics of a user. Unparsable timestamps count as missing.
+func (a *Analyzer) Analyze(user map[string]interface{}) SignInAnalytics {
+	return a.analyze(user, a.reference())
+}
+
+// reference returns the time day counts are computed at.
+func (a *Analyzer) reference() time.Time {
+	if a.Now.IsZero() {
+		return time.Now()
+	}
+	return a.Now
+}
+
+func (a *Analyzer) analyze(user map[string]interface{}, now time.Time) SignInAnalytics {
+	fields := a.fields()
+	result := SignInAnalytics{
+		DaysSinceSignIn:               daysSince(lookup(user, fields.Interactive), now),
+		DaysSinceNonInteractiveSignIn: daysSince(lookup(user, fields.NonInteractive), now),
+		DaysSinceSuccessfulSignIn:     daysSince(lookup(user, fields.Successful), now),
+	}
+	result.NeverSignedIn = result.DaysSinceSignIn == nil && result.DaysSinceNonInteractiveSignIn == nil && result.DaysSinceSuccessfulSignIn == nil
+
+	enabled, _ := lookup(user, fields.Enabled).(bool)
+	result.EnabledButStale = enabled &&
+		exceeds(result.DaysSinceSignIn, a.Thresholds.Interactive) &&
+		exceeds(result.DaysSinceNonInteractiveSignIn, a.Thresholds.NonInteractive) &&
+		exceeds(result.DaysSinceSuccessfulSignIn, a.Thresholds.Successful)
+	return result
+}
+
+// Users returns the sign-in analytics of every user, in the order of the users.
+func (a *Analyzer) Users(users []map[string]interface{}) []UserAnalytics {
+	now := a.reference()
+	fields := a.fields()
+	results := make([]UserAnalytics, 0, len(users))
+	for _, user := range users {
+		results = append(results, UserAnalytics{ID: lookup(user, fields.ID), SignInAnalytics: a.analyze(user, now)})
+	}
+	return results
+}
+
+// Stale returns the report of enabled but stale accounts: accounts that never signed in first, then by
+// days since their last sign-in. Users are left unchanged, since day counts change every day and would
+// make every user look modified to diffs and incremental runs.
+func (a *Analyzer) Stale(users []map[string]interface{}) []StaleAccount {
+	now := a.reference()
+	fields := a.fields()
+	stale := []StaleAccount{}
+	for _, user := range users {
+		result := a.analyze(user, now)
+		if !result.EnabledButStale {
+			continue
+		}
+
+		account := StaleAccount{
+			ID:              lookup(user, fields.ID),
+			ExternalID:      lookup(user, fields.ExternalID),
+			Mail:            lookup(user, fields.Mail),
+			SignInAnalytics: result,
+		}
+		firstName, _ := lookup(user, fields.FirstName).(string)
+		lastName, _ := lookup(user, fields.LastName).(string)
+		account.DisplayName = strings.TrimSpace(firstName + " " + lastName)
+		stale = append(stale, account)
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		a, b := lastSignIn(stale[i].SignInAnalytics), lastSignIn(stale[j].SignInAnalytics)
+		switch {
+		case a == nil || b == nil:
+			return a == nil && b != nil
+		default:
+			return *a > *b
+		}
+	})
+	return stale
+}
+
+// lastSignIn returns the days since the most recent sign-in of any kind.
+func lastSignIn(result SignInAnalytics) *int {
+	var last *int
+	for _, days := range []*int{result.DaysSinceSignIn, result.DaysSinceNonInteractiveSignIn, result.DaysSinceSuccessfulSignIn} {
+		if days != nil && (last == nil || *days < *last) {
+			last = days
+		}
+	}
+	return last
+}
+
+// lookup returns the value at a dot-separated path of a user, nil when missing.
+func lookup(user map[string]interface{}, path string) interface{} {
+	var value interface{} = user
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// exceeds reports whether a day count is over a threshold; missing counts exceed any threshold.
+func exceeds(days *int, threshold int) bool {
+	return threshold <= 0 || days == nil || *days > threshold
+}
+
+// daysSince returns the number of whole days elapsed since a timestamp, or nil when it is missing.
+func daysSince(value interface{}, now time.Time) *int {
+	s, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	t, err := utils.ParseTimestamp(s)
+	if err != nil {
+		return nil
+	}
+	days := int(now.Sub(t).Hours() / 24)
+	return &days
+}
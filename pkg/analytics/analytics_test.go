@@ -0,0 +1,141 @@
+package analytics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pathid_assignment/pkg/analytics"
+	"pathid_assignment/pkg/rules"
+)
+
+func TestAnalyzer_Stale(t *testing.T) {
+	analyzer := &analytics.Analyzer{
+		Thresholds: analytics.DefaultThresholds,
+		Now:        time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	users := []map[string]interface{}{
+		{
+			"id": "active", "is_enabled": true,
+			"sign_in_activity": map[string]interface{}{
+				"lastSignInDateTime":               "2024-05-30T12:00:00",
+				"lastNonInteractiveSignInDateTime": "2024-01-01T00:00:00",
+			},
+		},
+		{
+			"id": "stale", "is_enabled": true, "first_name": "Ann", "last_name": "Lee",
+			"sign_in_activity": map[string]interface{}{
+				"lastSignInDateTime":               "2024-01-01T00:00:00",
+				"lastNonInteractiveSignInDateTime": "2024-02-01T00:00:00",
+			},
+		},
+		{"id": "never", "is_enabled": true},
+		{"id": "disabled", "is_enabled": false},
+	}
+
+	stale := analyzer.Stale(users)
+	if len(stale) != 2 || stale[0].ID != "never" || stale[1].ID != "stale" {
+		t.Fatalf("Expected the never-signed-in account before the stale one, got %+v", stale)
+	}
+	if stale[1].DisplayName != "Ann Lee" {
+		t.Errorf("Expected display name 'Ann Lee', got %q", stale[1].DisplayName)
+	}
+
+	if len(users[0]) != 3 {
+		t.Errorf("Expected users to be left unchanged, got %v", users[0])
+	}
+
+	active := analyzer.Analyze(users[0])
+	if active.DaysSinceSignIn == nil || *active.DaysSinceSignIn != 2 || active.EnabledButStale || active.NeverSignedIn {
+		t.Errorf("Unexpected analytics for an active user: %+v", active)
+	}
+	if never := analyzer.Analyze(users[2]); !never.NeverSignedIn || never.DaysSinceSuccessfulSignIn != nil {
+		t.Errorf("Unexpected analytics for a user who never signed in: %+v", never)
+	}
+	if disabled := analyzer.Analyze(users[3]); disabled.EnabledButStale {
+		t.Errorf("Expected a disabled account not to be reported as stale")
+	}
+
+	// Every user has analytics, including disabled accounts that never signed in.
+	all := analyzer.Users(users)
+	if len(all) != 4 || all[3].ID != "disabled" || !all[3].NeverSignedIn || all[0].NeverSignedIn {
+		t.Errorf("Expected the analytics of every user, got %+v", all)
+	}
+}
+
+func TestAnalyzer_Fields(t *testing.T) {
+	ruleSet, err := rules.Parse([]byte(`{
+		"id": "id",
+		"active": "accountEnabled",
+		"last_login": {"$path": "signInActivity.lastSignInDateTime", "$protect": "redact"},
+		"activity": {"background": {"$path": "signInActivity.lastNonInteractiveSignInDateTime", "$encrypt": true}},
+		"contact": {"email": "mail", "given": "givenName", "family": "surname"}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	// The default fields are not produced by custom rules.
+	analyzer := &analytics.Analyzer{Thresholds: analytics.DefaultThresholds}
+	if err := analyzer.Check(ruleSet, false); err == nil || !strings.Contains(err.Error(), `"is_enabled" is not produced`) {
+		t.Errorf("Expected the missing default fields to be reported, got %v", err)
+	}
+
+	analyzer.Fields = analytics.Fields{Enabled: "active", Interactive: "last_login", NonInteractive: "activity.background"}
+	if err := analyzer.Check(ruleSet, false); err == nil || !strings.Contains(err.Error(), `"last_login" cannot be read, it is sealed with $protect redact`) {
+		t.Errorf("Expected the redacted sign-in to be reported, got %v", err)
+	}
+
+	analyzer.Thresholds.Interactive = 0
+	if err := analyzer.Check(ruleSet, false); err != nil {
+		t.Fatalf("Expected the fields to be found, got %v", err)
+	}
+	if err := analyzer.Check(ruleSet, true); err == nil || !strings.Contains(err.Error(), `"activity.background" cannot be read, it is sealed with $encrypt`) {
+		t.Errorf("Expected the encrypted sign-in to be reported when encrypting, got %v", err)
+	}
+	analyzer.Now = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	result := analyzer.Analyze(map[string]interface{}{"active": true, "activity": map[string]interface{}{"background": "2024-01-01T00:00:00"}})
+	if !result.EnabledButStale || result.DaysSinceNonInteractiveSignIn == nil || *result.DaysSinceNonInteractiveSignIn != 152 {
+		t.Errorf("Expected the custom fields to be read, got %+v", result)
+	}
+
+	// Stale accounts are identified by the configured fields.
+	analyzer.Fields.ExternalID = "id"
+	analyzer.Fields.Mail = "contact.email"
+	analyzer.Fields.FirstName = "contact.given"
+	analyzer.Fields.LastName = "contact.family"
+	stale := analyzer.Stale([]map[string]interface{}{{
+		"id": "user-a", "active": true,
+		"contact": map[string]interface{}{"email": "ann@example.com", "given": "Ann", "family": "Lee"},
+	}})
+	if len(stale) != 1 || stale[0].ExternalID != "user-a" || stale[0].Mail != "ann@example.com" || stale[0].DisplayName != "Ann Lee" {
+		t.Errorf("Expected the configured fields to be copied, got %+v", stale)
+	}
+}
+
+func TestAnalyzer_Thresholds(t *testing.T) {
+	user := map[string]interface{}{
+		"is_enabled": true,
+		"sign_in_activity": map[string]interface{}{
+			"lastSignInDateTime":           "2024-05-01T00:00:00",
+			"lastSuccessfulSignInDateTime": "2024-01-01T00:00:00",
+		},
+	}
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	lenient := &analytics.Analyzer{Thresholds: analytics.Thresholds{Interactive: 60}, Now: now}
+	if lenient.Analyze(user).EnabledButStale {
+		t.Errorf("Expected a sign-in 31 days ago not to be stale after 60 days")
+	}
+
+	strict := &analytics.Analyzer{Thresholds: analytics.Thresholds{Interactive: 60, Successful: 90}, Now: now}
+	if strict.Analyze(user).EnabledButStale {
+		t.Errorf("Expected every threshold to be exceeded for an account to be stale")
+	}
+
+	strict.Thresholds.Interactive = 30
+	if !strict.Analyze(user).EnabledButStale {
+		t.Errorf("Expected the account to be stale once every threshold is exceeded")
+	}
+}
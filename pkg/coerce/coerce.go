@@ -0,0 +1,203 @@
+package coerce
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"pathid_assignment/pkg/utils"
+)
+
+// Type names a declared field type. Values are coerced into the JSON-like value model produced by
+// encoding/json: booleans are bool, numbers are float64 and everything else is a normalized string.
+type Type string
+
+const (
+	// Bool accepts booleans, the strings true/false/yes/no/1/0 (in any case) and the numbers 0 and 1.
+	Bool Type = "bool"
+	// Int accepts whole numbers and strings holding one.
+	Int Type = "int"
+	// Float accepts numbers and strings holding one.
+	Float Type = "float"
+	// Timestamp accepts any layout supported by utils.ParseTimestamp, normalized to RFC 3339 in UTC.
+	Timestamp Type = "timestamp"
+	// Email accepts a bare email address, normalized to lower case.
+	Email Type = "email"
+	// UUID accepts a hyphenated UUID, normalized to lower case.
+	UUID Type = "uuid"
+	// CountryCode accepts an ISO 3166-1 alpha-2 country code, normalized to upper case.
+	CountryCode Type = "country_code"
+)
+
+// Types lists every supported type, in the order they are documented.
+var Types = []Type{Bool, Int, Float, Timestamp, Email, UUID, CountryCode}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// countryCodes holds the officially assigned ISO 3166-1 alpha-2 codes.
+var countryCodes = make(map[string]bool)
+
+func init() {
+	const assigned = "AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS " +
+		"BT BV BW BY BZ CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES " +
+		"ET FI FJ FK FM FO FR GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM " +
+		"IN IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME " +
+		"MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG " +
+		"PH PK PL PM PN PR PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY " +
+		"SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW"
+	for _, code := range strings.Fields(assigned) {
+		countryCodes[code] = true
+	}
+}
+
+// Known reports whether name is a supported type.
+func Known(name string) bool {
+	for _, t := range Types {
+		if string(t) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizations tells how coercing to each type can change a valid value.
+var normalizations = map[Type]string{
+	Bool:        "strings and numbers are turned into booleans",
+	Int:         "numeric strings are turned into numbers",
+	Float:       "numeric strings are turned into numbers",
+	Timestamp:   "timestamps are rewritten in RFC 3339 in UTC",
+	Email:       "addresses are trimmed and lower-cased",
+	UUID:        "UUIDs are trimmed and lower-cased",
+	CountryCode: "codes are trimmed and upper-cased",
+}
+
+// Normalization describes how coercing to the type can change a valid value, so that the original
+// value cannot be told from the coerced one.
+func Normalization(t Type) string {
+	return normalizations[t]
+}
+
+// Error describes a value that cannot be coerced to a type.
+type Error struct {
+	Type   Type
+	Value  interface{}
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("%s is not a valid %s: %s", describe(e.Value), e.Type, e.Reason)
+	}
+	return fmt.Sprintf("%s is not a valid %s", describe(e.Value), e.Type)
+}
+
+// Coerce converts a value to the given type, returning an *Error when it does not fit.
+// Null values are passed through unchanged; a field that must be present is a schema concern.
+func Coerce(t Type, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	switch t {
+	case Bool:
+		return toBool(value)
+	case Int:
+		f, err := toFloat(t, value)
+		if err != nil {
+			return nil, err
+		}
+		if f != math.Trunc(f) {
+			return nil, &Error{Type: t, Value: value, Reason: "not a whole number"}
+		}
+		return f, nil
+	case Float:
+		return toFloat(t, value)
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return nil, &Error{Type: t, Value: value, Reason: "expected a string"}
+	}
+	s = strings.TrimSpace(s)
+
+	switch t {
+	case Timestamp:
+		ts, err := utils.ParseTimestamp(s)
+		if err != nil {
+			return nil, &Error{Type: t, Value: value}
+		}
+		return ts.UTC().Format(time.RFC3339Nano), nil
+
+	case Email:
+		address, err := mail.ParseAddress(s)
+		if err != nil || address.Name != "" || address.Address != s {
+			return nil, &Error{Type: t, Value: value}
+		}
+		return strings.ToLower(s), nil
+
+	case UUID:
+		s = strings.ToLower(s)
+		if !uuidPattern.MatchString(s) {
+			return nil, &Error{Type: t, Value: value}
+		}
+		return s, nil
+
+	case CountryCode:
+		s = strings.ToUpper(s)
+		if !countryCodes[s] {
+			return nil, &Error{Type: t, Value: value, Reason: "not an ISO 3166-1 alpha-2 code"}
+		}
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("unknown type %q", t)
+}
+
+func toBool(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case float64:
+		if v == 0 || v == 1 {
+			return v == 1, nil
+		}
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "1":
+			return true, nil
+		case "false", "no", "0":
+			return false, nil
+		}
+	}
+	return nil, &Error{Type: Bool, Value: value}
+}
+
+func toFloat(t Type, value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return f, nil
+		}
+	}
+	return 0, &Error{Type: t, Value: value}
+}
+
+// describe formats a value for error messages, quoting strings.
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v)
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "list"
+	}
+	return fmt.Sprint(value)
+}
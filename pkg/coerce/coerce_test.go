@@ -0,0 +1,63 @@
+package coerce_test
+
+import (
+	"errors"
+	"testing"
+
+	"pathid_assignment/pkg/coerce"
+)
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		typ      coerce.Type
+		value    interface{}
+		expected interface{}
+	}{
+		{coerce.Bool, "TRUE", true},
+		{coerce.Bool, "no", false},
+		{coerce.Bool, float64(1), true},
+		{coerce.Int, "42", float64(42)},
+		{coerce.Float, " 2.5 ", 2.5},
+		{coerce.Timestamp, "2017-12-27T04:06:12", "2017-12-27T04:06:12Z"},
+		{coerce.Timestamp, "2017-12-27T06:06:12+02:00", "2017-12-27T04:06:12Z"},
+		{coerce.Email, "John.Doe@Example.com", "john.doe@example.com"},
+		{coerce.UUID, "0E685562-4A32-4728-A7EC-4D288ED7D3D4", "0e685562-4a32-4728-a7ec-4d288ed7d3d4"},
+		{coerce.CountryCode, "il", "IL"},
+		{coerce.Email, nil, nil},
+	}
+
+	for _, test := range tests {
+		got, err := coerce.Coerce(test.typ, test.value)
+		if err != nil {
+			t.Errorf("%s %v: expected no error, got %v", test.typ, test.value, err)
+			continue
+		}
+		if got != test.expected {
+			t.Errorf("%s %v: expected %v, got %v", test.typ, test.value, test.expected, got)
+		}
+	}
+}
+
+func TestCoerce_Invalid(t *testing.T) {
+	tests := []struct {
+		typ   coerce.Type
+		value interface{}
+	}{
+		{coerce.Bool, "maybe"},
+		{coerce.Int, 2.5},
+		{coerce.Float, "abc"},
+		{coerce.Timestamp, "yesterday"},
+		{coerce.Email, "John Doe <john@example.com>"},
+		{coerce.UUID, "0e685562-4a32"},
+		{coerce.CountryCode, "ZZ"},
+		{coerce.CountryCode, true},
+	}
+
+	for _, test := range tests {
+		_, err := coerce.Coerce(test.typ, test.value)
+		var coerceErr *coerce.Error
+		if !errors.As(err, &coerceErr) {
+			t.Errorf("%s %v: expected a coercion error, got %v", test.typ, test.value, err)
+		}
+	}
+}
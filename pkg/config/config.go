@@ -0,0 +1,195 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables overriding settings, e.g. TRANSFORMER_OUTPUT or
+// TRANSFORMER_REPORT_FORMAT.
+const EnvPrefix = "TRANSFORMER_"
+
+// DefaultFiles are the config files looked up in the working directory when none is given.
+var DefaultFiles = []string{"transformer.yaml", "transformer.yml", "transformer.json"}
+
+// Source kinds telling where the value of a setting came from, by increasing precedence.
+const (
+	Default = "default"
+	File    = "file"
+	Env     = "env"
+	Flag    = "flag"
+)
+
+// Config holds the settings of a config file and of the environment. Settings are named after the flags
+// they set. Top-level settings apply to every command, and the mappings named after a command hold the
+// settings of that command only, overriding top-level ones. Settings meaning different things per command,
+// such as output or format, can only be set for a command:
+//
+//	rules: configs/tenant.yaml
+//	transform:
+//	  input: [data/input]
+//	  merge: newest
+//	report:
+//	  format: html
+type Config struct {
+	// Path of the config file, empty without one.
+	Path string
+	// Env looks up environment variables, os.LookupEnv when nil.
+	Env func(string) (string, bool)
+	// Shared reports whether a setting means the same for every command, and can be set at the top level.
+	// Every setting is shared when nil.
+	Shared func(key string) bool
+
+	global   map[string]interface{}
+	sections map[string]map[string]interface{}
+}
+
+// Load reads a YAML or JSON config file. Without a path, the first of DefaultFiles found is read, and
+// without any the config only holds the environment.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		for _, file := range DefaultFiles {
+			if _, err := os.Stat(file); err == nil {
+				path = file
+				break
+			}
+		}
+	}
+	if path == "" {
+		return &Config{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	config, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	config.Path = path
+	return config, nil
+}
+
+// Parse parses YAML or JSON settings.
+func Parse(data []byte) (*Config, error) {
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+
+	config := &Config{global: make(map[string]interface{}), sections: make(map[string]map[string]interface{})}
+	for key, value := range values {
+		if section, ok := value.(map[string]interface{}); ok {
+			config.sections[key] = section
+			continue
+		}
+		config.global[key] = value
+	}
+	return config, nil
+}
+
+// Check reports the settings of the file that no command knows, such as misspelled ones, and the top-level
+// settings that are not shared by every command.
+func (c *Config) Check(known func(section, key string) bool) error {
+	var unknown, unshared []string
+	for key := range c.global {
+		switch {
+		case !known("", key):
+			unknown = append(unknown, key)
+		case !c.shared(key):
+			unshared = append(unshared, key)
+		}
+	}
+	if len(unshared) > 0 {
+		sort.Strings(unshared)
+		return fmt.Errorf("%s: settings %s mean different things per command, set them in command sections",
+			c.Path, strings.Join(unshared, ", "))
+	}
+	for section, values := range c.sections {
+		for key := range values {
+			if !known(section, key) {
+				unknown = append(unknown, section+"."+key)
+			}
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	return fmt.Errorf("%s: unknown settings %s", c.Path, strings.Join(unknown, ", "))
+}
+
+// Lookup returns the values of a setting for a command section, and where they came from, such as
+// "env TRANSFORMER_MERGE" or "file transformer.yaml". The environment overrides the file, and settings of the
+// section override top-level ones. List settings split environment variables on commas. Top-level values of
+// settings that are not shared, and values that are not settings, such as mappings, are errors.
+func (c *Config) Lookup(section, key string, list bool) ([]string, string, bool, error) {
+	env := c.Env
+	if env == nil {
+		env = os.LookupEnv
+	}
+	for _, name := range []string{EnvName(section, key), EnvName("", key)} {
+		if value, ok := env(name); ok {
+			if section != "" && name == EnvName("", key) && !c.shared(key) {
+				return nil, "", false, fmt.Errorf("%s means different things per command, use %s instead", name, EnvName(section, key))
+			}
+			if list {
+				return strings.Split(value, ","), Env + " " + name, true, nil
+			}
+			return []string{value}, Env + " " + name, true, nil
+		}
+		if section == "" {
+			break
+		}
+	}
+
+	for _, values := range []map[string]interface{}{c.sections[section], c.global} {
+		value, ok := values[key]
+		if !ok || value == nil {
+			continue
+		}
+		strs, err := toStrings(value)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("%s: setting %s: %w", c.Path, key, err)
+		}
+		return strs, File + " " + c.Path, true, nil
+	}
+	return nil, "", false, nil
+}
+
+func (c *Config) shared(key string) bool {
+	return c.Shared == nil || c.Shared(key)
+}
+
+// EnvName returns the environment variable of a setting, e.g. TRANSFORMER_REPORT_FORMAT.
+func EnvName(section, key string) string {
+	name := key
+	if section != "" {
+		name = section + "_" + key
+	}
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer("-", "_", " ", "_").Replace(name))
+}
+
+// toStrings converts a decoded setting into the values of a flag.
+func toStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.(map[string]interface{}); nested {
+				return nil, errors.New("nested mappings are not settings")
+			}
+			strs = append(strs, fmt.Sprint(item))
+		}
+		return strs, nil
+	case map[string]interface{}:
+		return nil, errors.New("nested mappings are not settings")
+	}
+	return []string{fmt.Sprint(value)}, nil
+}
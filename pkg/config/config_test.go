@@ -0,0 +1,104 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/config"
+)
+
+func TestConfig_Lookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transformer.yaml")
+	data := `
+rules: configs/tenant.yaml
+workers: 4
+include: [a.json, b.json]
+transform:
+  merge: newest
+report:
+  format: html
+`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	env := map[string]string{"TRANSFORMER_WORKERS": "8", "TRANSFORMER_REPORT_FORMAT": "markdown", "TRANSFORMER_EXCLUDE": "a,b"}
+	cfg.Env = func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		section, key string
+		list         bool
+		values       []string
+		source       string
+	}{
+		{"transform", "rules", false, []string{"configs/tenant.yaml"}, "file " + path},
+		{"transform", "merge", false, []string{"newest"}, "file " + path},
+		{"transform", "include", true, []string{"a.json", "b.json"}, "file " + path},
+		{"transform", "workers", false, []string{"8"}, "env TRANSFORMER_WORKERS"},
+		{"report", "format", false, []string{"markdown"}, "env TRANSFORMER_REPORT_FORMAT"},
+		{"diff", "format", false, nil, ""},
+		{"inspect", "exclude", true, []string{"a", "b"}, "env TRANSFORMER_EXCLUDE"},
+	}
+	for _, test := range tests {
+		values, source, ok, err := cfg.Lookup(test.section, test.key, test.list)
+		if err != nil {
+			t.Errorf("%s.%s: lookup failed: %v", test.section, test.key, err)
+		}
+		if ok != (test.values != nil) || !reflect.DeepEqual(values, test.values) || source != test.source {
+			t.Errorf("%s.%s: expected %v from %q, got %v from %q", test.section, test.key, test.values, test.source, values, source)
+		}
+	}
+}
+
+func TestConfig_Check(t *testing.T) {
+	cfg, err := config.Parse([]byte(`{"rules": "r.json", "ouput": "x", "report": {"format": "html", "colour": true}}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	err = cfg.Check(func(section, key string) bool { return key == "rules" || key == "format" })
+	if err == nil || !strings.Contains(err.Error(), "unknown settings ouput, report.colour") {
+		t.Errorf("Expected the unknown settings to be reported, got %v", err)
+	}
+}
+
+func TestConfig_Shared(t *testing.T) {
+	cfg, err := config.Parse([]byte(`{"rules": "r.json", "format": "html", "report": {"output": "report.html", "exclude": {"a": 1}}}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	cfg.Shared = func(key string) bool { return key != "format" && key != "output" }
+	env := map[string]string{"TRANSFORMER_OUTPUT": "out", "TRANSFORMER_DIFF_OUTPUT": "diff.txt"}
+	cfg.Env = func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	if err := cfg.Check(func(section, key string) bool { return true }); err == nil || !strings.Contains(err.Error(), "settings format mean different things per command") {
+		t.Errorf("Expected the top-level format to be rejected, got %v", err)
+	}
+	if _, _, _, err := cfg.Lookup("report", "output", false); err == nil || !strings.Contains(err.Error(), "use TRANSFORMER_REPORT_OUTPUT instead") {
+		t.Errorf("Expected TRANSFORMER_OUTPUT to be rejected, got %v", err)
+	}
+	if values, _, _, err := cfg.Lookup("diff", "output", false); err != nil || !reflect.DeepEqual(values, []string{"diff.txt"}) {
+		t.Errorf("Expected the variable of the command to be used, got %v (%v)", values, err)
+	}
+	if _, _, _, err := cfg.Lookup("report", "exclude", true); err == nil || !strings.Contains(err.Error(), "setting exclude: nested mappings are not settings") {
+		t.Errorf("Expected the mapping to be reported, got %v", err)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if name := config.EnvName("transform", "stale-interactive-days"); name != "TRANSFORMER_TRANSFORM_STALE_INTERACTIVE_DAYS" {
+		t.Errorf("Unexpected environment variable %s", name)
+	}
+}
@@ -0,0 +1,205 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+
+	"pathid_assignment/pkg/storage"
+)
+
+// ignoredFields are bookkeeping fields of stored users that are not part of the user itself.
+var ignoredFields = map[string]bool{"op": true}
+
+// FieldChange is a field whose value differs between two runs. A nil Old or New value means the
+// field is null or missing on that side.
+type FieldChange struct {
+	Field string      `json:"field"` // Dot-separated field path, e.g. "sign_in_activity.lastSignInDateTime".
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// UserChange lists the changed fields of a user present in both runs.
+type UserChange struct {
+	ID     string        `json:"id"`
+	Fields []FieldChange `json:"fields"`
+}
+
+// Report describes the changes between two runs, ordered by user id.
+type Report struct {
+	Added    []map[string]interface{} `json:"added"`
+	Removed  []map[string]interface{} `json:"removed"`
+	Modified []UserChange             `json:"modified"`
+	SignIns  struct {
+		Appeared    []storage.SignIn `json:"appeared"`
+		Disappeared []storage.SignIn `json:"disappeared"`
+	} `json:"sign_ins"`
+}
+
+// Empty reports whether the two runs stored the same users and sign-ins.
+func (r *Report) Empty() bool {
+	return len(r.Added) == 0 && len(r.Removed) == 0 && len(r.Modified) == 0 &&
+		len(r.SignIns.Appeared) == 0 && len(r.SignIns.Disappeared) == 0
+}
+
+// Compare reports the users and sign-ins that differ from the old run to the new one.
+func Compare(old, new *storage.Output) *Report {
+	report := &Report{Added: []map[string]interface{}{}, Removed: []map[string]interface{}{}, Modified: []UserChange{}}
+
+	oldUsers, newUsers := byID(old.Users), byID(new.Users)
+	for _, id := range sortedKeys(newUsers) {
+		oldUser, exists := oldUsers[id]
+		if !exists {
+			report.Added = append(report.Added, newUsers[id])
+			continue
+		}
+		if fields := compareFields(oldUser, newUsers[id]); len(fields) > 0 {
+			report.Modified = append(report.Modified, UserChange{ID: id, Fields: fields})
+		}
+	}
+	for _, id := range sortedKeys(oldUsers) {
+		if _, exists := newUsers[id]; !exists {
+			report.Removed = append(report.Removed, oldUsers[id])
+		}
+	}
+
+	report.SignIns.Appeared = subtract(new.SignIns, old.SignIns)
+	report.SignIns.Disappeared = subtract(old.SignIns, new.SignIns)
+	return report
+}
+
+// byID indexes users by their id. Users without an id cannot be matched and are left out.
+func byID(users []map[string]interface{}) map[string]map[string]interface{} {
+	indexed := make(map[string]map[string]interface{}, len(users))
+	for _, user := range users {
+		if id, exists := user["id"]; exists && id != nil {
+			indexed[fmt.Sprint(id)] = user
+		}
+	}
+	return indexed
+}
+
+func sortedKeys(users map[string]map[string]interface{}) []string {
+	keys := make([]string, 0, len(users))
+	for key := range users {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compareFields lists the leaf fields that differ between two versions of a user, ordered by field.
+func compareFields(old, new map[string]interface{}) []FieldChange {
+	oldFields, newFields := make(map[string]interface{}), make(map[string]interface{})
+	flatten("", old, oldFields)
+	flatten("", new, newFields)
+
+	var changes []FieldChange
+	for field, value := range newFields {
+		if !reflect.DeepEqual(oldFields[field], value) {
+			changes = append(changes, FieldChange{Field: field, Old: oldFields[field], New: value})
+		}
+	}
+	for field, value := range oldFields {
+		if _, exists := newFields[field]; !exists && value != nil {
+			changes = append(changes, FieldChange{Field: field, Old: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+// flatten collects the leaf values of a record by dot-separated path.
+func flatten(prefix string, record map[string]interface{}, fields map[string]interface{}) {
+	for key, value := range record {
+		if prefix == "" && ignoredFields[key] {
+			continue
+		}
+		field := key
+		if prefix != "" {
+			field = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(field, nested, fields)
+			continue
+		}
+		fields[field] = value
+	}
+}
+
+// subtract returns the sign-ins of a that are not in b, ordered by user, type and time.
+func subtract(a, b []storage.SignIn) []storage.SignIn {
+	existing := make(map[storage.SignIn]bool, len(b))
+	for _, signIn := range b {
+		existing[signIn] = true
+	}
+
+	result := []storage.SignIn{}
+	for _, signIn := range a {
+		if !existing[signIn] {
+			result = append(result, signIn)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		x, y := result[i], result[j]
+		if x.UserID != y.UserID {
+			return x.UserID < y.UserID
+		}
+		if x.Type != y.Type {
+			return x.Type < y.Type
+		}
+		return x.TimeStamp < y.TimeStamp
+	})
+	return result
+}
+
+// WriteText writes the report in a human-readable form, one line per user or sign-in.
+func (r *Report) WriteText(w io.Writer) error {
+	ew := &errWriter{w: w}
+
+	ew.printf("Users: %d added, %d removed, %d modified\n", len(r.Added), len(r.Removed), len(r.Modified))
+	for _, user := range r.Added {
+		ew.printf("+ %v\n", user["id"])
+	}
+	for _, user := range r.Removed {
+		ew.printf("- %v\n", user["id"])
+	}
+	for _, change := range r.Modified {
+		ew.printf("~ %s\n", change.ID)
+		for _, field := range change.Fields {
+			ew.printf("    %s: %s -> %s\n", field.Field, format(field.Old), format(field.New))
+		}
+	}
+
+	ew.printf("Sign-ins: %d appeared, %d disappeared\n", len(r.SignIns.Appeared), len(r.SignIns.Disappeared))
+	for _, signIn := range r.SignIns.Appeared {
+		ew.printf("+ %s %s %s (request %s)\n", signIn.UserID, signIn.Type, signIn.TimeStamp, signIn.RequestID)
+	}
+	for _, signIn := range r.SignIns.Disappeared {
+		ew.printf("- %s %s %s (request %s)\n", signIn.UserID, signIn.Type, signIn.TimeStamp, signIn.RequestID)
+	}
+	return ew.err
+}
+
+// format renders a field value as JSON, so strings are quoted and missing values read as null.
+func format(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// errWriter keeps the first write error, so a report is written without checking every line.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
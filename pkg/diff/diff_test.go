@@ -0,0 +1,63 @@
+package diff_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/diff"
+	"pathid_assignment/pkg/storage"
+)
+
+func TestCompare(t *testing.T) {
+	old := &storage.Output{
+		Users: []map[string]interface{}{
+			{"id": "1", "first_name": "Ann", "mail": nil},
+			{"id": "2", "first_name": "Bob"},
+		},
+		SignIns: []storage.SignIn{
+			{UserID: "1", Type: "lastSignInDateTime", TimeStamp: "2024-01-01T00:00:00", RequestID: "r1"},
+		},
+	}
+	new := &storage.Output{
+		Users: []map[string]interface{}{
+			{"id": "1", "first_name": "Anne", "mail": "ann@example.com", "op": "changed"},
+			{"id": "3", "first_name": "Eve"},
+		},
+		SignIns: []storage.SignIn{
+			{UserID: "1", Type: "lastSignInDateTime", TimeStamp: "2024-02-01T00:00:00", RequestID: "r2"},
+		},
+	}
+
+	report := diff.Compare(old, new)
+	if len(report.Added) != 1 || report.Added[0]["id"] != "3" {
+		t.Errorf("Expected user 3 added, got %v", report.Added)
+	}
+	if len(report.Removed) != 1 || report.Removed[0]["id"] != "2" {
+		t.Errorf("Expected user 2 removed, got %v", report.Removed)
+	}
+	if len(report.Modified) != 1 || len(report.Modified[0].Fields) != 2 {
+		t.Fatalf("Expected user 1 modified in 2 fields, got %+v", report.Modified)
+	}
+	if field := report.Modified[0].Fields[0]; field.Field != "first_name" || field.Old != "Ann" || field.New != "Anne" {
+		t.Errorf("Unexpected field change: %+v", field)
+	}
+	if len(report.SignIns.Appeared) != 1 || report.SignIns.Appeared[0].RequestID != "r2" ||
+		len(report.SignIns.Disappeared) != 1 || report.SignIns.Disappeared[0].RequestID != "r1" {
+		t.Errorf("Unexpected sign-in changes: %+v", report.SignIns)
+	}
+
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	for _, line := range []string{"Users: 1 added, 1 removed, 1 modified", `first_name: "Ann" -> "Anne"`, "- 1 lastSignInDateTime"} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("Expected the text report to contain %q, got:\n%s", line, text.String())
+		}
+	}
+
+	if !diff.Compare(old, old).Empty() {
+		t.Errorf("Expected no changes between identical runs")
+	}
+}
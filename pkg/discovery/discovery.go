@@ -0,0 +1,145 @@
+package discovery
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Stdin is the input path reading records from standard input.
+const Stdin = "-"
+
+// DefaultInclude matches the input files found in directories when no include pattern is given.
+const DefaultInclude = "*.json"
+
+// Options select the input files found in directories.
+type Options struct {
+	// Recursive descends into the subdirectories of input directories.
+	Recursive bool
+	// Include patterns match the names of the files taken from directories, "*.json" when empty.
+	Include []string
+	// Exclude patterns match the names, or the slash-separated paths relative to the input directory,
+	// of files and subdirectories that are skipped.
+	Exclude []string
+}
+
+// Find expands input paths into input files, in order and without duplicates. Paths may be files,
+// directories, glob patterns or Stdin. Files named explicitly are always taken; the files of directories,
+// including directories matched by a pattern, are selected by the options.
+func (o Options) Find(paths []string) ([]string, error) {
+	for _, pattern := range append(append([]string(nil), o.Include...), o.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+
+	var files []string
+	seen := make(map[string]bool)
+	add := func(file string) {
+		if file != Stdin {
+			file = filepath.Clean(file)
+		}
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, path := range paths {
+		if path == Stdin {
+			add(path)
+			continue
+		}
+
+		matches := []string{path}
+		if isPattern(path) {
+			var err error
+			if matches, err = filepath.Glob(path); err != nil {
+				return nil, fmt.Errorf("invalid input pattern %q: %w", path, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no input matches %s", path)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, fmt.Errorf("accessing input path: %w", err)
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			found, err := o.walk(match)
+			if err != nil {
+				return nil, fmt.Errorf("reading input directory %s: %w", match, err)
+			}
+			for _, file := range found {
+				add(file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// walk returns the files of a directory selected by the options, in lexical order.
+func (o Options) walk(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if !o.Recursive || o.excluded(filepath.ToSlash(rel), entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if o.included(entry.Name()) && !o.excluded(filepath.ToSlash(rel), entry.Name()) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (o Options) included(name string) bool {
+	include := o.Include
+	if len(include) == 0 {
+		include = []string{DefaultInclude}
+	}
+	for _, pattern := range include {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Options) excluded(rel, name string) bool {
+	for _, pattern := range o.Exclude {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isPattern reports whether a path holds glob metacharacters.
+func isPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
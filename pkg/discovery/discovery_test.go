@@ -0,0 +1,71 @@
+package discovery_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"pathid_assignment/pkg/discovery"
+)
+
+func TestOptions_Find(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"a.json", "b.txt", "nested/c.json", "nested/skip/d.json", "nested/e.ndjson"} {
+		path := filepath.Join(dir, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	join := func(files ...string) []string {
+		for i, file := range files {
+			if file != discovery.Stdin {
+				files[i] = filepath.Join(dir, filepath.FromSlash(file))
+			}
+		}
+		return files
+	}
+
+	tests := []struct {
+		name     string
+		options  discovery.Options
+		paths    []string
+		expected []string
+	}{
+		{"directory", discovery.Options{}, join(""), join("a.json")},
+		{"recursive", discovery.Options{Recursive: true}, join(""), join("a.json", "nested/c.json", "nested/skip/d.json")},
+		{"include", discovery.Options{Recursive: true, Include: []string{"*.json", "*.ndjson"}}, join("nested"), join("nested/c.json", "nested/e.ndjson", "nested/skip/d.json")},
+		{"exclude", discovery.Options{Recursive: true, Exclude: []string{"skip", "a.*"}}, join(""), join("nested/c.json")},
+		{"explicit file", discovery.Options{}, join("b.txt"), join("b.txt")},
+		{"glob", discovery.Options{}, join("*.json", "nested/*.json"), join("a.json", "nested/c.json")},
+		{"stdin and duplicates", discovery.Options{}, join("-", "a.json", "", "-"), join("-", "a.json")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := test.options.Find(test.paths)
+			if err != nil {
+				t.Fatalf("Find failed: %v", err)
+			}
+			if !reflect.DeepEqual(files, test.expected) {
+				t.Errorf("Expected %v, got %v", test.expected, files)
+			}
+		})
+	}
+}
+
+func TestOptions_Find_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := (discovery.Options{}).Find([]string{filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("Expected an error for a missing input")
+	}
+	if _, err := (discovery.Options{}).Find([]string{filepath.Join(dir, "*.json")}); err == nil {
+		t.Error("Expected an error for a pattern matching nothing")
+	}
+	if _, err := (discovery.Options{Include: []string{"["}}).Find([]string{dir}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,244 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Version is the current format version of envelopes and encrypted values.
+const Version = 1
+
+// Algorithm is the cipher data keys encrypt values with.
+const Algorithm = "AES-256-GCM"
+
+// Prefix starts every encrypted value, followed by the base64-encoded nonce and ciphertext.
+const Prefix = "enc:v1:"
+
+// KMS wraps and unwraps data keys with a master key it never reveals.
+type KMS interface {
+	// KeyID identifies the master key, so the right key can be found to unwrap a data key.
+	KeyID() string
+	WrapKey(dataKey []byte) ([]byte, error)
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// FileKMS is a KMS holding its master key in a local file, standing in for a key management service.
+type FileKMS struct {
+	aead cipher.AEAD
+	id   string
+}
+
+// LoadFileKMS reads a 256-bit master key from a file, hex- or base64-encoded, e.g. as generated
+// by `openssl rand -hex 32`.
+func LoadFileKMS(path string) (*FileKMS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading master key file: %w", err)
+	}
+
+	encoded := string(bytes.TrimSpace(data))
+	key, err := hex.DecodeString(encoded)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encoded)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("master key file %s must hold a hex- or base64-encoded 32-byte key", path)
+	}
+	return NewFileKMS(key)
+}
+
+// NewFileKMS returns a FileKMS with the given 256-bit master key.
+func NewFileKMS(key []byte) (*FileKMS, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &FileKMS{aead: aead, id: "file:" + hex.EncodeToString(sum[:8])}, nil
+}
+
+// KeyID identifies the master key by a digest prefix, which does not reveal the key.
+func (k *FileKMS) KeyID() string {
+	return k.id
+}
+
+// WrapKey encrypts a data key with the master key.
+func (k *FileKMS) WrapKey(dataKey []byte) ([]byte, error) {
+	return seal(k.aead, dataKey)
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey.
+func (k *FileKMS) UnwrapKey(wrapped []byte) ([]byte, error) {
+	return open(k.aead, wrapped)
+}
+
+// Envelope holds the wrapped data key of a run, stored next to the values it encrypted.
+type Envelope struct {
+	Version    int    `json:"version"`
+	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// Sealer encrypts values with a data key generated for it. It is safe for concurrent use.
+type Sealer struct {
+	aead     cipher.AEAD
+	envelope Envelope
+}
+
+// NewSealer generates a data key and wraps it with the KMS.
+func NewSealer(kms KMS) (*Sealer, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	wrapped, err := kms.WrapKey(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{
+		aead:     aead,
+		envelope: Envelope{Version: Version, Algorithm: Algorithm, KeyID: kms.KeyID(), WrappedKey: wrapped},
+	}, nil
+}
+
+// Envelope returns the envelope needed to decrypt the values the Sealer encrypted.
+func (s *Sealer) Envelope() Envelope {
+	return s.envelope
+}
+
+// Seal encrypts the JSON encoding of a value, so its type is restored on decryption.
+func (s *Sealer) Seal(value interface{}) (string, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(s.aead, plaintext)
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Opener decrypts values encrypted by the Sealer of an envelope.
+type Opener struct {
+	aead cipher.AEAD
+}
+
+// NewOpener unwraps the data key of an envelope with the KMS.
+func NewOpener(kms KMS, envelope Envelope) (*Opener, error) {
+	if envelope.Version != Version || envelope.Algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported envelope version %d with algorithm %q", envelope.Version, envelope.Algorithm)
+	}
+	if envelope.KeyID != kms.KeyID() {
+		return nil, fmt.Errorf("data key is wrapped by master key %s, not %s", envelope.KeyID, kms.KeyID())
+	}
+
+	dataKey, err := kms.UnwrapKey(envelope.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unwrapping data key: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Opener{aead: aead}, nil
+}
+
+// IsSealed reports whether a value was encrypted by a Sealer.
+func IsSealed(value interface{}) bool {
+	s, ok := value.(string)
+	return ok && strings.HasPrefix(s, Prefix)
+}
+
+// Open decrypts a value encrypted by Seal.
+func (o *Opener) Open(value string) (interface{}, error) {
+	if !strings.HasPrefix(value, Prefix) {
+		return nil, errors.New("value is not encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil {
+		return nil, fmt.Errorf("decoding encrypted value: %w", err)
+	}
+	plaintext, err := open(o.aead, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var decrypted interface{}
+	if err := json.Unmarshal(plaintext, &decrypted); err != nil {
+		return nil, err
+	}
+	return decrypted, nil
+}
+
+// OpenAll decrypts every encrypted value found in a decoded JSON document, at any depth.
+func (o *Opener) OpenAll(document interface{}) (interface{}, error) {
+	switch v := document.(type) {
+	case string:
+		if IsSealed(v) {
+			return o.Open(v)
+		}
+	case []interface{}:
+		for i, item := range v {
+			opened, err := o.OpenAll(item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = opened
+		}
+	case map[string]interface{}:
+		for key, item := range v {
+			opened, err := o.OpenAll(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			v[key] = opened
+		}
+	}
+	return document, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, returned in front of the ciphertext.
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(aead cipher.AEAD, sealed []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("decrypting value: wrong key or corrupted data")
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,113 @@
+package envelope_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/envelope"
+)
+
+func newKMS(t *testing.T, b byte) *envelope.FileKMS {
+	t.Helper()
+	kms, err := envelope.NewFileKMS(bytes.Repeat([]byte{b}, 32))
+	if err != nil {
+		t.Fatalf("NewFileKMS failed: %v", err)
+	}
+	return kms
+}
+
+func TestSealer(t *testing.T) {
+	kms := newKMS(t, 1)
+	sealer, err := envelope.NewSealer(kms)
+	if err != nil {
+		t.Fatalf("NewSealer failed: %v", err)
+	}
+
+	document := map[string]interface{}{"plain": "kept"}
+	for _, value := range []interface{}{"ann@example.com", true, 42.0, map[string]interface{}{"nested": "value"}} {
+		sealed, err := sealer.Seal(value)
+		if err != nil {
+			t.Fatalf("Seal(%v) failed: %v", value, err)
+		}
+		if !envelope.IsSealed(sealed) || strings.Contains(sealed, "ann") {
+			t.Errorf("Expected an encrypted value, got %q", sealed)
+		}
+		document[sealed[len(sealed)-8:]] = []interface{}{sealed}
+	}
+
+	opener, err := envelope.NewOpener(kms, sealer.Envelope())
+	if err != nil {
+		t.Fatalf("NewOpener failed: %v", err)
+	}
+	opened, err := opener.OpenAll(document)
+	if err != nil {
+		t.Fatalf("OpenAll failed: %v", err)
+	}
+
+	var values []interface{}
+	for key, value := range opened.(map[string]interface{}) {
+		if key != "plain" {
+			values = append(values, value.([]interface{})[0])
+		}
+	}
+	if len(values) != 4 || document["plain"] != "kept" {
+		t.Fatalf("Unexpected decrypted document: %v", opened)
+	}
+	for _, expected := range []interface{}{"ann@example.com", true, 42.0, map[string]interface{}{"nested": "value"}} {
+		found := false
+		for _, value := range values {
+			found = found || reflect.DeepEqual(value, expected)
+		}
+		if !found {
+			t.Errorf("Expected %v to be decrypted with its type, got %v", expected, values)
+		}
+	}
+
+	if _, err := envelope.NewOpener(newKMS(t, 2), sealer.Envelope()); err == nil {
+		t.Errorf("Expected another master key to be refused")
+	}
+}
+
+func TestLoadFileKMS(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, 32)
+	files := map[string]string{
+		"hex.key":    strings.Repeat("07", 32) + "\n",
+		"base64.key": base64.StdEncoding.EncodeToString(key),
+		"short.key":  "0707",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write key file: %v", err)
+		}
+	}
+
+	hexKMS, err := envelope.LoadFileKMS(filepath.Join(dir, "hex.key"))
+	if err != nil {
+		t.Fatalf("Expected a hex key to load, got %v", err)
+	}
+	base64KMS, err := envelope.LoadFileKMS(filepath.Join(dir, "base64.key"))
+	if err != nil {
+		t.Fatalf("Expected a base64 key to load, got %v", err)
+	}
+	if hexKMS.KeyID() != base64KMS.KeyID() {
+		t.Errorf("Expected the same key in both encodings to have the same id")
+	}
+
+	wrapped, err := hexKMS.WrapKey([]byte("data key"))
+	if err != nil {
+		t.Fatalf("WrapKey failed: %v", err)
+	}
+	if unwrapped, err := base64KMS.UnwrapKey(wrapped); err != nil || string(unwrapped) != "data key" {
+		t.Errorf("Expected the data key back, got %q (%v)", unwrapped, err)
+	}
+
+	if _, err := envelope.LoadFileKMS(filepath.Join(dir, "short.key")); err == nil {
+		t.Errorf("Expected a short key to be refused")
+	}
+}
@@ -0,0 +1,90 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expression is a parsed expression that can be evaluated against input records.
+// A parsed Expression holds no mutable state and is safe for concurrent use.
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse compiles the given source into an Expression.
+//
+// The language supports literals ('text', "text", 42, 1.5, true, false, null),
+// dot-separated field paths (signInActivity.lastSignInDateTime), list literals,
+// the operators == != < <= > >= in && || ! -, parentheses and a small set of
+// built-in functions (see functions.go).
+func Parse(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseExpression()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression %q: %w", source, err)
+	}
+
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("invalid expression %q: unexpected %q at offset %d", source, tok.text, tok.pos)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source the expression was parsed from.
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression against the given record and returns the resulting value.
+func (e *Expression) Eval(data map[string]interface{}) (interface{}, error) {
+	val, err := e.root.eval(data)
+	if err != nil {
+		return nil, fmt.Errorf("evaluating %q: %w", e.source, err)
+	}
+	return val, nil
+}
+
+// EvalBool evaluates the expression and interprets the result as a condition.
+// A null result (for example a missing field) is treated as false.
+func (e *Expression) EvalBool(data map[string]interface{}) (bool, error) {
+	val, err := e.Eval(data)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := truthy(val)
+	if err != nil {
+		return false, fmt.Errorf("evaluating %q: %w", e.source, err)
+	}
+	return b, nil
+}
+
+// Paths returns the field paths referenced by the expression, in order of appearance.
+func (e *Expression) Paths() []string {
+	var paths []string
+	walk(e.root, func(n node) {
+		if p, ok := n.(*pathNode); ok {
+			paths = append(paths, strings.Join(p.keys, "."))
+		}
+	})
+	return paths
+}
+
+// truthy converts an evaluated value into a boolean condition.
+func truthy(val interface{}) (bool, error) {
+	switch v := val.(type) {
+	case bool:
+		return v, nil
+	case nil:
+		return false, nil
+	default:
+		return false, fmt.Errorf("expected a boolean, got %T", val)
+	}
+}
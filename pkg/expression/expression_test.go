@@ -0,0 +1,82 @@
+package expression_test
+
+import (
+	"testing"
+	"time"
+
+	"pathid_assignment/pkg/expression"
+)
+
+func TestExpression_EvalBool(t *testing.T) {
+	record := map[string]interface{}{
+		"userType":       "Guest",
+		"accountEnabled": true,
+		"usageLocation":  "US",
+		"otherMails":     []interface{}{"a@example.com"},
+		"signInActivity": map[string]interface{}{
+			"lastSignInDateTime": time.Now().AddDate(0, 0, -10).UTC().Format("2006-01-02T15:04:05"),
+		},
+	}
+
+	tests := []struct {
+		source   string
+		expected bool
+	}{
+		{`userType == 'Guest'`, true},
+		{`userType != "Guest"`, false},
+		{`accountEnabled && userType == 'Guest'`, true},
+		{`!accountEnabled || usageLocation == 'US'`, true},
+		{`usageLocation in ['US', 'CA']`, true},
+		{`mail == null`, true},
+		{`mail != null`, false},
+		{`daysSince(signInActivity.lastSignInDateTime) <= 90`, true},
+		{`daysSince(signInActivity.lastNonInteractiveSignInDateTime) <= 90`, false},
+		{`len(otherMails) > 0 && contains(otherMails, 'a@example.com')`, true},
+		{`startsWith(lower(userType), 'gu')`, true},
+		{`(userType == 'Member' || userType == 'Guest') && !(usageLocation == 'IL')`, true},
+	}
+
+	for _, tt := range tests {
+		expr, err := expression.Parse(tt.source)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.source, err)
+		}
+
+		got, err := expr.EvalBool(record)
+		if err != nil {
+			t.Fatalf("EvalBool(%q) failed: %v", tt.source, err)
+		}
+		if got != tt.expected {
+			t.Errorf("EvalBool(%q) = %v, expected %v", tt.source, got, tt.expected)
+		}
+	}
+}
+
+func TestExpression_ParseErrors(t *testing.T) {
+	invalid := []string{
+		`userType ==`,
+		`userType == 'Guest`,
+		`unknown(userType)`,
+		`daysSince()`,
+		`(userType == 'Guest'`,
+		`userType # 'Guest'`,
+	}
+
+	for _, source := range invalid {
+		if _, err := expression.Parse(source); err == nil {
+			t.Errorf("Expected Parse(%q) to fail, but it did not", source)
+		}
+	}
+}
+
+func TestExpression_Paths(t *testing.T) {
+	expr, err := expression.Parse(`accountEnabled && daysSince(signInActivity.lastSignInDateTime) < 30`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	paths := expr.Paths()
+	if len(paths) != 2 || paths[0] != "accountEnabled" || paths[1] != "signInActivity.lastSignInDateTime" {
+		t.Errorf("Unexpected paths: %v", paths)
+	}
+}
@@ -0,0 +1,93 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pathid_assignment/pkg/utils"
+)
+
+type function struct {
+	arity int
+	call  func(args []interface{}) (interface{}, error)
+}
+
+// functions holds the built-in functions available to expressions.
+var functions = map[string]function{
+	// daysSince returns the number of days elapsed since a timestamp, or null if the timestamp is missing.
+	"daysSince": {arity: 1, call: func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a timestamp string, got %T", args[0])
+		}
+		t, err := utils.ParseTimestamp(s)
+		if err != nil {
+			return nil, err
+		}
+		return time.Since(t).Hours() / 24, nil
+	}},
+	"lower": {arity: 1, call: stringFunc(strings.ToLower)},
+	"upper": {arity: 1, call: stringFunc(strings.ToUpper)},
+	"len": {arity: 1, call: func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return float64(0), nil
+		case string:
+			return float64(len(v)), nil
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		}
+		return nil, fmt.Errorf("unsupported argument type %T", args[0])
+	}},
+	"contains": {arity: 2, call: func(args []interface{}) (interface{}, error) {
+		switch v := args[0].(type) {
+		case nil:
+			return false, nil
+		case string:
+			sub, ok := args[1].(string)
+			return ok && strings.Contains(v, sub), nil
+		case []interface{}:
+			for _, item := range v {
+				if equal(item, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return nil, fmt.Errorf("unsupported argument type %T", args[0])
+	}},
+	"startsWith": {arity: 2, call: stringPredicate(strings.HasPrefix)},
+	"endsWith":   {arity: 2, call: stringPredicate(strings.HasSuffix)},
+}
+
+// stringFunc adapts a string-to-string function, passing null through unchanged.
+func stringFunc(fn func(string) string) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("expected a string, got %T", args[0])
+		}
+		return fn(s), nil
+	}
+}
+
+// stringPredicate adapts a two-string predicate, returning false when either side is not a string.
+func stringPredicate(fn func(string, string) bool) func([]interface{}) (interface{}, error) {
+	return func(args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return false, nil
+		}
+		arg, ok := args[1].(string)
+		return ok && fn(s, arg), nil
+	}
+}
@@ -0,0 +1,117 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// operators lists the supported operator symbols, longest first so that "<=" wins over "<".
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "-", "(", ")", "[", "]", ","}
+
+// tokenize splits the expression source into tokens.
+func tokenize(source string) ([]token, error) {
+	var tokens []token
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '\'' || r == '"':
+			text, next, err := readString(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i = next
+
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+
+		case isIdentStart(r):
+			start := i
+			for i < len(runes) && (isIdentPart(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			if strings.HasSuffix(text, ".") || strings.Contains(text, "..") {
+				return nil, fmt.Errorf("malformed path %q at offset %d", text, start)
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: text, pos: start})
+
+		default:
+			op := matchOperator(runes[i:])
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", r, i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// readString reads a quoted string literal starting at runes[start] and returns its unescaped value.
+func readString(runes []rune, start int) (string, int, error) {
+	quote := runes[start]
+	var sb strings.Builder
+
+	for i := start + 1; i < len(runes); i++ {
+		switch runes[i] {
+		case '\\':
+			if i+1 >= len(runes) {
+				return "", 0, fmt.Errorf("unterminated string at offset %d", start)
+			}
+			i++
+			sb.WriteRune(runes[i])
+		case quote:
+			return sb.String(), i + 1, nil
+		default:
+			sb.WriteRune(runes[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated string at offset %d", start)
+}
+
+func matchOperator(runes []rune) string {
+	for _, op := range operators {
+		if len(runes) >= len(op) && string(runes[:len(op)]) == op {
+			return op
+		}
+	}
+	return ""
+}
+
+func isIdentStart(r rune) bool {
+	return unicode.IsLetter(r) || r == '_' || r == '@'
+}
+
+func isIdentPart(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '@'
+}
@@ -0,0 +1,295 @@
+package expression
+
+import (
+	"fmt"
+	"strings"
+)
+
+// node is a single element of the parsed expression tree.
+type node interface {
+	eval(data map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// pathNode resolves a pre-split dot path against the record. Missing fields evaluate to null.
+type pathNode struct {
+	keys []string
+}
+
+func (n *pathNode) eval(data map[string]interface{}) (interface{}, error) {
+	var value interface{} = data
+	for _, key := range n.keys {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		value = m[key]
+	}
+	return value, nil
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(data map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		val, err := item.eval(data)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, val)
+	}
+	return values, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(data map[string]interface{}) (interface{}, error) {
+	val, err := n.operand.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "!" {
+		b, err := truthy(val)
+		if err != nil {
+			return nil, fmt.Errorf("operand of !: %w", err)
+		}
+		return !b, nil
+	}
+
+	if val == nil {
+		return nil, nil
+	}
+	f, ok := toFloat(val)
+	if !ok {
+		return nil, fmt.Errorf("cannot negate %T", val)
+	}
+	return -f, nil
+}
+
+// logicalNode implements short-circuiting && and ||.
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n *logicalNode) eval(data map[string]interface{}) (interface{}, error) {
+	leftVal, err := n.left.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	left, err := truthy(leftVal)
+	if err != nil {
+		return nil, fmt.Errorf("left operand of %s: %w", n.op, err)
+	}
+
+	if (n.op == "&&" && !left) || (n.op == "||" && left) {
+		return left, nil
+	}
+
+	rightVal, err := n.right.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	right, err := truthy(rightVal)
+	if err != nil {
+		return nil, fmt.Errorf("right operand of %s: %w", n.op, err)
+	}
+	return right, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n *compareNode) eval(data map[string]interface{}) (interface{}, error) {
+	left, err := n.left.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	}
+
+	// Ordering against a missing value never holds, so filters such as
+	// "daysSince(x) <= 90" simply exclude records without x.
+	if left == nil || right == nil {
+		return false, nil
+	}
+
+	cmp, err := compare(left, right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+type inNode struct {
+	value, list node
+}
+
+func (n *inNode) eval(data map[string]interface{}) (interface{}, error) {
+	val, err := n.value.eval(data)
+	if err != nil {
+		return nil, err
+	}
+	listVal, err := n.list.eval(data)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := listVal.([]interface{})
+	if !ok {
+		if listVal == nil {
+			return false, nil
+		}
+		return nil, fmt.Errorf("right operand of in must be a list, got %T", listVal)
+	}
+
+	for _, item := range list {
+		if equal(val, item) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type callNode struct {
+	name string
+	fn   func(args []interface{}) (interface{}, error)
+	args []node
+}
+
+func (n *callNode) eval(data map[string]interface{}) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		val, err := arg.eval(data)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = val
+	}
+
+	result, err := n.fn(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", n.name, err)
+	}
+	return result, nil
+}
+
+// walk visits every node of the tree in depth-first order.
+func walk(n node, visit func(node)) {
+	visit(n)
+	switch v := n.(type) {
+	case *listNode:
+		for _, item := range v.items {
+			walk(item, visit)
+		}
+	case *unaryNode:
+		walk(v.operand, visit)
+	case *logicalNode:
+		walk(v.left, visit)
+		walk(v.right, visit)
+	case *compareNode:
+		walk(v.left, visit)
+		walk(v.right, visit)
+	case *inNode:
+		walk(v.value, visit)
+		walk(v.list, visit)
+	case *callNode:
+		for _, arg := range v.args {
+			walk(arg, visit)
+		}
+	}
+}
+
+// equal compares two values, treating all numeric types as float64.
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+
+	switch av := a.(type) {
+	case nil:
+		return b == nil
+	case string:
+		bv, ok := b.(string)
+		return ok && av == bv
+	case bool:
+		bv, ok := b.(bool)
+		return ok && av == bv
+	}
+	return false
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b interface{}) (int, error) {
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1, nil
+			case fa > fb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+
+	if sa, ok := a.(string); ok {
+		if sb, ok := b.(string); ok {
+			return strings.Compare(sa, sb), nil
+		}
+	}
+
+	return 0, fmt.Errorf("cannot compare %T with %T", a, b)
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
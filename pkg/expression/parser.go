@@ -0,0 +1,227 @@
+package expression
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parser is a recursive-descent parser over the token stream.
+//
+// Precedence, from lowest to highest:
+//
+//	||
+//	&&
+//	== != < <= > >= in
+//	! - (unary)
+//	literals, paths, calls, lists, parentheses
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) isOperator(text string) bool {
+	tok := p.peek()
+	return tok.kind == tokenOperator && tok.text == text
+}
+
+func (p *parser) expect(text string) error {
+	tok := p.next()
+	if tok.kind != tokenOperator || tok.text != text {
+		return fmt.Errorf("expected %q at offset %d", text, tok.pos)
+	}
+	return nil
+}
+
+func (p *parser) parseExpression() (node, error) {
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("||") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOperator("&&") {
+		p.next()
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := p.peek()
+	switch {
+	case tok.kind == tokenOperator && isComparison(tok.text):
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &compareNode{op: tok.text, left: left, right: right}, nil
+	case tok.kind == tokenIdent && tok.text == "in":
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &inNode{value: left, list: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOperator("!") || p.isOperator("-") {
+		op := p.next().text
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+
+	switch tok.kind {
+	case tokenString:
+		return &literalNode{value: tok.text}, nil
+
+	case tokenNumber:
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at offset %d", tok.text, tok.pos)
+		}
+		return &literalNode{value: f}, nil
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.isOperator("(") {
+			return p.parseCall(tok)
+		}
+		return &pathNode{keys: strings.Split(tok.text, ".")}, nil
+
+	case tokenOperator:
+		switch tok.text {
+		case "(":
+			inner, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return inner, nil
+		case "[":
+			items, err := p.parseList("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, exists := functions[name.text]
+	if !exists {
+		return nil, fmt.Errorf("unknown function %q at offset %d", name.text, name.pos)
+	}
+
+	p.next() // consume "("
+	args, err := p.parseList(")")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) != fn.arity {
+		return nil, fmt.Errorf("function %s expects %d argument(s), got %d", name.text, fn.arity, len(args))
+	}
+	return &callNode{name: name.text, fn: fn.call, args: args}, nil
+}
+
+// parseList parses comma-separated expressions up to and including the closing token.
+func (p *parser) parseList(closing string) ([]node, error) {
+	var items []node
+	if p.isOperator(closing) {
+		p.next()
+		return items, nil
+	}
+
+	for {
+		item, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if p.isOperator(",") {
+			p.next()
+			continue
+		}
+		if err := p.expect(closing); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package inspect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MaxDistinct bounds the distinct values counted per field, so scanning large inputs stays cheap.
+const MaxDistinct = 10000
+
+// MaxExamples is the number of distinct example values kept per field.
+const MaxExamples = 3
+
+// Field describes a leaf field found in the input records.
+type Field struct {
+	Path     string        `json:"path"`     // Dot-separated source path, e.g. "signInActivity.lastSignInDateTime".
+	Count    int           `json:"count"`    // Records holding a non-null value.
+	Nulls    int           `json:"nulls"`    // Records holding null.
+	Missing  int           `json:"missing"`  // Records without the field.
+	Nullable bool          `json:"nullable"` // Whether some records hold null or lack the field.
+	Distinct int           `json:"distinct"` // Distinct non-null values, at most MaxDistinct.
+	Types    []string      `json:"types"`    // JSON types of the non-null values, sorted.
+	Examples []interface{} `json:"examples"` // The first distinct non-null values, at most MaxExamples.
+}
+
+// Profile summarizes the fields of a set of input records.
+type Profile struct {
+	Records int     `json:"records"`
+	Fields  []Field `json:"fields"` // Ordered by path.
+}
+
+// Scan profiles the leaf fields of records. Nested objects are descended into; arrays are leaves.
+func Scan(records []map[string]interface{}) *Profile {
+	fields := make(map[string]*Field)
+	types := make(map[string]map[string]bool)
+	distinct := make(map[string]map[string]bool)
+
+	var walk func(prefix string, record map[string]interface{})
+	walk = func(prefix string, record map[string]interface{}) {
+		for key, value := range record {
+			path := key
+			if prefix != "" {
+				path = prefix + "." + key
+			}
+			if nested, ok := value.(map[string]interface{}); ok {
+				walk(path, nested)
+				continue
+			}
+
+			field, exists := fields[path]
+			if !exists {
+				field = &Field{Path: path}
+				fields[path] = field
+				types[path] = make(map[string]bool)
+				distinct[path] = make(map[string]bool)
+			}
+			if value == nil {
+				field.Nulls++
+				continue
+			}
+			field.Count++
+			types[path][typeOf(value)] = true
+
+			if len(distinct[path]) < MaxDistinct {
+				key, _ := json.Marshal(value)
+				if !distinct[path][string(key)] {
+					distinct[path][string(key)] = true
+					if len(field.Examples) < MaxExamples {
+						field.Examples = append(field.Examples, value)
+					}
+				}
+			}
+		}
+	}
+	for _, record := range records {
+		walk("", record)
+	}
+
+	profile := &Profile{Records: len(records), Fields: make([]Field, 0, len(fields))}
+	for path, field := range fields {
+		field.Missing = len(records) - field.Count - field.Nulls
+		field.Nullable = field.Nulls > 0 || field.Missing > 0
+		field.Distinct = len(distinct[path])
+		if field.Examples == nil {
+			field.Examples = []interface{}{}
+		}
+		field.Types = make([]string, 0, len(types[path]))
+		for t := range types[path] {
+			field.Types = append(field.Types, t)
+		}
+		sort.Strings(field.Types)
+		profile.Fields = append(profile.Fields, *field)
+	}
+	sort.Slice(profile.Fields, func(i, j int) bool { return profile.Fields[i].Path < profile.Fields[j].Path })
+	return profile
+}
+
+// typeOf names the JSON type of a decoded value.
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, int, int64, float32:
+		return "number"
+	case []interface{}:
+		return "array"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// WriteText writes the profile as an aligned table, one field per line.
+func (p *Profile) WriteText(w io.Writer) error {
+	width := len("FIELD")
+	for _, field := range p.Fields {
+		if len(field.Path) > width {
+			width = len(field.Path)
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "%d records, %d fields\n%-*s  %7s  %7s  %7s  %8s  %-16s  %s\n", p.Records, len(p.Fields),
+		width, "FIELD", "PRESENT", "NULL", "MISSING", "DISTINCT", "TYPES", "EXAMPLES"); err != nil {
+		return err
+	}
+	for _, field := range p.Fields {
+		distinct := fmt.Sprint(field.Distinct)
+		if field.Distinct >= MaxDistinct {
+			distinct += "+"
+		}
+		examples := make([]string, len(field.Examples))
+		for i, example := range field.Examples {
+			examples[i] = formatExample(example)
+		}
+
+		line := fmt.Sprintf("%-*s  %7d  %7d  %7d  %8s  %-16s  %s", width, field.Path, field.Count, field.Nulls, field.Missing,
+			distinct, strings.Join(field.Types, ", "), strings.Join(examples, ", "))
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatExample formats an example value as JSON, shortened to fit a table.
+func formatExample(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	if example := []rune(string(data)); len(example) > 32 {
+		return string(example[:31]) + "…"
+	}
+	return string(data)
+}
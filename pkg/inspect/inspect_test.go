@@ -0,0 +1,110 @@
+package inspect_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/inspect"
+	"pathid_assignment/pkg/rules"
+)
+
+func TestScan(t *testing.T) {
+	records := []map[string]interface{}{
+		{"id": "1", "accountEnabled": true, "signInActivity": map[string]interface{}{"lastSignInDateTime": "2024-01-01T00:00:00Z"}},
+		{"id": 2.0, "accountEnabled": false, "signInActivity": nil, "businessPhones": []interface{}{"+1 555"}},
+		{"id": "3", "accountEnabled": nil},
+		{"id": "1", "accountEnabled": true},
+	}
+
+	profile := inspect.Scan(records)
+
+	expected := &inspect.Profile{
+		Records: 4,
+		Fields: []inspect.Field{
+			{Path: "accountEnabled", Count: 3, Nulls: 1, Missing: 0, Nullable: true, Distinct: 2, Types: []string{"boolean"}, Examples: []interface{}{true, false}},
+			{Path: "businessPhones", Count: 1, Nulls: 0, Missing: 3, Nullable: true, Distinct: 1, Types: []string{"array"}, Examples: []interface{}{[]interface{}{"+1 555"}}},
+			{Path: "id", Count: 4, Nulls: 0, Missing: 0, Distinct: 3, Types: []string{"number", "string"}, Examples: []interface{}{"1", 2.0, "3"}},
+			{Path: "signInActivity", Count: 0, Nulls: 1, Missing: 3, Nullable: true, Distinct: 0, Types: []string{}, Examples: []interface{}{}},
+			{Path: "signInActivity.lastSignInDateTime", Count: 1, Nulls: 0, Missing: 3, Nullable: true, Distinct: 1, Types: []string{"string"}, Examples: []interface{}{"2024-01-01T00:00:00Z"}},
+		},
+	}
+	if !reflect.DeepEqual(profile, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, profile)
+	}
+}
+
+func TestProfile_WriteText(t *testing.T) {
+	profile := inspect.Scan([]map[string]interface{}{{"id": "1", "mail": nil}})
+
+	var buf bytes.Buffer
+	if err := profile.WriteText(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "1 records, 2 fields\n" +
+		"FIELD  PRESENT     NULL  MISSING  DISTINCT  TYPES             EXAMPLES\n" +
+		"id           1        0        0         1  string            \"1\"\n" +
+		"mail         0        1        0         0\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestProfile_Rules(t *testing.T) {
+	profile := inspect.Scan([]map[string]interface{}{
+		{"id": "1", "@odata.type": "#microsoft.graph.user", "userPrincipalName": "a@example.com", "signInActivity": map[string]interface{}{"lastSignInDateTime": "2024-01-01T00:00:00Z"}},
+		{"id": "2", "signInActivity": nil},
+	})
+
+	starter := profile.Rules(inspect.SnakeCase)
+	if starter.Fields() != 3 {
+		t.Errorf("Expected 3 target fields, got %d", starter.Fields())
+	}
+
+	var buf bytes.Buffer
+	if err := starter.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON failed: %v", err)
+	}
+	expected := `{
+  "id": "id",
+  "sign_in_activity": {
+    "last_sign_in_date_time": "signInActivity.lastSignInDateTime"
+  },
+  "user_principal_name": "userPrincipalName"
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	// The starter rules are valid rules.
+	if _, err := rules.Parse(buf.Bytes()); err != nil {
+		t.Errorf("Expected valid rules, got %v", err)
+	}
+
+	buf.Reset()
+	if err := profile.Rules(inspect.KeepNames).WriteYAML(&buf); err != nil {
+		t.Fatalf("WriteYAML failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "signInActivity:\n  lastSignInDateTime: signInActivity.lastSignInDateTime\n") {
+		t.Errorf("Unexpected YAML rules:\n%s", buf.String())
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, expected := range map[string]string{
+		"id":                 "id",
+		"givenName":          "given_name",
+		"userPrincipalName":  "user_principal_name",
+		"lastSignInDateTime": "last_sign_in_date_time",
+		"onPremisesSID":      "on_premises_sid",
+		"userIDValue":        "user_id_value",
+		"extension_1a2b":     "extension_1a2b",
+	} {
+		if snake := inspect.SnakeCase(name); snake != expected {
+			t.Errorf("SnakeCase(%q): expected %q, got %q", name, expected, snake)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package inspect
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Naming turns a source field name into a target field name.
+type Naming func(string) string
+
+// KeepNames uses source field names as target names.
+func KeepNames(name string) string {
+	return name
+}
+
+// SnakeCase turns camelCase source names into snake_case target names, e.g. "userPrincipalName" into
+// "user_principal_name", like the targets of the default rules.
+func SnakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// An upper-case letter starts a word after a lower-case letter or digit, or ends an acronym
+			// followed by a lower-case letter, e.g. "ID" in "userIDValue".
+			startsWord := i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])))
+			if startsWord {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		if r == '-' || r == ' ' {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ruleNode is a target field of starter rules: a source path, or a group of fields.
+type ruleNode struct {
+	target string
+	path   string
+	fields []*ruleNode
+}
+
+// Rules builds starter rules mapping every field of the profile to a target named by naming, keeping the
+// nesting of the source. Graph annotations ("@odata.type") are left out, and so are fields that are null in
+// some records and an object in others, whose nested fields are mapped instead.
+func (p *Profile) Rules(naming Naming) *StarterRules {
+	root := &ruleNode{}
+	for _, field := range p.Fields {
+		if strings.HasPrefix(field.Path, "@") || strings.Contains(field.Path, ".@") || p.isObject(field.Path) {
+			continue
+		}
+		root.add(strings.Split(field.Path, "."), field.Path, naming)
+	}
+	return &StarterRules{root: root}
+}
+
+// isObject reports whether a path holds nested fields in some records.
+func (p *Profile) isObject(path string) bool {
+	for _, field := range p.Fields {
+		if strings.HasPrefix(field.Path, path+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds the field at the source keys below the node, skipping targets that are already taken.
+func (n *ruleNode) add(keys []string, path string, naming Naming) {
+	target := naming(keys[0])
+	var child *ruleNode
+	for _, field := range n.fields {
+		if field.target == target {
+			child = field
+		}
+	}
+
+	if len(keys) == 1 {
+		if child == nil {
+			n.fields = append(n.fields, &ruleNode{target: target, path: path})
+		}
+		return
+	}
+	if child == nil {
+		child = &ruleNode{target: target}
+		n.fields = append(n.fields, child)
+	}
+	if child.path == "" {
+		child.add(keys[1:], path, naming)
+	}
+}
+
+// StarterRules are rules inferred from a profile, to be completed by hand.
+type StarterRules struct {
+	root *ruleNode
+}
+
+// Fields returns the number of target fields of the rules.
+func (r *StarterRules) Fields() int {
+	var count func(n *ruleNode) int
+	count = func(n *ruleNode) int {
+		if n.path != "" {
+			return 1
+		}
+		total := 0
+		for _, field := range n.fields {
+			total += count(field)
+		}
+		return total
+	}
+	return count(r.root)
+}
+
+// WriteJSON writes the rules as a JSON rules file, keeping the order of the fields.
+func (r *StarterRules) WriteJSON(w io.Writer) error {
+	var b bytes.Buffer
+	var write func(n *ruleNode, indent string)
+	write = func(n *ruleNode, indent string) {
+		b.WriteString("{")
+		for i, field := range n.fields {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			key, _ := json.Marshal(field.target)
+			b.WriteString("\n" + indent + "  " + string(key) + ": ")
+			if field.path != "" {
+				value, _ := json.Marshal(field.path)
+				b.Write(value)
+				continue
+			}
+			write(field, indent+"  ")
+		}
+		if len(n.fields) > 0 {
+			b.WriteString("\n" + indent)
+		}
+		b.WriteString("}")
+	}
+	write(r.root, "")
+	b.WriteString("\n")
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// WriteYAML writes the rules as a YAML rules file, keeping the order of the fields.
+func (r *StarterRules) WriteYAML(w io.Writer) error {
+	var node func(n *ruleNode) *yaml.Node
+	node = func(n *ruleNode) *yaml.Node {
+		if n.path != "" {
+			return &yaml.Node{Kind: yaml.ScalarNode, Value: n.path}
+		}
+		mapping := &yaml.Node{Kind: yaml.MappingNode}
+		for _, field := range n.fields {
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: field.target}, node(field))
+		}
+		return mapping
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node(r.root)); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
@@ -0,0 +1,182 @@
+package lookup
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Unmatched handling modes for values that have no entry in a lookup table.
+const (
+	// UnmatchedKeep leaves the source value unchanged.
+	UnmatchedKeep = "keep"
+	// UnmatchedDefault replaces the source value with the rule's default value.
+	UnmatchedDefault = "default"
+	// UnmatchedFail fails the transformation of the record.
+	UnmatchedFail = "fail"
+)
+
+// Spec describes where a lookup table is loaded from.
+// Key and Value name the columns (CSV) or properties (JSON array) holding the lookup key and value.
+// When Value is empty, every column except the key is returned as an object.
+type Spec struct {
+	File  string `json:"file"`
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Table is an in-memory dictionary loaded from a side file.
+type Table struct {
+	entries map[string]interface{}
+}
+
+// Get returns the value mapped to the given key. Non-string keys are matched by their string form.
+func (t *Table) Get(key interface{}) (interface{}, bool) {
+	val, exists := t.entries[fmt.Sprint(key)]
+	return val, exists
+}
+
+// Len returns the number of entries in the table.
+func (t *Table) Len() int {
+	return len(t.entries)
+}
+
+// Invert returns a table mapping values back to their keys. It fails when two keys share a value,
+// or when values are objects rather than scalars, since such tables cannot be reversed.
+func (t *Table) Invert() (*Table, error) {
+	entries := make(map[string]interface{}, len(t.entries))
+	for key, value := range t.entries {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("value of key %q is not a scalar", key)
+		}
+
+		reversed := fmt.Sprint(value)
+		if existing, exists := entries[reversed]; exists {
+			return nil, fmt.Errorf("keys %q and %q share the value %q", existing, key, reversed)
+		}
+		entries[reversed] = key
+	}
+	return &Table{entries: entries}, nil
+}
+
+// Load reads a lookup table from a CSV or JSON file, depending on its extension.
+//
+// CSV files must have a header row. JSON files hold either an object mapping keys to values,
+// or an array of objects from which the Key and Value properties are taken.
+func Load(spec Spec) (*Table, error) {
+	data, err := os.ReadFile(spec.File)
+	if err != nil {
+		return nil, fmt.Errorf("reading lookup file: %w", err)
+	}
+
+	var rows []map[string]interface{}
+	switch strings.ToLower(filepath.Ext(spec.File)) {
+	case ".csv":
+		rows, err = parseCSV(data)
+	case ".json":
+		var object map[string]interface{}
+		if err := json.Unmarshal(data, &object); err == nil {
+			return &Table{entries: object}, nil
+		}
+		err = json.Unmarshal(data, &rows)
+	default:
+		return nil, fmt.Errorf("unsupported lookup file format %q", spec.File)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing lookup file %s: %w", spec.File, err)
+	}
+
+	return fromRows(spec, rows)
+}
+
+// fromRows builds a table from row objects using the spec's key and value columns.
+func fromRows(spec Spec, rows []map[string]interface{}) (*Table, error) {
+	if spec.Key == "" {
+		return nil, fmt.Errorf("lookup file %s requires a key column", spec.File)
+	}
+
+	entries := make(map[string]interface{}, len(rows))
+	for i, row := range rows {
+		key, exists := row[spec.Key]
+		if !exists {
+			return nil, fmt.Errorf("lookup file %s: row %d has no %q column", spec.File, i+1, spec.Key)
+		}
+
+		if spec.Value != "" {
+			entries[fmt.Sprint(key)] = row[spec.Value]
+			continue
+		}
+
+		value := make(map[string]interface{}, len(row)-1)
+		for column, v := range row {
+			if column != spec.Key {
+				value[column] = v
+			}
+		}
+		entries[fmt.Sprint(key)] = value
+	}
+
+	return &Table{entries: entries}, nil
+}
+
+// parseCSV reads CSV data with a header row into row objects.
+func parseCSV(data []byte) ([]map[string]interface{}, error) {
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing header row")
+	}
+
+	header := records[0]
+	rows := make([]map[string]interface{}, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// Cache loads each lookup table at most once and shares it between concurrent callers.
+// The zero value is ready to use.
+type Cache struct {
+	mutex   sync.Mutex
+	entries map[Spec]*cacheEntry
+}
+
+type cacheEntry struct {
+	once  sync.Once
+	table *Table
+	err   error
+}
+
+// Load returns the table for the given spec, loading it on first use.
+// Load errors are cached as well, so a missing file is reported without being re-read for every record.
+func (c *Cache) Load(spec Spec) (*Table, error) {
+	c.mutex.Lock()
+	if c.entries == nil {
+		c.entries = make(map[Spec]*cacheEntry)
+	}
+	entry, exists := c.entries[spec]
+	if !exists {
+		entry = &cacheEntry{}
+		c.entries[spec] = entry
+	}
+	c.mutex.Unlock()
+
+	entry.once.Do(func() {
+		entry.table, entry.err = Load(spec)
+	})
+	return entry.table, entry.err
+}
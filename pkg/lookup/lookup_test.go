@@ -0,0 +1,93 @@
+package lookup_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pathid_assignment/pkg/lookup"
+)
+
+func TestLoad_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "countries.csv")
+	csvData := "code,name,region\nUS,United States,Americas\nIL,Israel,Asia\n"
+	if err := os.WriteFile(path, []byte(csvData), 0644); err != nil {
+		t.Fatalf("Failed to write lookup file: %v", err)
+	}
+
+	names, err := lookup.Load(lookup.Spec{File: path, Key: "code", Value: "name"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if val, _ := names.Get("IL"); val != "Israel" {
+		t.Errorf("Expected 'Israel', got '%v'", val)
+	}
+
+	rows, err := lookup.Load(lookup.Spec{File: path, Key: "code"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	row, _ := rows.Get("US")
+	if row.(map[string]interface{})["region"] != "Americas" {
+		t.Errorf("Expected region 'Americas', got '%v'", row)
+	}
+}
+
+func TestLoad_JSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+	if err := os.WriteFile(path, []byte(`{"Member": "employee", "Guest": "contractor"}`), 0644); err != nil {
+		t.Fatalf("Failed to write lookup file: %v", err)
+	}
+
+	var cache lookup.Cache
+	table, err := cache.Load(lookup.Spec{File: path})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if table.Len() != 2 {
+		t.Errorf("Expected 2 entries, got %d", table.Len())
+	}
+
+	again, _ := cache.Load(lookup.Spec{File: path})
+	if again != table {
+		t.Errorf("Expected the cached table to be reused")
+	}
+
+	if _, exists := table.Get("Owner"); exists {
+		t.Errorf("Expected 'Owner' to be unmatched")
+	}
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	if _, err := lookup.Load(lookup.Spec{File: "missing.csv", Key: "code"}); err == nil {
+		t.Fatal("Expected an error for a missing lookup file, but got none")
+	}
+}
+
+func TestTable_Invert(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roles.json")
+	if err := os.WriteFile(path, []byte(`{"Member": "employee", "Guest": "contractor"}`), 0644); err != nil {
+		t.Fatalf("Failed to write lookup file: %v", err)
+	}
+
+	table, err := lookup.Load(lookup.Spec{File: path})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	inverse, err := table.Invert()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if val, _ := inverse.Get("contractor"); val != "Guest" {
+		t.Errorf("Expected 'Guest', got '%v'", val)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"Member": "employee", "Owner": "employee"}`), 0644); err != nil {
+		t.Fatalf("Failed to write lookup file: %v", err)
+	}
+	table, _ = lookup.Load(lookup.Spec{File: path})
+	if _, err := table.Invert(); err == nil {
+		t.Errorf("Expected an error for a table with duplicate values")
+	}
+}
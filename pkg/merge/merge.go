@@ -0,0 +1,205 @@
+package merge
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// Strategy selects how records sharing the same key are merged into one.
+type Strategy string
+
+const (
+	// None keeps every record, duplicates included.
+	None Strategy = "none"
+	// First keeps the first record read for a key.
+	First Strategy = "first"
+	// Last keeps the last record read for a key.
+	Last Strategy = "last"
+	// Newest keeps the record with the latest modification time; ties go to the record read last.
+	Newest Strategy = "newest"
+	// Fields merges every record field by field: each field takes the last non-null value read.
+	Fields Strategy = "fields"
+)
+
+// Strategies lists every merge strategy, in the order they are documented.
+var Strategies = []Strategy{None, First, Last, Newest, Fields}
+
+// ParseStrategy returns the strategy with the given name.
+func ParseStrategy(name string) (Strategy, error) {
+	for _, strategy := range Strategies {
+		if string(strategy) == name {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown merge strategy %q, expected one of none, first, last, newest or fields", name)
+}
+
+// Entry is a transformed record with the information needed to merge it.
+type Entry struct {
+	Record   map[string]interface{}
+	Source   string    // Input file the record was read from.
+	Modified time.Time // Last modification of the source record, zero when unknown.
+}
+
+// Value is one of several differing values found for a field.
+type Value struct {
+	Source string      `json:"source"`
+	Value  interface{} `json:"value"`
+}
+
+// Conflict reports a field holding different non-null values in records sharing the same key.
+type Conflict struct {
+	Key    string      `json:"key"`
+	Field  string      `json:"field"` // Dot-separated field path, e.g. "sign_in_activity.lastSignInDateTime".
+	Values []Value     `json:"values"`
+	Kept   interface{} `json:"kept"`
+}
+
+// Result holds the merged records and what the merge found.
+type Result struct {
+	Records    []map[string]interface{}
+	Duplicates int // Records merged into another record with the same key.
+	Conflicts  []Conflict
+}
+
+// Deduplicate merges entries sharing the same value of the key field, which must be in the order they
+// were read. Merged records take the position of the first record with their key; records without
+// a key are kept as they are.
+func Deduplicate(entries []Entry, key string, strategy Strategy) Result {
+	var result Result
+
+	groups := make(map[interface{}][]Entry)
+	var order []interface{}
+	for i, entry := range entries {
+		id, exists := entry.Record[key]
+		if !exists || id == nil || strategy == None || !hashable(id) {
+			order = append(order, unkeyed(i)) // A unique placeholder keeps the record in place.
+			groups[unkeyed(i)] = []Entry{entry}
+			continue
+		}
+		if _, seen := groups[id]; !seen {
+			order = append(order, id)
+		}
+		groups[id] = append(groups[id], entry)
+	}
+
+	for _, id := range order {
+		group := groups[id]
+		if len(group) == 1 {
+			result.Records = append(result.Records, group[0].Record)
+			continue
+		}
+
+		merged := resolve(group, strategy)
+		result.Records = append(result.Records, merged)
+		result.Duplicates += len(group) - 1
+		result.Conflicts = append(result.Conflicts, conflicts(fmt.Sprint(id), group, merged)...)
+	}
+
+	return result
+}
+
+// resolve merges the records of a group according to the strategy.
+func resolve(group []Entry, strategy Strategy) map[string]interface{} {
+	switch strategy {
+	case Last:
+		return group[len(group)-1].Record
+	case Newest:
+		newest := group[0]
+		for _, entry := range group[1:] {
+			if !entry.Modified.Before(newest.Modified) {
+				newest = entry
+			}
+		}
+		return newest.Record
+	case Fields:
+		merged := make(map[string]interface{})
+		for _, entry := range group {
+			mergeFields(merged, entry.Record)
+		}
+		return merged
+	}
+	return group[0].Record
+}
+
+// mergeFields copies the fields of src into dst, descending into nested objects. Null values only
+// fill fields dst does not have yet.
+func mergeFields(dst, src map[string]interface{}) {
+	for key, value := range src {
+		existing, exists := dst[key]
+		if value == nil {
+			if !exists {
+				dst[key] = nil
+			}
+			continue
+		}
+
+		srcObject, srcIsObject := value.(map[string]interface{})
+		dstObject, dstIsObject := existing.(map[string]interface{})
+		switch {
+		case srcIsObject && dstIsObject:
+			mergeFields(dstObject, srcObject)
+		case srcIsObject:
+			copied := make(map[string]interface{}, len(srcObject))
+			mergeFields(copied, srcObject)
+			dst[key] = copied
+		default:
+			dst[key] = value
+		}
+	}
+}
+
+// conflicts lists the fields holding different non-null values across the records of a group.
+func conflicts(key string, group []Entry, merged map[string]interface{}) []Conflict {
+	values := make(map[string][]Value)
+	for _, entry := range group {
+		flatten("", entry.Record, func(field string, value interface{}) {
+			for _, existing := range values[field] {
+				if reflect.DeepEqual(existing.Value, value) {
+					return
+				}
+			}
+			values[field] = append(values[field], Value{Source: entry.Source, Value: value})
+		})
+	}
+
+	kept := make(map[string]interface{})
+	flatten("", merged, func(field string, value interface{}) { kept[field] = value })
+
+	var found []Conflict
+	for field, fieldValues := range values {
+		if len(fieldValues) > 1 {
+			found = append(found, Conflict{Key: key, Field: field, Values: fieldValues, Kept: kept[field]})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Field < found[j].Field })
+	return found
+}
+
+// flatten calls fn with the dot-separated path of every non-null leaf value of a record.
+func flatten(prefix string, record map[string]interface{}, fn func(field string, value interface{})) {
+	for key, value := range record {
+		field := key
+		if prefix != "" {
+			field = prefix + "." + key
+		}
+
+		switch v := value.(type) {
+		case nil:
+		case map[string]interface{}:
+			flatten(field, v, fn)
+		default:
+			fn(field, v)
+		}
+	}
+}
+
+// unkeyed identifies a record without a usable key by its position.
+type unkeyed int
+
+// hashable reports whether a key value can be used as a map key.
+func hashable(value interface{}) bool {
+	return reflect.TypeOf(value).Comparable()
+}
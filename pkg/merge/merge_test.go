@@ -0,0 +1,93 @@
+package merge_test
+
+import (
+	"testing"
+	"time"
+
+	"pathid_assignment/pkg/merge"
+)
+
+func entries() []merge.Entry {
+	return []merge.Entry{
+		{
+			Record:   map[string]interface{}{"id": "1", "first_name": "Ann", "mail": nil, "sign_in_activity": map[string]interface{}{"lastSignInDateTime": "2024-01-01T00:00:00Z"}},
+			Source:   "part_1.json",
+			Modified: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Record: map[string]interface{}{"first_name": "No id"},
+			Source: "part_1.json",
+		},
+		{
+			Record:   map[string]interface{}{"id": "1", "first_name": "Anne", "mail": "ann@example.com"},
+			Source:   "part_2.json",
+			Modified: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestDeduplicate_Strategies(t *testing.T) {
+	tests := []struct {
+		strategy  merge.Strategy
+		firstName string
+		mail      interface{}
+	}{
+		{merge.First, "Ann", nil},
+		{merge.Last, "Anne", "ann@example.com"},
+		{merge.Newest, "Ann", nil},
+		{merge.Fields, "Anne", "ann@example.com"},
+	}
+
+	for _, test := range tests {
+		result := merge.Deduplicate(entries(), "id", test.strategy)
+		if len(result.Records) != 2 || result.Duplicates != 1 {
+			t.Errorf("%s: expected 2 records and 1 duplicate, got %d and %d", test.strategy, len(result.Records), result.Duplicates)
+			continue
+		}
+
+		merged := result.Records[0]
+		if merged["first_name"] != test.firstName || merged["mail"] != test.mail {
+			t.Errorf("%s: unexpected merged record %v", test.strategy, merged)
+		}
+		if result.Records[1]["first_name"] != "No id" {
+			t.Errorf("%s: expected the record without id to keep its position, got %v", test.strategy, result.Records[1])
+		}
+	}
+
+	merged := merge.Deduplicate(entries(), "id", merge.Fields).Records[0]
+	if _, exists := merged["sign_in_activity"]; !exists {
+		t.Errorf("Expected the field merge to keep sign_in_activity, got %v", merged)
+	}
+}
+
+func TestDeduplicate_Conflicts(t *testing.T) {
+	result := merge.Deduplicate(entries(), "id", merge.First)
+
+	// A null mail is not a conflict, only the differing first names are.
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("Expected 1 conflict, got %+v", result.Conflicts)
+	}
+	conflict := result.Conflicts[0]
+	if conflict.Key != "1" || conflict.Field != "first_name" || conflict.Kept != "Ann" || len(conflict.Values) != 2 {
+		t.Errorf("Unexpected conflict: %+v", conflict)
+	}
+	if conflict.Values[1].Source != "part_2.json" {
+		t.Errorf("Expected the conflicting value's source, got %+v", conflict.Values[1])
+	}
+}
+
+func TestDeduplicate_None(t *testing.T) {
+	result := merge.Deduplicate(entries(), "id", merge.None)
+	if len(result.Records) != 3 || result.Duplicates != 0 || len(result.Conflicts) != 0 {
+		t.Errorf("Expected every record to be kept, got %+v", result)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	if strategy, err := merge.ParseStrategy("newest"); err != nil || strategy != merge.Newest {
+		t.Errorf("Expected newest, got %v, %v", strategy, err)
+	}
+	if _, err := merge.ParseStrategy("oldest"); err == nil {
+		t.Errorf("Expected an error for an unknown strategy")
+	}
+}
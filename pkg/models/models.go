@@ -5,15 +5,16 @@ import "sync"
 type UserModel struct {
 	Users      []map[string]interface{}
 	Activities []map[string]interface{}
+	Rejects    []map[string]interface{}
 	UserMutex  sync.Mutex
 }
 
 type DefaultStructure struct {
-	Id             string          `json:"id"`
-	ExternalID     string          `json:"external_id"`
-	Mail           string          `json:"mail"`
+	Id             string          `json:"id" format:"uuid"`
+	ExternalID     string          `json:"external_id" format:"upn"`
+	Mail           *string         `json:"mail"`
 	Type           string          `json:"type"`
-	Location       string          `json:"location"`
+	Location       string          `json:"location" format:"country_code"`
 	IsEnabled      bool            `json:"is_enabled"`
 	FirstName      string          `json:"first_name"`
 	LastName       string          `json:"last_name"`
@@ -28,23 +29,3 @@ type SignInActivity struct {
 	LastSuccessfulSignInDateTime      string `json:"lastSuccessfulSignInDateTime"`
 	LastSuccessfulSignInRequestId     string `json:"lastSuccessfulSignInRequestId"`
 }
-
-const DEFAULT_RULES_MODEL = `
-{
-	"id": "id",
-	"external_id": "userPrincipalName",
-	"mail": "mail",
-	"type": "userType",
-	"location": "usageLocation",
-	"is_enabled": "accountEnabled",
-	"first_name": "givenName",
-	"last_name": "surname",
-	"sign_in_activity": {
-		"lastSignInDateTime": "signInActivity.lastSignInDateTime",
-		"lastSignInRequestId": "signInActivity.lastSignInRequestId",
-		"lastNonInteractiveSignInDateTime": "signInActivity.lastNonInteractiveSignInDateTime",
-		"lastNonInteractiveSignInRequestId": "signInActivity.lastNonInteractiveSignInRequestId",
-		"lastSuccessfulSignInDateTime": "signInActivity.lastSuccessfulSignInDateTime",
-		   "lastSuccessfulSignInRequestId": "signInActivity.lastSuccessfulSignInRequestId"
-	}
-}`
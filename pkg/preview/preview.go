@@ -0,0 +1,204 @@
+package preview
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"pathid_assignment/pkg/schema"
+)
+
+// DefaultSize is the number of records sampled per file when no size is given.
+const DefaultSize = 5
+
+// Options select the records sampled from every input file.
+type Options struct {
+	Size   int   // Records sampled per file, DefaultSize when zero.
+	Random bool  // Samples records at random instead of the first ones.
+	Seed   int64 // Seed of the random samples, so they can be repeated.
+}
+
+// Sample returns the positions of the records sampled out of count, in input order.
+func (o Options) Sample(count int, random *rand.Rand) []int {
+	size := o.Size
+	if size <= 0 {
+		size = DefaultSize
+	}
+	if size > count {
+		size = count
+	}
+
+	var sampled []int
+	if o.Random {
+		sampled = random.Perm(count)[:size]
+		sort.Ints(sampled)
+		return sampled
+	}
+	for i := 0; i < size; i++ {
+		sampled = append(sampled, i)
+	}
+	return sampled
+}
+
+// Record is a sampled source record with its transformation.
+type Record struct {
+	File       string                 `json:"file"`
+	Index      int                    `json:"index"` // Position of the record in its file, from 1.
+	Source     map[string]interface{} `json:"source"`
+	Target     map[string]interface{} `json:"target,omitempty"`
+	Filtered   bool                   `json:"filtered,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Violations []schema.Violation     `json:"violations,omitempty"`
+}
+
+// Coverage tells how often a target field was populated in the sampled records.
+type Coverage struct {
+	Field     string  `json:"field"`
+	Populated int     `json:"populated"` // Transformed records holding a non-null value.
+	Percent   float64 `json:"percent"`
+}
+
+// Preview holds the sampled records of a dry run and the coverage of the target fields.
+type Preview struct {
+	Files       int        `json:"files"`
+	FailedFiles int        `json:"failed_files"`
+	Records     int        `json:"records"` // Records of the input files, sampled or not.
+	Sampled     int        `json:"sampled"`
+	Transformed int        `json:"transformed"`
+	Filtered    int        `json:"filtered"`
+	Failed      int        `json:"failed"`
+	Rejected    int        `json:"rejected"`
+	Seed        int64      `json:"seed,omitempty"` // Seed of random samples.
+	Samples     []Record   `json:"samples"`
+	Coverage    []Coverage `json:"coverage"`
+}
+
+// Add records a sampled record and counts its outcome.
+func (p *Preview) Add(record Record) {
+	p.Sampled++
+	switch {
+	case record.Filtered:
+		p.Filtered++
+	case record.Error != "":
+		p.Failed++
+	case len(record.Violations) > 0:
+		p.Rejected++
+	default:
+		p.Transformed++
+	}
+	p.Samples = append(p.Samples, record)
+}
+
+// Cover computes how often each target field was populated in the transformed records, rejected ones included.
+func (p *Preview) Cover(targets []string) {
+	total := 0
+	for _, record := range p.Samples {
+		if record.Target != nil {
+			total++
+		}
+	}
+
+	p.Coverage = make([]Coverage, 0, len(targets))
+	for _, target := range targets {
+		coverage := Coverage{Field: target}
+		for _, record := range p.Samples {
+			if record.Target != nil && lookup(record.Target, target) != nil {
+				coverage.Populated++
+			}
+		}
+		if total > 0 {
+			coverage.Percent = float64(coverage.Populated) * 100 / float64(total)
+		}
+		p.Coverage = append(p.Coverage, coverage)
+	}
+}
+
+// lookup returns the value at a dot-separated path of a record, nil when missing.
+func lookup(record map[string]interface{}, path string) interface{} {
+	var value interface{} = record
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+// WriteText writes every sampled record next to its transformation, followed by the field coverage.
+func (p *Preview) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, record := range p.Samples {
+		fmt.Fprintf(&b, "== %s, record %d\n", record.File, record.Index)
+
+		var right []string
+		switch {
+		case record.Filtered:
+			right = []string{"(filtered out by the rules)"}
+		case record.Error != "":
+			right = []string{"error: " + record.Error}
+		default:
+			right = indented(record.Target)
+		}
+		sideBySide(&b, indented(record.Source), right)
+
+		for _, violation := range record.Violations {
+			fmt.Fprintf(&b, "rejected: %s\n", violation)
+		}
+		b.WriteString("\n")
+	}
+
+	fmt.Fprintf(&b, "Sampled %d of %d records from %d files: %d transformed, %d filtered out, %d failed, %d rejected\n",
+		p.Sampled, p.Records, p.Files, p.Transformed, p.Filtered, p.Failed, p.Rejected)
+	if p.Seed != 0 {
+		fmt.Fprintf(&b, "Random sample seed: %d\n", p.Seed)
+	}
+
+	width := len("FIELD")
+	for _, coverage := range p.Coverage {
+		if len(coverage.Field) > width {
+			width = len(coverage.Field)
+		}
+	}
+	fmt.Fprintf(&b, "\n%-*s  %9s  %7s\n", width, "FIELD", "POPULATED", "PERCENT")
+	for _, coverage := range p.Coverage {
+		fmt.Fprintf(&b, "%-*s  %9d  %6.1f%%\n", width, coverage.Field, coverage.Populated, coverage.Percent)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sideBySide writes two columns of lines, the left one padded to its widest line.
+func sideBySide(b *strings.Builder, left, right []string) {
+	width := len("SOURCE")
+	for _, line := range left {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+
+	fmt.Fprintf(b, "%-*s | %s\n", width, "SOURCE", "TARGET")
+	for i := 0; i < len(left) || i < len(right); i++ {
+		var l, r string
+		if i < len(left) {
+			l = left[i]
+		}
+		if i < len(right) {
+			r = right[i]
+		}
+		b.WriteString(strings.TrimRight(fmt.Sprintf("%-*s | %s", width, l, r), " ") + "\n")
+	}
+}
+
+func indented(record map[string]interface{}) []string {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return strings.Split(string(data), "\n")
+}
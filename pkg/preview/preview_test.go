@@ -0,0 +1,59 @@
+package preview_test
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/preview"
+)
+
+func TestOptions_Sample(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	if sampled := (preview.Options{Size: 3}).Sample(10, random); !reflect.DeepEqual(sampled, []int{0, 1, 2}) {
+		t.Errorf("Expected the first 3 records, got %v", sampled)
+	}
+	if sampled := (preview.Options{}).Sample(2, random); !reflect.DeepEqual(sampled, []int{0, 1}) {
+		t.Errorf("Expected every record of a short file, got %v", sampled)
+	}
+
+	sampled := (preview.Options{Size: 4, Random: true}).Sample(100, random)
+	if len(sampled) != 4 {
+		t.Fatalf("Expected 4 records, got %v", sampled)
+	}
+	for i := 1; i < len(sampled); i++ {
+		if sampled[i] <= sampled[i-1] {
+			t.Errorf("Expected random samples in input order, got %v", sampled)
+		}
+	}
+}
+
+func TestPreview_WriteText(t *testing.T) {
+	p := &preview.Preview{Files: 1, Records: 3}
+	p.Add(preview.Record{File: "users.json", Index: 1, Source: map[string]interface{}{"id": "1"}, Target: map[string]interface{}{"id": "1", "sign_in_activity": map[string]interface{}{"last": nil}}})
+	p.Add(preview.Record{File: "users.json", Index: 2, Source: map[string]interface{}{"id": "2"}, Filtered: true})
+	p.Cover([]string{"id", "sign_in_activity.last"})
+
+	if p.Coverage[0].Percent != 100 || p.Coverage[1].Populated != 0 {
+		t.Errorf("Expected id fully covered and the null sign-in not populated, got %+v", p.Coverage)
+	}
+
+	var buf bytes.Buffer
+	if err := p.WriteText(&buf); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	for _, expected := range []string{
+		"== users.json, record 1\nSOURCE      | TARGET\n{           | {\n  \"id\": \"1\" |   \"id\": \"1\",\n",
+		"{           | (filtered out by the rules)\n  \"id\": \"2\" |\n",
+		"Sampled 2 of 3 records from 1 files: 1 transformed, 1 filtered out, 0 failed, 0 rejected",
+		"id                             1   100.0%",
+		"sign_in_activity.last          0     0.0%",
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package privacy
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// FF1 is the FF1 format-preserving encryption mode of NIST SP 800-38G with AES. It encrypts a string of
+// numerals in a radix into another string of the same length and radix, and is a permutation of those
+// strings for every key and tweak: distinct plaintexts never share a ciphertext.
+type FF1 struct {
+	block cipher.Block
+}
+
+// NewFF1 returns FF1 with an AES key of 16, 24 or 32 bytes.
+func NewFF1(key []byte) (*FF1, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &FF1{block: block}, nil
+}
+
+// Encrypt encrypts numerals in the given radix, from 2 to 65536, with a tweak.
+func (f *FF1) Encrypt(tweak []byte, numerals []uint16, radix int) ([]uint16, error) {
+	return f.cipher(tweak, numerals, radix, true)
+}
+
+// Decrypt decrypts numerals encrypted with the same tweak and radix.
+func (f *FF1) Decrypt(tweak []byte, numerals []uint16, radix int) ([]uint16, error) {
+	return f.cipher(tweak, numerals, radix, false)
+}
+
+// cipher runs the ten Feistel rounds of FF1, forward to encrypt and backward to decrypt. The standard
+// asks for at least a million possible values per radix and length; shorter strings are still permuted,
+// but a permutation of few values protects them little.
+func (f *FF1) cipher(tweak []byte, numerals []uint16, radix int, encrypt bool) ([]uint16, error) {
+	if radix < 2 || radix > 1<<16 {
+		return nil, fmt.Errorf("FF1 radix %d is out of range", radix)
+	}
+	for _, numeral := range numerals {
+		if int(numeral) >= radix {
+			return nil, fmt.Errorf("FF1 numeral %d is out of radix %d", numeral, radix)
+		}
+	}
+	n := len(numerals)
+	if n == 0 {
+		return nil, errors.New("FF1 needs at least one numeral")
+	}
+
+	u, v := n/2, n-n/2
+	bigRadix := big.NewInt(int64(radix))
+	b := (bitLength(bigRadix, v) + 7) / 8
+	d := 4*((b+3)/4) + 4
+	moduli := [2]*big.Int{new(big.Int).Exp(bigRadix, big.NewInt(int64(u)), nil), new(big.Int).Exp(bigRadix, big.NewInt(int64(v)), nil)}
+
+	p := make([]byte, aes.BlockSize, aes.BlockSize+len(tweak)+b+1+15)
+	p[0], p[1], p[2] = 1, 2, 1
+	p[3], p[4], p[5] = byte(radix>>16), byte(radix>>8), byte(radix)
+	p[6], p[7] = 10, byte(u)
+	binary.BigEndian.PutUint32(p[8:], uint32(n))
+	binary.BigEndian.PutUint32(p[12:], uint32(len(tweak)))
+
+	a, bb := num(numerals[:u], bigRadix), num(numerals[u:], bigRadix)
+	for step := 0; step < 10; step++ {
+		i, source := step, bb
+		if !encrypt {
+			i, source = 9-step, a
+		}
+
+		// Q = T || 0^((-t-b-1) mod 16) || [i]^1 || [NUM(B)]^b
+		q := append(p[:aes.BlockSize], tweak...)
+		q = append(q, make([]byte, ((-len(tweak)-b-1)%16+16)%16)...)
+		q = append(q, byte(i))
+		q = append(q, source.FillBytes(make([]byte, b))...)
+		y := new(big.Int).SetBytes(f.expand(f.prf(q), d))
+
+		m := moduli[i%2]
+		c := new(big.Int)
+		if encrypt {
+			c.Add(a, y).Mod(c, m)
+			a, bb = bb, c
+		} else {
+			c.Sub(bb, y).Mod(c, m)
+			a, bb = c, a
+		}
+	}
+	return append(str(a, bigRadix, u), str(bb, bigRadix, v)...), nil
+}
+
+// prf is the CBC-MAC of data, a whole number of blocks, with a zero IV.
+func (f *FF1) prf(data []byte) []byte {
+	r := make([]byte, aes.BlockSize)
+	for i := 0; i < len(data); i += aes.BlockSize {
+		for j := range r {
+			r[j] ^= data[i+j]
+		}
+		f.block.Encrypt(r, r)
+	}
+	return r
+}
+
+// expand extends the PRF output r to d bytes with the encryptions of r xor 1, r xor 2, and so on.
+func (f *FF1) expand(r []byte, d int) []byte {
+	s := append([]byte(nil), r...)
+	for j := uint64(1); len(s) < d; j++ {
+		block := append([]byte(nil), r...)
+		counter := binary.BigEndian.AppendUint64(nil, j)
+		for k := range counter {
+			block[aes.BlockSize-8+k] ^= counter[k]
+		}
+		f.block.Encrypt(block, block)
+		s = append(s, block...)
+	}
+	return s[:d]
+}
+
+// bitLength returns the number of bits of radix^v - 1, that is ceil(v * log2(radix)).
+func bitLength(radix *big.Int, v int) int {
+	max := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+	return max.Sub(max, big.NewInt(1)).BitLen()
+}
+
+// num returns the number that numerals, most significant first, stand for.
+func num(numerals []uint16, radix *big.Int) *big.Int {
+	x := new(big.Int)
+	for _, numeral := range numerals {
+		x.Mul(x, radix).Add(x, big.NewInt(int64(numeral)))
+	}
+	return x
+}
+
+// str returns the m numerals of x, most significant first.
+func str(x, radix *big.Int, m int) []uint16 {
+	numerals := make([]uint16, m)
+	x, digit := new(big.Int).Set(x), new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		x.QuoRem(x, radix, digit)
+		numerals[i] = uint16(digit.Int64())
+	}
+	return numerals
+}
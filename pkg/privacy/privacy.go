@@ -0,0 +1,234 @@
+package privacy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Method is a way of protecting personal data in a field.
+type Method string
+
+const (
+	// Redact replaces the value with Redacted.
+	Redact Method = "redact"
+	// Mask keeps enough of the value to recognize it, e.g. "j***@example.com" or "***0100".
+	Mask Method = "mask"
+	// HMAC replaces the value with its keyed HMAC-SHA256, hex-encoded.
+	HMAC Method = "hmac"
+	// Tokenize encrypts the letters and digits of the value with FF1 into others of the same class,
+	// keeping its format: a UUID stays a UUID and an email address stays an email address. Distinct
+	// values always get distinct tokens, which Detokenize reverses with the same key.
+	Tokenize Method = "tokenize"
+)
+
+// Methods lists every protection method, in the order they are documented.
+var Methods = []Method{Redact, Mask, HMAC, Tokenize}
+
+// Redacted replaces redacted values.
+const Redacted = "[REDACTED]"
+
+// DefaultKeyEnv is the environment variable holding the key when the rules do not say where it is.
+const DefaultKeyEnv = "TRANSFORMER_PRIVACY_KEY"
+
+// Known reports whether name is a supported protection method.
+func Known(name string) bool {
+	for _, m := range Methods {
+		if string(m) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Keyed reports whether the method requires a key.
+func (m Method) Keyed() bool {
+	return m == HMAC || m == Tokenize
+}
+
+// KeySpec describes where the key of keyed methods is read from: an environment variable or a file.
+type KeySpec struct {
+	Env  string `json:"key_env,omitempty"`
+	File string `json:"key_file,omitempty"`
+}
+
+// Load reads the key. Without a file or variable, it is read from DefaultKeyEnv. Surrounding
+// whitespace, such as the trailing newline of a key file, is not part of the key.
+func (s KeySpec) Load() ([]byte, error) {
+	var key []byte
+	switch {
+	case s.File != "":
+		data, err := os.ReadFile(s.File)
+		if err != nil {
+			return nil, fmt.Errorf("reading privacy key file: %w", err)
+		}
+		key = bytes.TrimSpace(data)
+	default:
+		name := s.Env
+		if name == "" {
+			name = DefaultKeyEnv
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("privacy key environment variable %s is not set", name)
+		}
+		key = []byte(strings.TrimSpace(value))
+	}
+
+	if len(key) == 0 {
+		return nil, errors.New("privacy key is empty")
+	}
+	return key, nil
+}
+
+// Protector applies protection methods with a key. Keyed methods are deterministic, so the same value
+// is always replaced by the same pseudonym and records can still be joined on protected fields.
+// A Protector holds no mutable state and is safe for concurrent use.
+type Protector struct {
+	key []byte
+	ff1 *FF1 // Tokenizes with a key derived from key, nil without a key.
+}
+
+// NewProtector returns a Protector using the given key for keyed methods.
+func NewProtector(key []byte) *Protector {
+	p := &Protector{key: key}
+	if len(key) > 0 {
+		// An HMAC-SHA256 digest is a valid AES-256 key.
+		p.ff1, _ = NewFF1(p.mac("tokenize", ""))
+	}
+	return p
+}
+
+// Protect applies a method to a value. Null values are kept; other values are protected in their
+// string form. Keyed methods fail without a key.
+func (p *Protector) Protect(m Method, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		s = fmt.Sprint(value)
+	}
+
+	if m.Keyed() && (p == nil || len(p.key) == 0) {
+		return nil, fmt.Errorf("%s requires a privacy key", m)
+	}
+
+	switch m {
+	case Redact:
+		return Redacted, nil
+	case Mask:
+		return MaskValue(s), nil
+	case HMAC:
+		return hex.EncodeToString(p.mac("hmac", s)), nil
+	case Tokenize:
+		return p.tokenize(s, true)
+	}
+	return nil, fmt.Errorf("unknown protection method %q", m)
+}
+
+// Detokenize returns the value a token was made from by Tokenize with the same key.
+func (p *Protector) Detokenize(token string) (string, error) {
+	if p == nil || p.ff1 == nil {
+		return "", fmt.Errorf("%s requires a privacy key", Tokenize)
+	}
+	return p.tokenize(token, false)
+}
+
+// MaskValue partially masks a value. Email addresses keep the first character of the local part and
+// the domain, phone numbers keep their last four digits, and other values keep their first character.
+func MaskValue(s string) string {
+	if s == "" {
+		return s
+	}
+	if at := strings.LastIndex(s, "@"); at > 0 {
+		first, _ := utf8.DecodeRuneInString(s)
+		return string(first) + "***" + s[at:]
+	}
+	if digits := phoneDigits(s); len(digits) > 4 {
+		return "***" + digits[len(digits)-4:]
+	}
+	first, _ := utf8.DecodeRuneInString(s)
+	return string(first) + "***"
+}
+
+// phoneDigits returns the digits of a phone number, or "" when s holds anything but digits and separators.
+func phoneDigits(s string) string {
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case strings.ContainsRune("+-(). ", r):
+		default:
+			return ""
+		}
+	}
+	return digits.String()
+}
+
+// classes are the character ranges tokenization substitutes within. Hexadecimal letters stay
+// hexadecimal, so identifiers keep their format.
+var classes = [][2]rune{{'0', '9'}, {'a', 'f'}, {'g', 'z'}, {'A', 'F'}, {'G', 'Z'}}
+
+// tokenize encrypts, or decrypts, the characters of each class of s with FF1 in the radix of the class,
+// and keeps every other character. The tweak is the format of s, the class of every character, so
+// values of different formats are encrypted independently and each format is a permutation of itself.
+func (p *Protector) tokenize(s string, encrypt bool) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", fmt.Errorf("%s requires valid UTF-8", Tokenize)
+	}
+	runes := []rune(s)
+	format := make([]byte, len(runes))
+	positions := make([][]int, len(classes))
+	for i, r := range runes {
+		format[i] = 0xff
+		for c, class := range classes {
+			if r >= class[0] && r <= class[1] {
+				format[i] = byte(c)
+				positions[c] = append(positions[c], i)
+				break
+			}
+		}
+	}
+	for c, class := range classes {
+		if len(positions[c]) == 0 {
+			continue
+		}
+		numerals := make([]uint16, len(positions[c]))
+		for i, position := range positions[c] {
+			numerals[i] = uint16(runes[position] - class[0])
+		}
+
+		tweak := append([]byte{byte(c)}, format...)
+		radix := int(class[1]-class[0]) + 1
+		var err error
+		if encrypt {
+			numerals, err = p.ff1.Encrypt(tweak, numerals, radix)
+		} else {
+			numerals, err = p.ff1.Decrypt(tweak, numerals, radix)
+		}
+		if err != nil {
+			return "", err
+		}
+		for i, position := range positions[c] {
+			runes[position] = class[0] + rune(numerals[i])
+		}
+	}
+	return string(runes), nil
+}
+
+// mac returns the HMAC-SHA256 of a value, with a purpose separating the digests of different methods.
+func (p *Protector) mac(purpose, value string) []byte {
+	h := hmac.New(sha256.New, p.key)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
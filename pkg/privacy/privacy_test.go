@@ -0,0 +1,187 @@
+package privacy_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/privacy"
+)
+
+func TestMaskValue(t *testing.T) {
+	cases := map[string]string{
+		"john.doe@example.com": "j***@example.com",
+		"+1 (555) 010-0199":    "***0199",
+		"Johnson":              "J***",
+		"Ólafur":               "Ó***",
+		"":                     "",
+	}
+	for value, expected := range cases {
+		if got := privacy.MaskValue(value); got != expected {
+			t.Errorf("MaskValue(%q): expected %q, got %q", value, expected, got)
+		}
+	}
+}
+
+func TestProtector_Protect(t *testing.T) {
+	protector := privacy.NewProtector([]byte("secret"))
+	other := privacy.NewProtector([]byte("other secret"))
+
+	protect := func(p *privacy.Protector, m privacy.Method, value interface{}) interface{} {
+		t.Helper()
+		result, err := p.Protect(m, value)
+		if err != nil {
+			t.Fatalf("Protect(%s, %v) failed: %v", m, value, err)
+		}
+		return result
+	}
+
+	if got := protect(protector, privacy.Redact, "Ann"); got != privacy.Redacted {
+		t.Errorf("Expected a redacted value, got %v", got)
+	}
+	if got := protect(protector, privacy.HMAC, nil); got != nil {
+		t.Errorf("Expected null values to be kept, got %v", got)
+	}
+
+	digest := protect(protector, privacy.HMAC, "ann@example.com")
+	if !regexp.MustCompile(`^[0-9a-f]{64}$`).MatchString(digest.(string)) {
+		t.Errorf("Expected a hex-encoded HMAC-SHA256, got %v", digest)
+	}
+	if protect(protector, privacy.HMAC, "ann@example.com") != digest {
+		t.Errorf("Expected HMAC pseudonyms to be deterministic")
+	}
+	if protect(other, privacy.HMAC, "ann@example.com") == digest {
+		t.Errorf("Expected HMAC pseudonyms to depend on the key")
+	}
+
+	id := "0e685562-4a32-4728-a7ec-4d288ed7d3d4"
+	token := protect(protector, privacy.Tokenize, id).(string)
+	if token == id || !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`).MatchString(token) {
+		t.Errorf("Expected a different UUID-shaped token, got %q", token)
+	}
+	if protect(protector, privacy.Tokenize, id) != token {
+		t.Errorf("Expected tokens to be deterministic")
+	}
+	if mail := protect(protector, privacy.Tokenize, "Ann.Lee@example.com").(string); !regexp.MustCompile(`^[A-Z][a-z]{2}\.[A-Z][a-z]{2}@[a-z]{7}\.[a-z]{3}$`).MatchString(mail) {
+		t.Errorf("Expected the token to keep the email format, got %q", mail)
+	}
+
+	if _, err := privacy.NewProtector(nil).Protect(privacy.Tokenize, "value"); err == nil {
+		t.Errorf("Expected keyed methods to fail without a key")
+	}
+}
+
+func TestProtector_Detokenize(t *testing.T) {
+	protector := privacy.NewProtector([]byte("secret"))
+
+	for _, value := range []string{"0e685562-4a32-4728-a7ec-4d288ed7d3d4", "Ann.Lee@example.com", "+1 555 0100", "7", "Zoë", ""} {
+		token, err := protector.Protect(privacy.Tokenize, value)
+		if err != nil {
+			t.Fatalf("Failed to tokenize %q: %v", value, err)
+		}
+		if got, err := protector.Detokenize(token.(string)); err != nil || got != value {
+			t.Errorf("Expected %q to be detokenized back, got %q (%v)", value, got, err)
+		}
+	}
+
+	// Every value of a format gets its own token.
+	tokens := make(map[string]string)
+	for i := 0; i < 10000; i++ {
+		value := fmt.Sprintf("%04d", i)
+		token, err := protector.Protect(privacy.Tokenize, value)
+		if err != nil {
+			t.Fatalf("Failed to tokenize %q: %v", value, err)
+		}
+		if other, exists := tokens[token.(string)]; exists {
+			t.Fatalf("Expected distinct tokens, got %v for %q and %q", token, other, value)
+		}
+		tokens[token.(string)] = value
+	}
+
+	if _, err := privacy.NewProtector(nil).Detokenize("1234"); err == nil {
+		t.Errorf("Expected detokenizing to fail without a key")
+	}
+}
+
+func TestFF1(t *testing.T) {
+	// Samples 1 to 3 of the NIST FF1 examples.
+	key, _ := hex.DecodeString("2B7E151628AED2A6ABF7158809CF4F3C")
+	cases := []struct {
+		tweak, plaintext, ciphertext string
+		radix                        int
+	}{
+		{"", "0123456789", "2433477484", 10},
+		{"39383736353433323130", "0123456789", "6124200773", 10},
+		{"3737373770717273373737", "0123456789abcdefghi", "a9tv40mll9kdu509eum", 36},
+	}
+
+	ff1, err := privacy.NewFF1(key)
+	if err != nil {
+		t.Fatalf("NewFF1 failed: %v", err)
+	}
+	for _, c := range cases {
+		tweak, _ := hex.DecodeString(c.tweak)
+		ciphertext, err := ff1.Encrypt(tweak, numerals(c.plaintext), c.radix)
+		if err != nil || letters(ciphertext) != c.ciphertext {
+			t.Errorf("Expected %s to be encrypted into %s, got %s (%v)", c.plaintext, c.ciphertext, letters(ciphertext), err)
+		}
+		plaintext, err := ff1.Decrypt(tweak, numerals(c.ciphertext), c.radix)
+		if err != nil || letters(plaintext) != c.plaintext {
+			t.Errorf("Expected %s to be decrypted into %s, got %s (%v)", c.ciphertext, c.plaintext, letters(plaintext), err)
+		}
+	}
+
+	if _, err := ff1.Encrypt(nil, []uint16{10}, 10); err == nil {
+		t.Errorf("Expected numerals out of the radix to be rejected")
+	}
+}
+
+// numerals converts base-36 digits into numerals.
+func numerals(s string) []uint16 {
+	result := make([]uint16, len(s))
+	for i, r := range s {
+		n, _ := strconv.ParseUint(string(r), 36, 16)
+		result[i] = uint16(n)
+	}
+	return result
+}
+
+// letters converts numerals into base-36 digits.
+func letters(numerals []uint16) string {
+	var sb strings.Builder
+	for _, n := range numerals {
+		sb.WriteString(strconv.FormatUint(uint64(n), 36))
+	}
+	return sb.String()
+}
+
+func TestKeySpec_Load(t *testing.T) {
+	t.Setenv(privacy.DefaultKeyEnv, "from-default")
+	t.Setenv("TENANT_KEY", " from-env ")
+
+	keyFile := filepath.Join(t.TempDir(), "privacy.key")
+	if err := os.WriteFile(keyFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	cases := map[string]privacy.KeySpec{
+		"from-default": {},
+		"from-env":     {Env: "TENANT_KEY"},
+		"from-file":    {File: keyFile},
+	}
+	for expected, spec := range cases {
+		key, err := spec.Load()
+		if err != nil || string(key) != expected {
+			t.Errorf("Load(%+v): expected %q, got %q (%v)", spec, expected, key, err)
+		}
+	}
+
+	if _, err := (privacy.KeySpec{Env: "MISSING_PRIVACY_KEY"}).Load(); err == nil {
+		t.Errorf("Expected an error for a missing key variable")
+	}
+}
@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"pathid_assignment/pkg/preview"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/transformer"
+)
+
+// Preview transforms a sample of the records of every input file without storing anything, so rules can be
+// tried on real inputs. Records are transformed and validated like in ProcessRules, but not merged.
+func (p *Processor) Preview(inputPaths []string, ruleSet *rules.Rules, options preview.Options) (*preview.Preview, error) {
+	transform := func(obj map[string]interface{}) (map[string]interface{}, error) {
+		return p.Transformer.Transform(obj, ruleSet.Document)
+	}
+	if compiler, ok := p.Transformer.(transformer.Compiler); ok {
+		plan, err := compiler.Compile(ruleSet)
+		if err != nil {
+			return nil, &InputError{Err: fmt.Errorf("compiling rules: %w", err)}
+		}
+		transform = plan.Transform
+	}
+
+	files, err := p.Discovery.Find(inputPaths)
+	if err != nil {
+		return nil, &InputError{Err: err}
+	}
+
+	logger, _ := p.runLogger()
+	result := &preview.Preview{Files: len(files)}
+	if options.Random {
+		if options.Seed == 0 {
+			options.Seed = time.Now().UnixNano()
+		}
+		result.Seed = options.Seed
+	}
+	random := rand.New(rand.NewSource(options.Seed))
+
+	for _, file := range files {
+		fileLogger := logger.With("file", file)
+		data, err := p.readInput(file)
+		if err != nil {
+			fileLogger.Error("reading file failed", "error", err)
+			result.FailedFiles++
+			continue
+		}
+		objs, err := p.unmarshaller(fileLogger).UnmarshalByProperty(data, ruleSet.Document, "value")
+		if err != nil {
+			fileLogger.Error("unmarshalling file failed", "error", err)
+			result.FailedFiles++
+			continue
+		}
+		result.Records += len(objs)
+
+		for _, index := range options.Sample(len(objs), random) {
+			obj := objs[index]
+			if _, removed := obj[RemovedField]; removed {
+				continue
+			}
+
+			// The source is copied first, so it is shown as read whatever the transformation does with it.
+			record := preview.Record{File: file, Index: index + 1, Source: copyRecord(obj)}
+			target, err := transform(obj)
+			switch {
+			case errors.Is(err, transformer.ErrFiltered):
+				record.Filtered = true
+			case err != nil:
+				record.Error = err.Error()
+			default:
+				record.Target = target
+				if p.Schema != nil {
+					record.Violations = p.Schema.Validate(target)
+				}
+			}
+			result.Add(record)
+		}
+	}
+
+	result.Cover(ruleSet.Targets())
+	return result, nil
+}
+
+// copyRecord returns a deep copy of a decoded JSON record.
+func copyRecord(record map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(record))
+	for key, value := range record {
+		copied[key] = copyValue(value)
+	}
+	return copied
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return copyRecord(v)
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, item := range v {
+			copied[i] = copyValue(item)
+		}
+		return copied
+	}
+	return value
+}
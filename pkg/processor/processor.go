@@ -1,17 +1,30 @@
 package processor
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
-	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
 
+	"pathid_assignment/pkg/analytics"
+	"pathid_assignment/pkg/discovery"
+	"pathid_assignment/pkg/merge"
 	"pathid_assignment/pkg/models"
+	"pathid_assignment/pkg/progress"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/schema"
+	"pathid_assignment/pkg/state"
 	"pathid_assignment/pkg/storage"
 	"pathid_assignment/pkg/transformer"
 	"pathid_assignment/pkg/unmarshaller"
+	"pathid_assignment/pkg/utils"
 )
 
 // Processor struct manages the transformation and storage process.
@@ -19,135 +32,516 @@ type Processor struct {
 	Transformer  transformer.GenericTransformer
 	Storage      *storage.Storage
 	Unmarshaller unmarshaller.Unmarshaller
+
+	// Schema, when set, validates every transformed record before it reaches storage.
+	// Records violating it are written to rejects.json with their violations instead.
+	Schema *schema.Schema
+	// Strict fails the run when any record is rejected: nothing but the rejects is stored.
+	Strict bool
+	// Merge selects how records sharing the same id are merged; the zero value keeps every record.
+	Merge merge.Strategy
+	// StatePath, when set, makes runs incremental: only users added, changed or deleted since the run
+	// that last saved this state file are stored, each with an "op" field.
+	StatePath string
+	// Analytics, when set, derives the sign-in analytics of every user into sign_in_analytics.json, and
+	// reports enabled but stale accounts, with their analytics, in stale_accounts.json. Users are stored
+	// without analytics.
+	Analytics *analytics.Analyzer
+	// Discovery selects the input files found in input directories.
+	Discovery discovery.Options
+	// Stdin is read for the input path "-", os.Stdin when nil.
+	Stdin io.Reader
+	// Workers bounds how many files and records are processed concurrently, the number of CPUs when zero.
+	Workers int
+	// Logger receives the messages of runs, each carrying the run ID, and the file and record index when
+	// they concern one; nothing is logged when nil.
+	Logger *slog.Logger
+	// RunID correlates the messages and the summary of a run, generated for every run when empty.
+	RunID string
+	// Progress, when set, is called as files and records are processed, one call at a time, so applications
+	// can report the progress of runs. It should return quickly as it holds up the workers.
+	Progress func(progress.Event)
+}
+
+const (
+	// ModifiedField is the source record field holding its last modification time, used by merge.Newest.
+	ModifiedField = "lastModifiedDateTime"
+	// RemovedField marks source records deleted since the previous Graph delta query.
+	RemovedField = "@removed"
+	// OpField is the field of stored users telling how they changed in an incremental run.
+	OpField = "op"
+)
+
+// ErrRejected is returned in strict mode when records violate the output schema.
+var ErrRejected = errors.New("records violate the output schema")
+
+// InputError reports a problem with what a run was given, its input paths or its rules, found before
+// any record is processed.
+type InputError struct {
+	Err error
+}
+
+func (e *InputError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InputError) Unwrap() error {
+	return e.Err
+}
+
+// deltaEnvelope holds the paging annotations of a Graph delta query response.
+type deltaEnvelope struct {
+	DeltaLink string `json:"@odata.deltaLink"`
+}
+
+// orderedEntry is a transformed record with its position in the input.
+type orderedEntry struct {
+	file   int
+	record int
+	entry  merge.Entry
+}
+
+// Summary holds the record counts of a single Process run.
+type Summary struct {
+	RunID       string `json:"run_id"`
+	Files       int    `json:"files"`
+	Records     int    `json:"records"`
+	Transformed int    `json:"transformed"`
+	Filtered    int    `json:"filtered"`
+	Failed      int    `json:"failed"`
+	Rejected    int    `json:"rejected"`
+	FailedFiles int    `json:"failed_files"` // Input files that could not be read or parsed.
+	Duplicates  int    `json:"duplicates"`
+	Conflicts   int    `json:"conflicts"`
+	Removed     int    `json:"removed"` // Records marked "@removed" in delta inputs.
+	Stale       int    `json:"stale"`   // Enabled but stale accounts, when analytics are derived.
+	// Accounts, enabled or not, that never signed in, when analytics are derived.
+	NeverSignedIn int `json:"never_signed_in"`
+
+	// Incremental run counts, only set when the processor has a StatePath.
+	Added     int `json:"added,omitempty"`
+	Changed   int `json:"changed,omitempty"`
+	Deleted   int `json:"deleted,omitempty"`
+	Unchanged int `json:"unchanged,omitempty"`
+	// StateSaved tells whether the state file was updated, only when every file and record was processed.
+	StateSaved bool `json:"state_saved,omitempty"`
+
+	// Statistics of the processing of the inputs, overall and per input file.
+	Stats     progress.Stats       `json:"stats"`
+	FileStats []progress.FileStats `json:"file_stats"`
+}
+
+// NewRunID returns a random ID correlating the messages of a run.
+func NewRunID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// runLogger returns the logger of a run, carrying its ID.
+func (p *Processor) runLogger() (*slog.Logger, string) {
+	runID := p.RunID
+	if runID == "" {
+		runID = NewRunID()
+	}
+	return utils.LoggerOrDiscard(p.Logger).With("run_id", runID), runID
 }
 
 // NewProcessor initializes a new Processor with given Transformer and Unmarshaller.
+// Records sharing the same id are merged with merge.First unless Merge is changed.
 func NewProcessor(transformer transformer.GenericTransformer, unmarshaller unmarshaller.Unmarshaller, storage *storage.Storage) *Processor {
 	return &Processor{
 		Transformer:  transformer,
 		Storage:      storage,
 		Unmarshaller: unmarshaller,
+		Merge:        merge.First,
 	}
 }
 
 // Process reads input files, transforms their contents, and stores the results - Runs the main workflow.
-func (p *Processor) Process(inputPaths []string, rulesPath string, outputPath string) {
+// It returns a summary of how many records were transformed, filtered out by the rules or failed.
+// Records and files that fail are counted in the summary; the error reports a run that could not
+// complete, an *InputError when it could not start.
+func (p *Processor) Process(inputPaths []string, rulesPath string, outputPath string) (Summary, error) {
+	// Loading and validating the rules file before any input is read.
+	ruleSet, err := rules.Load(rulesPath)
+	if err != nil {
+		return Summary{}, &InputError{Err: fmt.Errorf("loading rules: %w", err)}
+	}
+
+	return p.ProcessRules(inputPaths, ruleSet, outputPath)
+}
+
+// ProcessRules runs the main workflow like Process, using rules that are already loaded,
+// such as the built-in defaults.
+func (p *Processor) ProcessRules(inputPaths []string, ruleSet *rules.Rules, outputPath string) (Summary, error) {
 	var wg sync.WaitGroup
 	var users models.UserModel
-	workerCount := runtime.NumCPU()
+	var summary Summary
+	var summaryMutex sync.Mutex
+	logger, runID := p.runLogger()
+	summary.RunID = runID
+	workerCount := p.Workers
+	if workerCount <= 0 {
+		workerCount = runtime.NumCPU()
+	}
 
 	// Semaphore controls the max number of concurrent goroutines.
 	semaphore := make(chan struct{}, workerCount)
+	rulesMap := ruleSet.Document
 
-	// Loading the rules file into the memory.
-	rulesData, err := os.ReadFile(rulesPath)
-	if err != nil {
-		log.Fatalf("Error reading rules file: %v", err)
+	// Compiling the rules once per run when the transformer supports it, instead of once per record.
+	transform := func(obj map[string]interface{}) (map[string]interface{}, error) {
+		return p.Transformer.Transform(obj, rulesMap)
+	}
+	if compiler, ok := p.Transformer.(transformer.Compiler); ok {
+		plan, err := compiler.Compile(ruleSet)
+		if err != nil {
+			return summary, &InputError{Err: fmt.Errorf("compiling rules: %w", err)}
+		}
+		transform = plan.Transform
+	}
+
+	// The analytics must be able to read their fields from the transformed users.
+	if p.Analytics != nil {
+		if err := p.Analytics.Check(ruleSet, p.Storage.Encryption != nil); err != nil {
+			return summary, &InputError{Err: fmt.Errorf("sign-in analytics: %w", err)}
+		}
 	}
 
-	// Parsing the rules file.
-	rules := make(map[string]interface{})
-	err = json.Unmarshal(rulesData, &rules)
+	// Process files, directories, patterns and standard input
+	allFiles, err := p.Discovery.Find(inputPaths)
 	if err != nil {
-		log.Fatalf("Error unmarshalling rules: %v", err)
+		return summary, &InputError{Err: err}
 	}
+	summary.Files = len(allFiles)
+	tracker := progress.NewTracker(allFiles, p.Progress)
 
-	// Process both files and directories
-	allFiles := []string{}
-	for _, path := range inputPaths {
-		info, err := os.Stat(path)
-		if err != nil {
-			log.Printf("Error accessing path %s: %v", path, err)
-			continue
+	// Loading the state of the previous incremental run before any input is read.
+	var runState *state.State
+	if p.StatePath != "" {
+		if runState, err = state.Load(p.StatePath); err != nil {
+			return summary, &InputError{Err: err}
 		}
+	}
 
-		if info.IsDir() {
-			files, err := filepath.Glob(filepath.Join(path, "*.json")) // Assuming JSON input files
-			if err != nil {
-				log.Printf("Error reading directory %s: %v", path, err)
-				continue
+	// Delta inputs carry a delta link per file and list deleted users as "@removed" records.
+	deltaLinks := make([]string, len(allFiles))
+	var removedIDs []string
+	// Records that failed or were rejected are skipped by incremental runs rather than deleted.
+	var skippedIDs []string
+	skip := func(records ...map[string]interface{}) {
+		for _, record := range records {
+			if id, exists := record["id"]; exists && id != nil {
+				skippedIDs = append(skippedIDs, fmt.Sprint(id))
 			}
-			allFiles = append(allFiles, files...)
-		} else {
-			allFiles = append(allFiles, path)
 		}
 	}
 
+	// Transformed records are collected with their position, so duplicates are merged in the order they were read.
+	var entries []orderedEntry
+	var entriesMutex sync.Mutex
+
 	// Process each input file concurrently, while respecting the semaphore limits.
-	for _, inputFilepath := range allFiles {
+	for fileIndex, inputFilepath := range allFiles {
 		wg.Add(1)
 
-		go func(filePath string, rulesMap map[string]interface{}) {
+		go func(fileIndex int, filePath string, rulesMap map[string]interface{}) {
 			defer wg.Done()
 
 			semaphore <- struct{}{}        // Adding empty struct to semphore as registering new goroutine.
 			defer func() { <-semaphore }() // Release semaphore slot as releasing goroutine.
 
-			log.Printf("Processing file: %s", filePath)
-			fileData, err := os.ReadFile(filePath)
+			fileLogger := logger.With("file", filePath)
+			fileLogger.Debug("processing file")
+			tracker.FileStarted(filePath)
+			fileData, err := p.readInput(filePath)
 			if err != nil {
-				log.Printf("Error reading file %s: %v", filePath, err)
+				fileLogger.Error("reading file failed", "error", err)
+				tracker.FileFailed(filePath, err)
+				summaryMutex.Lock()
+				summary.FailedFiles++
+				summaryMutex.Unlock()
 				return
 			}
 
+			var envelope deltaEnvelope
+			if json.Unmarshal(fileData, &envelope) == nil {
+				deltaLinks[fileIndex] = envelope.DeltaLink
+			}
+
 			// Unmarshaling input data using the configured Unmarshaller.
-			objs, err := p.Unmarshaller.UnmarshalByProperty(fileData, rules, "value")
+			objs, err := p.unmarshaller(fileLogger).UnmarshalByProperty(fileData, rulesMap, "value")
 			if err != nil {
-				log.Fatalf("Error unmarshalling rules: %v", err)
+				fileLogger.Error("unmarshalling file failed", "error", err)
+				tracker.FileFailed(filePath, err)
+				summaryMutex.Lock()
+				summary.FailedFiles++
+				summaryMutex.Unlock()
 				return
 			}
 
+			summaryMutex.Lock()
+			summary.Records += len(objs)
+			summaryMutex.Unlock()
+			fileLogger.Debug("read file", "records", len(objs))
+			tracker.FileRead(filePath, len(objs))
+
 			// Transform and store each object concurrently, while respecting the semaphore limits.
-			for _, obj := range objs {
+			for recordIndex, obj := range objs {
 				wg.Add(1)
 
-				go func(obj map[string]interface{}) {
+				go func(recordIndex int, obj map[string]interface{}) {
 					defer wg.Done()
 
 					semaphore <- struct{}{}        // Adding empty struct to semphore as registering new goroutine.
 					defer func() { <-semaphore }() // Release semaphore slot as releasing goroutine.
 
+					outcome, recordErr := progress.Transformed, error(nil)
+					defer func() { tracker.RecordDone(filePath, recordIndex+1, outcome, recordErr) }()
+
+					// Deletion markers of delta inputs carry no user to transform.
+					if _, removed := obj[RemovedField]; removed {
+						outcome = progress.Removed
+						summaryMutex.Lock()
+						defer summaryMutex.Unlock()
+						summary.Removed++
+						if id, exists := obj["id"]; exists && id != nil {
+							removedIDs = append(removedIDs, fmt.Sprint(id))
+						}
+						return
+					}
+
 					// Transforming the object using the Transformer.
-					data, err := p.Transformer.Transform(obj, rulesMap)
+					data, err := transform(obj)
 					if err != nil {
-						log.Println("Error Transforming: " + err.Error())
+						summaryMutex.Lock()
+						defer summaryMutex.Unlock()
+
+						// Records excluded by the rules' filters are expected and only counted. Incremental runs
+						// delete them, as users that are no longer part of the output.
+						if errors.Is(err, transformer.ErrFiltered) {
+							outcome = progress.Filtered
+							summary.Filtered++
+							if id, exists := obj["id"]; exists && id != nil {
+								removedIDs = append(removedIDs, fmt.Sprint(id))
+							}
+							fileLogger.Debug("record filtered out", "record", recordIndex+1)
+							return
+						}
+						skip(obj)
+						outcome, recordErr = progress.Failed, err
+						summary.Failed++
+						fileLogger.Warn("transforming record failed", "record", recordIndex+1, "error", err)
 						return
 					}
 
-					users.UserMutex.Lock()
+					// Records violating the output schema are diverted to the rejects with their violations.
+					if p.Schema != nil {
+						if violations := p.Schema.Validate(data); len(violations) > 0 {
+							outcome = progress.Rejected
+							users.UserMutex.Lock()
+							users.Rejects = append(users.Rejects, map[string]interface{}{
+								"record":     data,
+								"violations": violations,
+							})
+							users.UserMutex.Unlock()
 
-					if signInActivity, exists := data["sign_in_activity"]; exists {
-						users.Activities = append(users.Activities, map[string]interface{}{
-							"id":               data["id"], // Maintain reference to user ID
-							"sign_in_activity": signInActivity,
-						})
+							summaryMutex.Lock()
+							summary.Rejected++
+							skip(obj, data)
+							summaryMutex.Unlock()
+							fileLogger.Debug("record rejected by the schema", "record", recordIndex+1, "violations", len(violations))
+							return
+						}
 					}
 
-					// Remove sign_in_activity from user before appending to users list
-					delete(data, "sign_in_activity")
+					entry := merge.Entry{Record: data, Source: filePath}
+					if modified, ok := obj[ModifiedField].(string); ok {
+						entry.Modified, _ = utils.ParseTimestamp(modified)
+					}
 
-					users.Users = append(users.Users, data)
-					users.UserMutex.Unlock()
-				}(obj)
+					entriesMutex.Lock()
+					entries = append(entries, orderedEntry{file: fileIndex, record: recordIndex, entry: entry})
+					entriesMutex.Unlock()
+
+					summaryMutex.Lock()
+					summary.Transformed++
+					summaryMutex.Unlock()
+				}(recordIndex, obj)
 			}
-		}(inputFilepath, rules)
+		}(fileIndex, inputFilepath, rulesMap)
 
 	}
 
 	// Wait for all processing to finish before exiting.
 	wg.Wait()
+	summary.Stats, summary.FileStats = tracker.Finish()
+	logger.Info("read inputs", "files", summary.Files, "failed_files", summary.FailedFiles, "records", summary.Records,
+		"transformed", summary.Transformed, "filtered", summary.Filtered, "failed", summary.Failed, "rejected", summary.Rejected)
+
+	// Merging records sharing the same id, in the order they were read.
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		return a.file < b.file || (a.file == b.file && a.record < b.record)
+	})
+	ordered := make([]merge.Entry, len(entries))
+	for i, e := range entries {
+		ordered[i] = e.entry
+	}
+	strategy := p.Merge
+	if strategy == "" {
+		strategy = merge.None
+	}
+	merged := merge.Deduplicate(ordered, "id", strategy)
+	summary.Duplicates = merged.Duplicates
+	summary.Conflicts = len(merged.Conflicts)
+
+	// Incremental runs only store the users that changed since the previous run, marked with their change.
+	// Runs where files or records failed, or were rejected, miss users that are not gone: they delete none,
+	// and leave the state unchanged so the next run compares with the last complete one.
+	records := merged.Records
+	deltaLink := ""
+	complete := summary.FailedFiles == 0 && summary.Failed == 0 && summary.Rejected == 0
+	if runState != nil {
+		for _, link := range deltaLinks {
+			if link != "" {
+				deltaLink = link
+			}
+		}
+
+		// A delta only lists what changed, so users missing from it are unchanged rather than deleted.
+		deletable := removedIDs
+		if !complete {
+			deletable = nil
+			logger.Warn("incomplete incremental run, deleting no user and keeping the previous state",
+				"failed_files", summary.FailedFiles, "failed", summary.Failed, "rejected", summary.Rejected)
+		}
+		changes, unchanged := runState.Apply(merged.Records, deletable, deltaLink == "" && complete, skippedIDs)
+		summary.Unchanged = unchanged
+		records = make([]map[string]interface{}, 0, len(changes))
+		for _, change := range changes {
+			record := change.Record
+			switch change.Op {
+			case state.Added:
+				summary.Added++
+			case state.Changed:
+				summary.Changed++
+			case state.Deleted:
+				summary.Deleted++
+				record = map[string]interface{}{"id": change.ID}
+			}
+			record[OpField] = change.Op
+			records = append(records, record)
+		}
+	}
+
+	// Deriving sign-in analytics from every user, not only those that changed, before the sign-in
+	// activity is split from the users.
+	var staleAccounts []analytics.StaleAccount
+	var userAnalytics []analytics.UserAnalytics
+	if p.Analytics != nil {
+		staleAccounts = p.Analytics.Stale(merged.Records)
+		summary.Stale = len(staleAccounts)
+		userAnalytics = p.Analytics.Users(merged.Records)
+		for _, user := range userAnalytics {
+			if user.NeverSignedIn {
+				summary.NeverSignedIn++
+			}
+		}
+	}
+
+	for _, data := range records {
+		if signInActivity, exists := data["sign_in_activity"]; exists {
+			users.Activities = append(users.Activities, map[string]interface{}{
+				"id":               data["id"], // Maintain reference to user ID
+				"sign_in_activity": signInActivity,
+			})
+		}
+
+		// Remove sign_in_activity from user before appending to users list
+		delete(data, "sign_in_activity")
+
+		users.Users = append(users.Users, data)
+	}
+
+	// Stores the envelope of encrypted fields first, so no encrypted file is stored without it.
+	if err := p.Storage.SaveEnvelope(outputPath); err != nil {
+		return summary, fmt.Errorf("saving encryption envelope: %w", err)
+	}
+
+	if len(merged.Conflicts) > 0 {
+		if err := p.Storage.SaveConflicts(merged.Conflicts, outputPath); err != nil {
+			return summary, fmt.Errorf("saving merge conflicts: %w", err)
+		}
+	}
+
+	// Stores the rejected records first, so they are available even when strict mode fails the run.
+	if len(users.Rejects) > 0 {
+		if err := p.Storage.SaveRejects(users.Rejects, outputPath); err != nil {
+			return summary, fmt.Errorf("saving rejected records: %w", err)
+		}
+		if p.Strict {
+			logger.Error("strict mode failed the run", "rejected", summary.Rejected)
+			see := storage.GenerateFilePath(outputPath, "rejects")
+			if p.Storage.Stream != nil {
+				see = fmt.Sprintf("the %q lines of the output", storage.StreamKey("rejects"))
+			}
+			return summary, fmt.Errorf("strict mode: %d %w, see %s", summary.Rejected, ErrRejected, see)
+		}
+	}
 
 	// Stores all users in output path, in designated json file.
-	err = p.Storage.SaveUsers(users.Users, outputPath)
-	if err != nil {
-		log.Println("Error saving users in file: " + err.Error())
-		return
+	if err := p.Storage.SaveUsers(users.Users, outputPath); err != nil {
+		return summary, fmt.Errorf("saving users: %w", err)
 	}
 
 	// Stores all users sign-in activities in output path, in designated json file.
-	err = p.Storage.SaveSignInActivities(users.Activities, outputPath)
-	if err != nil {
-		log.Println("Error saving sign in activities in file: " + err.Error())
-		return
+	if err := p.Storage.SaveSignInActivities(users.Activities, outputPath); err != nil {
+		return summary, fmt.Errorf("saving sign-in activities: %w", err)
+	}
+
+	if p.Analytics != nil {
+		if err := p.Storage.SaveStaleAccounts(staleAccounts, p.Analytics.Fields, outputPath); err != nil {
+			return summary, fmt.Errorf("saving stale accounts: %w", err)
+		}
+		if err := p.Storage.SaveSignInAnalytics(userAnalytics, p.Analytics.Fields, outputPath); err != nil {
+			return summary, fmt.Errorf("saving sign-in analytics: %w", err)
+		}
+	}
+
+	// Saving the state only once the outputs are stored, so a failed run is repeated in full.
+	if runState != nil && complete {
+		if deltaLink != "" {
+			runState.DeltaLink = deltaLink
+		}
+		if err := runState.Save(p.StatePath); err != nil {
+			return summary, fmt.Errorf("saving state file: %w", err)
+		}
+		summary.StateSaved = true
+	}
+
+	logger.Info("run completed", "users", len(users.Users), "duplicates", summary.Duplicates, "conflicts", summary.Conflicts)
+	return summary, nil
+}
+
+// unmarshaller returns the unmarshaller of the processor, logging to the given logger when it logs.
+func (p *Processor) unmarshaller(logger *slog.Logger) unmarshaller.Unmarshaller {
+	if logging, ok := p.Unmarshaller.(unmarshaller.Logging); ok {
+		return logging.WithLogger(logger)
+	}
+	return p.Unmarshaller
+}
+
+// readInput reads an input file, or standard input for discovery.Stdin.
+func (p *Processor) readInput(path string) ([]byte, error) {
+	if path != discovery.Stdin {
+		return os.ReadFile(path)
+	}
+	if p.Stdin != nil {
+		return io.ReadAll(p.Stdin)
 	}
+	return io.ReadAll(os.Stdin)
 }
@@ -1,10 +1,24 @@
 package processor_test
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
+	"pathid_assignment/pkg/merge"
+	"pathid_assignment/pkg/preview"
+	"pathid_assignment/pkg/privacy"
 	"pathid_assignment/pkg/processor"
+	"pathid_assignment/pkg/progress"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/schema"
 	"pathid_assignment/pkg/storage"
 	"pathid_assignment/pkg/transformer"
 	"pathid_assignment/pkg/unmarshaller"
@@ -51,5 +65,435 @@ func TestProcessor(t *testing.T) {
 
 	// Initialize processor
 	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
-	proc.Process([]string{inputPath}, rulesPath, outputPath)
+	summary, err := proc.Process([]string{inputPath}, rulesPath, outputPath)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if summary.Failed != 0 || summary.FailedFiles != 0 || summary.Transformed == 0 {
+		t.Errorf("Expected every record to be transformed, got %+v", summary)
+	}
+
+	// Input paths that cannot be read fail the run before any record is processed.
+	var inputErr *processor.InputError
+	if _, err := proc.Process([]string{"missing.json"}, rulesPath, outputPath); !errors.As(err, &inputErr) {
+		t.Errorf("Expected an InputError for a missing input, got %v", err)
+	}
+}
+
+func TestProcessor_SchemaRejects(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "users.json")
+	input := `{"value": [
+		{"id": "0e685562-4a32-4728-a7ec-4d288ed7d3d4", "usageLocation": "US", "accountEnabled": true},
+		{"id": "123", "usageLocation": "ZZ", "accountEnabled": true}
+	]}`
+	if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	ruleSet, err := rules.Parse([]byte(`{"id": "id", "location": "usageLocation", "is_enabled": "accountEnabled"}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+	s, err := schema.Compile([]byte(`{
+		"type": "object",
+		"properties": {
+			"id": {"type": "string", "format": "uuid"},
+			"location": {"type": "string", "format": "country_code"}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to compile schema: %v", err)
+	}
+
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	proc.Schema = s
+
+	outputPath := t.TempDir()
+	summary, err := proc.ProcessRules([]string{inputPath}, ruleSet, outputPath)
+	if err != nil {
+		t.Fatalf("ProcessRules failed: %v", err)
+	}
+	if summary.Transformed != 1 || summary.Rejected != 1 {
+		t.Errorf("Expected 1 transformed and 1 rejected record, got %+v", summary)
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, "rejects.json")); err != nil {
+		t.Errorf("Expected rejects.json to be written: %v", err)
+	}
+
+	// In strict mode nothing but the rejects is stored.
+	proc.Strict = true
+	outputPath = t.TempDir()
+	if _, err := proc.ProcessRules([]string{inputPath}, ruleSet, outputPath); !errors.Is(err, processor.ErrRejected) {
+		t.Errorf("Expected ErrRejected in strict mode, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputPath, "users.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected users.json not to be written in strict mode")
+	}
+}
+
+func TestProcessor_Deduplicate(t *testing.T) {
+	inputPath := t.TempDir()
+	parts := map[string]string{
+		"part_1.json": `{"value": [{"id": "1", "givenName": "Ann", "signInActivity": {"lastSignInDateTime": "2024-01-01T00:00:00", "lastSignInRequestId": "r1"}}]}`,
+		"part_2.json": `{"value": [{"id": "1", "givenName": "Anne", "signInActivity": {"lastSignInDateTime": "2024-01-01T00:00:00", "lastSignInRequestId": "r1"}}, {"id": "2", "givenName": "Bob"}]}`,
+	}
+	for name, content := range parts {
+		if err := os.WriteFile(filepath.Join(inputPath, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write input file: %v", err)
+		}
+	}
+
+	ruleSet, err := rules.Parse([]byte(`{
+		"id": "id",
+		"first_name": "givenName",
+		"sign_in_activity": {
+			"lastSignInDateTime": "signInActivity.lastSignInDateTime",
+			"lastSignInRequestId": "signInActivity.lastSignInRequestId"
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	proc.Merge = merge.Last
+
+	outputPath := t.TempDir()
+	summary, err := proc.ProcessRules([]string{inputPath}, ruleSet, outputPath)
+	if err != nil {
+		t.Fatalf("ProcessRules failed: %v", err)
+	}
+	if summary.Duplicates != 1 || summary.Conflicts != 1 {
+		t.Errorf("Expected 1 duplicate with 1 conflict, got %+v", summary)
+	}
+
+	var users, signIns []map[string]interface{}
+	readJSON(t, filepath.Join(outputPath, "users.json"), &users)
+	readJSON(t, filepath.Join(outputPath, "signin.json"), &signIns)
+	if len(users) != 2 || users[0]["first_name"] != "Anne" {
+		t.Errorf("Expected the last record of user 1 to be kept, got %v", users)
+	}
+	if len(signIns) != 1 {
+		t.Errorf("Expected the sign-ins of user 1 once, got %v", signIns)
+	}
+}
+
+func TestProcessor_Pseudonymize(t *testing.T) {
+	t.Setenv(privacy.DefaultKeyEnv, "secret")
+	inputPath := filepath.Join(t.TempDir(), "users.json")
+	input := `{"value": [{"id": "1", "mail": "ann@example.com", "signInActivity": {"lastSignInDateTime": "2024-01-01T00:00:00", "lastSignInRequestId": "r1"}}]}`
+	if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	ruleSet, err := rules.Parse([]byte(`{
+		"id": {"$path": "id", "$protect": "hmac"},
+		"mail": {"$path": "mail", "$protect": "mask"},
+		"sign_in_activity": {
+			"lastSignInDateTime": "signInActivity.lastSignInDateTime",
+			"lastSignInRequestId": "signInActivity.lastSignInRequestId"
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	outputPath := t.TempDir()
+	if _, err := proc.ProcessRules([]string{inputPath}, ruleSet, outputPath); err != nil {
+		t.Fatalf("ProcessRules failed: %v", err)
+	}
+
+	var users, signIns []map[string]interface{}
+	readJSON(t, filepath.Join(outputPath, "users.json"), &users)
+	readJSON(t, filepath.Join(outputPath, "signin.json"), &signIns)
+	if len(users) != 1 || users[0]["id"] == "1" || users[0]["mail"] != "a***@example.com" {
+		t.Fatalf("Expected a pseudonymized user, got %v", users)
+	}
+	if len(signIns) != 1 || signIns[0]["userId"] != users[0]["id"] {
+		t.Errorf("Expected sign-ins to reference the pseudonymized id %v, got %v", users[0]["id"], signIns)
+	}
+}
+
+func readJSON(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("Failed to parse %s: %v", path, err)
+	}
+}
+
+func TestProcessor_Incremental(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "users.json")
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	ruleSet, err := rules.Parse([]byte(`{"id": "id", "first_name": "givenName"}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	proc.StatePath = statePath
+	run := func(input string) (processor.Summary, []map[string]interface{}) {
+		if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
+			t.Fatalf("Failed to write input file: %v", err)
+		}
+		outputPath := t.TempDir()
+		summary, err := proc.ProcessRules([]string{inputPath}, ruleSet, outputPath)
+		if err != nil {
+			t.Fatalf("ProcessRules failed: %v", err)
+		}
+
+		var users []map[string]interface{}
+		readJSON(t, filepath.Join(outputPath, "users.json"), &users)
+		return summary, users
+	}
+
+	summary, users := run(`{"value": [{"id": "1", "givenName": "Ann"}, {"id": "2", "givenName": "Bob"}, {"id": "3", "givenName": "Eve"}]}`)
+	if summary.Added != 3 || len(users) != 3 || users[0]["op"] != "added" {
+		t.Errorf("Expected 3 added users, got %+v: %v", summary, users)
+	}
+
+	summary, users = run(`{"value": [{"id": "1", "givenName": "Ann"}, {"id": "2", "givenName": "Robert"}]}`)
+	if summary.Changed != 1 || summary.Deleted != 1 || summary.Unchanged != 1 || len(users) != 2 {
+		t.Errorf("Expected 1 changed and 1 deleted user, got %+v: %v", summary, users)
+	}
+
+	// Delta inputs only delete the users marked as removed.
+	summary, users = run(`{
+		"@odata.deltaLink": "https://graph.microsoft.com/v1.0/users/delta?$deltatoken=next",
+		"value": [{"id": "2", "@removed": {"reason": "deleted"}}]
+	}`)
+	if summary.Removed != 1 || summary.Deleted != 1 || len(users) != 1 || users[0]["op"] != "deleted" {
+		t.Errorf("Expected user 2 deleted, got %+v: %v", summary, users)
+	}
+
+	data, err := os.ReadFile(statePath)
+	if err != nil || !strings.Contains(string(data), "deltatoken=next") {
+		t.Errorf("Expected the state file to keep the delta link, got %s (%v)", data, err)
+	}
+}
+
+func TestProcessor_IncrementalFailures(t *testing.T) {
+	inputPath := t.TempDir()
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	ruleSet, err := rules.Parse([]byte(`{"$filter": "accountEnabled == true", "id": "id", "mail": {"$path": "mail", "$type": "email"}}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	proc.StatePath = statePath
+	run := func(a, b string) processor.Summary {
+		for name, content := range map[string]string{"a.json": a, "b.json": b} {
+			if err := os.WriteFile(filepath.Join(inputPath, name), []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write input file: %v", err)
+			}
+		}
+		summary, err := proc.ProcessRules([]string{inputPath}, ruleSet, t.TempDir())
+		if err != nil {
+			t.Fatalf("ProcessRules failed: %v", err)
+		}
+		return summary
+	}
+
+	const a = `{"value": [{"id": "1", "mail": "a@example.com", "accountEnabled": true}, {"id": "2", "mail": "b@example.com", "accountEnabled": true}]}`
+	const b = `{"value": [{"id": "3", "mail": "c@example.com", "accountEnabled": true}]}`
+	if summary := run(a, b); summary.Added != 3 || !summary.StateSaved {
+		t.Fatalf("Expected 3 added users, got %+v", summary)
+	}
+	baseline, err := os.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state file: %v", err)
+	}
+
+	// A corrupt file and a failing record delete no user, and leave the state file unchanged.
+	summary := run(`{"value": [{"id": "1", "mail": "invalid", "accountEnabled": true}, {"id": "2", "mail": "b@example.com", "accountEnabled": true}]}`, `not JSON`)
+	if summary.FailedFiles != 1 || summary.Failed != 1 || summary.Deleted != 0 || summary.Unchanged != 1 || summary.StateSaved {
+		t.Errorf("Expected no deleted user and no saved state, got %+v", summary)
+	}
+	if data, err := os.ReadFile(statePath); err != nil || string(data) != string(baseline) {
+		t.Errorf("Expected the state file to be left unchanged, got %s (%v)", data, err)
+	}
+
+	// The next complete run compares with the last complete one; users filtered out are deleted.
+	summary = run(a, `{"value": [{"id": "3", "mail": "c@example.com", "accountEnabled": false}]}`)
+	if summary.Added != 0 || summary.Deleted != 1 || summary.Unchanged != 2 || !summary.StateSaved {
+		t.Errorf("Expected 2 unchanged users and the filtered one deleted, got %+v", summary)
+	}
+
+	// Deltas delete the users they list that are filtered out, and keep the others.
+	summary = run(`{
+		"@odata.deltaLink": "https://graph.microsoft.com/v1.0/users/delta?$deltatoken=next",
+		"value": [{"id": "1", "mail": "a@example.com", "accountEnabled": false}]
+	}`, `{"value": []}`)
+	if summary.Filtered != 1 || summary.Deleted != 1 || !summary.StateSaved {
+		t.Errorf("Expected the filtered user deleted by the delta, got %+v", summary)
+	}
+}
+
+func TestProcessor_Inputs(t *testing.T) {
+	inputPath := t.TempDir()
+	parts := map[string]string{
+		"a.json":        `{"value": [{"id": "1"}]}`,
+		"nested/b.json": `{"value": [{"id": "2"}]}`,
+		"nested/c.txt":  `{"value": [{"id": "3"}]}`,
+	}
+	for name, content := range parts {
+		path := filepath.Join(inputPath, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create input directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write input file: %v", err)
+		}
+	}
+
+	ruleSet, err := rules.Parse([]byte(`{"id": "id"}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	proc.Discovery.Recursive = true
+	proc.Stdin = strings.NewReader(`{"value": [{"id": "4"}]}`)
+
+	summary, err := proc.ProcessRules([]string{inputPath, "-"}, ruleSet, t.TempDir())
+	if err != nil {
+		t.Fatalf("ProcessRules failed: %v", err)
+	}
+	if summary.Files != 3 || summary.Transformed != 3 {
+		t.Errorf("Expected 3 records from 3 inputs, got %+v", summary)
+	}
+}
+
+func TestProcessor_Logger(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "users.json")
+	if err := os.WriteFile(inputPath, []byte(`{"value": [{"id": "1", "mail": "a@example.com"}, {"id": "2", "mail": "invalid"}]}`), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	ruleSet, err := rules.Parse([]byte(`{"id": "id", "mail": {"$path": "mail", "$type": "email"}}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	var logs bytes.Buffer
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	proc.Logger = slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	proc.RunID = "run-1"
+
+	summary, err := proc.ProcessRules([]string{inputPath}, ruleSet, t.TempDir())
+	if err != nil {
+		t.Fatalf("ProcessRules failed: %v", err)
+	}
+	if summary.RunID != "run-1" || summary.Failed != 1 {
+		t.Fatalf("Expected 1 failed record in run-1, got %+v", summary)
+	}
+
+	// Only the failed record is logged at the warning level, with the run, file and record it concerns.
+	var messages []map[string]interface{}
+	scanner := bufio.NewScanner(&logs)
+	for scanner.Scan() {
+		var message map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			t.Fatalf("Invalid JSON log line %q: %v", scanner.Text(), err)
+		}
+		messages = append(messages, message)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %v", messages)
+	}
+	message := messages[0]
+	if message["level"] != "WARN" || message["run_id"] != "run-1" || message["file"] != inputPath || message["record"] != 2.0 {
+		t.Errorf("Unexpected message %v", message)
+	}
+}
+
+func TestProcessor_Progress(t *testing.T) {
+	inputPath := t.TempDir()
+	for name, content := range map[string]string{
+		"a.json": `{"value": [{"id": "1", "mail": "a@example.com"}, {"id": "2", "mail": "invalid"}]}`,
+		"b.json": `{"value": [{"id": "3", "mail": "c@example.com"}]}`,
+		"c.json": `not JSON`,
+	} {
+		if err := os.WriteFile(filepath.Join(inputPath, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write input file: %v", err)
+		}
+	}
+	ruleSet, err := rules.Parse([]byte(`{"id": "id", "mail": {"$path": "mail", "$type": "email"}}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	// The callback is never called concurrently, so it needs no locking.
+	counts := make(map[progress.Kind]int)
+	var last progress.Event
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	proc.Progress = func(event progress.Event) {
+		counts[event.Kind]++
+		last = event
+	}
+
+	summary, err := proc.ProcessRules([]string{inputPath}, ruleSet, t.TempDir())
+	if err != nil {
+		t.Fatalf("ProcessRules failed: %v", err)
+	}
+
+	expected := map[progress.Kind]int{progress.Started: 1, progress.FileRead: 2, progress.FileFailed: 1, progress.RecordDone: 3, progress.FileDone: 3, progress.Finished: 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Expected events %v, got %v", expected, counts)
+	}
+	if last.Kind != progress.Finished || last.Stats.FilesDone != 3 || last.Stats.RecordsDone != 3 || last.Stats.Errors() != 2 {
+		t.Errorf("Unexpected final event %+v", last)
+	}
+
+	if summary.Stats.Records != 3 || summary.Stats.Failed != 1 || summary.Stats.FailedFiles != 1 {
+		t.Errorf("Unexpected summary stats %+v", summary.Stats)
+	}
+	if len(summary.FileStats) != 3 || summary.FileStats[0].Failed != 1 || summary.FileStats[2].Error == "" {
+		t.Errorf("Unexpected file stats %+v", summary.FileStats)
+	}
+}
+
+func TestProcessor_Preview(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "users.json")
+	input := `{"value": [
+		{"id": "1", "mail": "a@example.com", "accountEnabled": true},
+		{"id": "2", "accountEnabled": false},
+		{"id": "3", "mail": "c@example.com", "accountEnabled": true}
+	]}`
+	if err := os.WriteFile(inputPath, []byte(input), 0644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	ruleSet, err := rules.Parse([]byte(`{"$filter": "accountEnabled", "id": "id", "mail": "mail"}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	proc := processor.NewProcessor(transformer.NewKeywordTransformer(), unmarshaller.NewJSONUnmarshaller(), storage.NewStorage())
+	result, err := proc.Preview([]string{inputPath}, ruleSet, preview.Options{Size: 2})
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	if result.Records != 3 || result.Sampled != 2 || result.Transformed != 1 || result.Filtered != 1 {
+		t.Errorf("Expected the first 2 of 3 records sampled, 1 transformed and 1 filtered out, got %+v", result)
+	}
+	if len(result.Coverage) != 2 || result.Coverage[1].Field != "mail" || result.Coverage[1].Populated != 1 {
+		t.Errorf("Expected the mail populated in the transformed record, got %+v", result.Coverage)
+	}
+	if result.Samples[0].Source["mail"] != "a@example.com" || result.Samples[0].Target["mail"] != "a@example.com" {
+		t.Errorf("Expected the source next to its transformation, got %+v", result.Samples[0])
+	}
+
+	random, err := proc.Preview([]string{inputPath}, ruleSet, preview.Options{Size: 2, Random: true, Seed: 7})
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	again, _ := proc.Preview([]string{inputPath}, ruleSet, preview.Options{Size: 2, Random: true, Seed: 7})
+	if random.Samples[0].Index != again.Samples[0].Index || random.Samples[1].Index != again.Samples[1].Index {
+		t.Errorf("Expected random samples to repeat with the same seed")
+	}
 }
@@ -0,0 +1,288 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind tells what happened in a run.
+type Kind int
+
+const (
+	// Started is sent once the input files of a run are found.
+	Started Kind = iota
+	// FileRead is sent once the records of a file are read, before they are transformed.
+	FileRead
+	// FileFailed is sent when a file cannot be read or parsed.
+	FileFailed
+	// RecordDone is sent for every record once transformed, filtered out, failed or rejected.
+	RecordDone
+	// FileDone is sent once every record of a file is done.
+	FileDone
+	// Finished is sent once every input is processed, before the outputs are stored.
+	Finished
+)
+
+// Outcome tells how a record ended up.
+type Outcome int
+
+const (
+	Transformed Outcome = iota
+	Filtered
+	Failed
+	Rejected
+	Removed // Deletion markers of delta inputs.
+)
+
+// Event reports the progress of a run.
+type Event struct {
+	Kind    Kind
+	File    string  // File the event concerns, empty for the run.
+	Record  int     // Position of the record in its file from 1, for RecordDone.
+	Outcome Outcome // Outcome of the record, for RecordDone.
+	Err     error   // Error of a failed file or record.
+	Stats   Stats   // Progress of the run when the event happened.
+}
+
+// Stats are the counts of a run, so far or once finished.
+type Stats struct {
+	Files       int           `json:"files"`
+	FilesRead   int           `json:"files_read"` // Files whose records are known, failed ones included.
+	FilesDone   int           `json:"files_done"` // Files whose records are all done, failed ones included.
+	FailedFiles int           `json:"failed_files"`
+	Records     int           `json:"records"` // Records of the files read so far.
+	RecordsDone int           `json:"records_done"`
+	Failed      int           `json:"failed"`
+	Rejected    int           `json:"rejected"`
+	Elapsed     time.Duration `json:"elapsed"`
+}
+
+// Errors returns the number of failed files, failed records and rejected records.
+func (s Stats) Errors() int {
+	return s.FailedFiles + s.Failed + s.Rejected
+}
+
+// Rate returns the number of records done per second.
+func (s Stats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.RecordsDone) / s.Elapsed.Seconds()
+}
+
+// ETA estimates the time left to process every record at the current rate, the records of the files not
+// read yet being estimated from those already read. It reports false until that can be estimated.
+func (s Stats) ETA() (time.Duration, bool) {
+	rate := s.Rate()
+	if rate == 0 || s.FilesRead == 0 {
+		return 0, false
+	}
+	total := float64(s.Records) * float64(s.Files) / float64(s.FilesRead)
+	left := total - float64(s.RecordsDone)
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(left / rate * float64(time.Second)), true
+}
+
+// String formats the stats as a progress line.
+func (s Stats) String() string {
+	eta := "ETA unknown"
+	if left, ok := s.ETA(); ok {
+		eta = "ETA " + left.Round(time.Second).String()
+	}
+	return fmt.Sprintf("files %d/%d, records %d/%d, %.0f records/s, %d errors, %s",
+		s.FilesDone, s.Files, s.RecordsDone, s.Records, s.Rate(), s.Errors(), eta)
+}
+
+// FileStats are the counts of an input file once processed.
+type FileStats struct {
+	File     string        `json:"file"`
+	Records  int           `json:"records"`
+	Failed   int           `json:"failed"`
+	Rejected int           `json:"rejected"`
+	Error    string        `json:"error,omitempty"` // Why the file could not be read or parsed.
+	Duration time.Duration `json:"duration"`
+}
+
+// fileState tracks the records of a file still being processed.
+type fileState struct {
+	stats   FileStats
+	started time.Time
+	left    int
+}
+
+// Tracker counts the progress of a run and sends it to a callback. It is safe for concurrent use, and
+// the callback is never called concurrently.
+type Tracker struct {
+	mutex    sync.Mutex
+	callback func(Event)
+	started  time.Time
+	stats    Stats
+	files    map[string]*fileState
+	order    []string // Files in input order.
+}
+
+// NewTracker starts tracking a run of the given input files, sending its events to callback when not nil.
+func NewTracker(files []string, callback func(Event)) *Tracker {
+	t := &Tracker{callback: callback, started: time.Now(), files: make(map[string]*fileState, len(files))}
+	for _, file := range files {
+		t.file(file).started = t.started
+	}
+	t.stats.Files = len(files)
+	t.send(Event{Kind: Started})
+	return t
+}
+
+// FileStarted records that a file is being read, timing it from now.
+func (t *Tracker) FileStarted(file string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.file(file).started = time.Now()
+}
+
+// FileRead records the number of records read from a file.
+func (t *Tracker) FileRead(file string, records int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	state := t.file(file)
+	state.stats.Records, state.left = records, records
+	t.stats.FilesRead++
+	t.stats.Records += records
+	t.send(Event{Kind: FileRead, File: file})
+	if records == 0 {
+		t.fileDone(file, state)
+	}
+}
+
+// FileFailed records a file that could not be read or parsed.
+func (t *Tracker) FileFailed(file string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	state := t.file(file)
+	state.stats.Error = err.Error()
+	t.stats.FilesRead++
+	t.stats.FailedFiles++
+	t.send(Event{Kind: FileFailed, File: file, Err: err})
+	t.fileDone(file, state)
+}
+
+// RecordDone records the outcome of the record of a file at the given position, from 1.
+func (t *Tracker) RecordDone(file string, record int, outcome Outcome, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	state := t.file(file)
+	state.left--
+	t.stats.RecordsDone++
+	switch outcome {
+	case Failed:
+		state.stats.Failed++
+		t.stats.Failed++
+	case Rejected:
+		state.stats.Rejected++
+		t.stats.Rejected++
+	}
+	t.send(Event{Kind: RecordDone, File: file, Record: record, Outcome: outcome, Err: err})
+	if state.left == 0 {
+		t.fileDone(file, state)
+	}
+}
+
+// Finish ends the run, returning its stats and those of every file in input order.
+func (t *Tracker) Finish() (Stats, []FileStats) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.send(Event{Kind: Finished})
+
+	files := make([]FileStats, 0, len(t.order))
+	for _, file := range t.order {
+		files = append(files, t.files[file].stats)
+	}
+	return t.stats, files
+}
+
+func (t *Tracker) file(file string) *fileState {
+	state, ok := t.files[file]
+	if !ok {
+		state = &fileState{stats: FileStats{File: file}, started: time.Now()}
+		t.files[file] = state
+		t.order = append(t.order, file)
+	}
+	return state
+}
+
+func (t *Tracker) fileDone(file string, state *fileState) {
+	state.stats.Duration = time.Since(state.started)
+	t.stats.FilesDone++
+	t.send(Event{Kind: FileDone, File: file})
+}
+
+// send sends an event with the current stats, the mutex being held.
+func (t *Tracker) send(event Event) {
+	t.stats.Elapsed = time.Since(t.started)
+	if t.callback == nil {
+		return
+	}
+	event.Stats = t.stats
+	t.callback(event)
+}
+
+// Terminal renders the progress of a run on a single line of a terminal, rewritten as the run goes.
+type Terminal struct {
+	w        io.Writer
+	Interval time.Duration // Shortest time between two updates of the line.
+	last     time.Time
+	width    int
+}
+
+// NewTerminal returns a Terminal writing to w, updated at most ten times per second.
+func NewTerminal(w io.Writer) *Terminal {
+	return &Terminal{w: w, Interval: 100 * time.Millisecond}
+}
+
+// Update renders an event, and clears the line once the run is finished.
+func (t *Terminal) Update(event Event) {
+	if event.Kind == Finished {
+		fmt.Fprintf(t.w, "\r%s\r", strings.Repeat(" ", t.width))
+		t.width = 0
+		return
+	}
+	if event.Kind != Started && event.Kind != FileDone && time.Since(t.last) < t.Interval {
+		return
+	}
+	t.last = time.Now()
+
+	line := event.Stats.String()
+	padding := ""
+	if len(line) < t.width {
+		padding = strings.Repeat(" ", t.width-len(line))
+	}
+	fmt.Fprintf(t.w, "\r%s%s", line, padding)
+	t.width = len(line) + len(padding)
+}
+
+// WriteText writes the final stats of a run, followed by those of every file when there are several.
+func WriteText(w io.Writer, stats Stats, files []FileStats) error {
+	if _, err := fmt.Fprintf(w, "Took %s, %.0f records/s, %d errors\n",
+		stats.Elapsed.Round(time.Millisecond), stats.Rate(), stats.Errors()); err != nil {
+		return err
+	}
+	if len(files) < 2 {
+		return nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%7s  %6s  %8s  %8s  %s\n", "RECORDS", "FAILED", "REJECTED", "DURATION", "FILE")
+	for _, file := range files {
+		name := file.File
+		if file.Error != "" {
+			name += " (" + file.Error + ")"
+		}
+		fmt.Fprintf(&b, "%7d  %6d  %8d  %8s  %s\n", file.Records, file.Failed, file.Rejected, file.Duration.Round(time.Millisecond), name)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,110 @@
+package progress_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"pathid_assignment/pkg/progress"
+)
+
+func TestTracker(t *testing.T) {
+	var kinds []progress.Kind
+	var last progress.Event
+	tracker := progress.NewTracker([]string{"a.json", "b.json", "c.json"}, func(event progress.Event) {
+		kinds = append(kinds, event.Kind)
+		last = event
+	})
+
+	tracker.FileStarted("a.json")
+	tracker.FileRead("a.json", 2)
+	tracker.RecordDone("a.json", 1, progress.Transformed, nil)
+	tracker.RecordDone("a.json", 2, progress.Failed, errors.New("invalid mail"))
+	tracker.FileStarted("b.json")
+	tracker.FileFailed("b.json", errors.New("invalid JSON"))
+	tracker.FileStarted("c.json")
+	tracker.FileRead("c.json", 0)
+
+	stats, files := tracker.Finish()
+
+	expected := []progress.Kind{
+		progress.Started,
+		progress.FileRead, progress.RecordDone, progress.RecordDone, progress.FileDone,
+		progress.FileFailed, progress.FileDone,
+		progress.FileRead, progress.FileDone,
+		progress.Finished,
+	}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected events %v, got %v", expected, kinds)
+	}
+	if last.Stats.FilesDone != 3 || last.Stats.RecordsDone != 2 {
+		t.Errorf("Expected every file and record done when finished, got %+v", last.Stats)
+	}
+
+	if stats.Files != 3 || stats.FilesRead != 3 || stats.FailedFiles != 1 || stats.Records != 2 || stats.Failed != 1 || stats.Errors() != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if len(files) != 3 || files[0].File != "a.json" || files[0].Failed != 1 || files[1].Error != "invalid JSON" || files[2].Records != 0 {
+		t.Errorf("Unexpected file stats %+v", files)
+	}
+}
+
+func TestStats_ETA(t *testing.T) {
+	if _, ok := (progress.Stats{Files: 2}).ETA(); ok {
+		t.Errorf("Expected no ETA before any record is done")
+	}
+
+	// Half of the files are read, so as many records are expected from the other half.
+	stats := progress.Stats{Files: 2, FilesRead: 1, Records: 100, RecordsDone: 50, Elapsed: time.Second}
+	if rate := stats.Rate(); rate != 50 {
+		t.Errorf("Expected 50 records/s, got %v", rate)
+	}
+	if eta, ok := stats.ETA(); !ok || eta != 3*time.Second {
+		t.Errorf("Expected an ETA of 3s, got %v", eta)
+	}
+	if line := stats.String(); line != "files 0/2, records 50/100, 50 records/s, 0 errors, ETA 3s" {
+		t.Errorf("Unexpected progress line %q", line)
+	}
+}
+
+func TestTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	terminal := progress.NewTerminal(&buf)
+	terminal.Interval = 0
+
+	terminal.Update(progress.Event{Kind: progress.RecordDone, Stats: progress.Stats{Files: 10, Records: 1000}})
+	terminal.Update(progress.Event{Kind: progress.RecordDone, Stats: progress.Stats{Files: 1}})
+	terminal.Update(progress.Event{Kind: progress.Finished})
+
+	lines := strings.Split(buf.String(), "\r")
+	if len(lines) != 5 {
+		t.Fatalf("Expected the line to be rewritten 3 times, got %q", buf.String())
+	}
+	// The longer line is cleared by the shorter one, and the last by the end of the run.
+	if len(lines[2]) != len(lines[1]) || strings.TrimSpace(lines[3]) != "" || len(lines[3]) != len(lines[2]) {
+		t.Errorf("Expected lines to be cleared, got %q", buf.String())
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	stats := progress.Stats{Files: 2, FilesRead: 2, FilesDone: 2, FailedFiles: 1, Records: 3, RecordsDone: 3, Failed: 1, Elapsed: 1500 * time.Millisecond}
+	files := []progress.FileStats{
+		{File: "a.json", Records: 3, Failed: 1, Duration: time.Second},
+		{File: "b.json", Error: "invalid JSON", Duration: time.Millisecond},
+	}
+
+	var buf bytes.Buffer
+	if err := progress.WriteText(&buf, stats, files); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+	expected := "Took 1.5s, 2 records/s, 2 errors\n" +
+		"RECORDS  FAILED  REJECTED  DURATION  FILE\n" +
+		"      3       1         0        1s  a.json\n" +
+		"      0       0         0       1ms  b.json (invalid JSON)\n"
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
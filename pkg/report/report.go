@@ -0,0 +1,296 @@
+package report
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/storage"
+	"pathid_assignment/pkg/utils"
+)
+
+// Unknown is the group of users missing the field a count is grouped by.
+const Unknown = "(unknown)"
+
+// Fields name the target fields users are grouped by, as dot-separated paths.
+type Fields struct {
+	Type     string `json:"type"`
+	Location string `json:"location"`
+	Enabled  string `json:"enabled"`
+}
+
+// DefaultFields are the fields named by the default rules.
+var DefaultFields = Fields{Type: "type", Location: "location", Enabled: "is_enabled"}
+
+// withDefaults returns the fields, defaulting empty names to those of DefaultFields.
+func (f Fields) withDefaults() Fields {
+	if f.Type == "" {
+		f.Type = DefaultFields.Type
+	}
+	if f.Location == "" {
+		f.Location = DefaultFields.Location
+	}
+	if f.Enabled == "" {
+		f.Enabled = DefaultFields.Enabled
+	}
+	return f
+}
+
+// Check returns a warning for every field the rules do not produce, or produce protected or encrypted:
+// users would all be counted as Unknown, or grouped by tokens and ciphertexts, for that field.
+func (f Fields) Check(ruleSet *rules.Rules) []string {
+	f = f.withDefaults()
+	var warnings []string
+	for _, path := range []string{f.Type, f.Location, f.Enabled} {
+		rule := ruleSet.Field(path)
+		switch {
+		case rule == nil:
+			warnings = append(warnings, fmt.Sprintf("report field %q is not produced by the rules, every user is counted as %s", path, Unknown))
+		case rule.Sealed() != "":
+			warnings = append(warnings, fmt.Sprintf("report field %q is sealed with %s, users are not grouped by its plain values", path, rule.Sealed()))
+		}
+	}
+	return warnings
+}
+
+// Sign-in types, as stored in signin.json.
+const (
+	interactiveType    = "lastSignInDateTime"
+	nonInteractiveType = "lastNonInteractiveSignInDateTime"
+	successfulType     = "lastSuccessfulSignInDateTime"
+)
+
+// Count is the number of users sharing a value of a field.
+type Count struct {
+	Value string `json:"value"`
+	Users int    `json:"users"`
+}
+
+// Month counts the sign-ins of each type whose last occurrence falls in a calendar month.
+type Month struct {
+	Month          string `json:"month"` // Formatted as 2006-01; sign-ins without a valid timestamp fall under Unknown.
+	Interactive    int    `json:"interactive"`
+	NonInteractive int    `json:"non_interactive"`
+	Successful     int    `json:"successful"`
+}
+
+// Report aggregates the users and sign-ins stored by a run. Counts are ordered by descending number
+// of users, then by value; months are in chronological order.
+type Report struct {
+	Fields         Fields  `json:"fields"`
+	Users          int     `json:"users"`
+	Enabled        int     `json:"enabled"`
+	Disabled       int     `json:"disabled"`
+	Guests         int     `json:"guests"`
+	Members        int     `json:"members"`
+	ByType         []Count `json:"by_type"`
+	ByLocation     []Count `json:"by_location"`
+	ByEnabled      []Count `json:"by_enabled"`
+	SignInsByMonth []Month `json:"sign_ins_by_month"`
+}
+
+// Build computes the report of a run's output, grouping users by the given fields. Users marked as
+// deleted by an incremental run are left out.
+func Build(output *storage.Output, fields Fields) *Report {
+	fields = fields.withDefaults()
+	report := &Report{Fields: fields}
+	byType, byLocation, byEnabled := make(map[string]int), make(map[string]int), make(map[string]int)
+
+	for _, user := range output.Users {
+		if user["op"] == "deleted" {
+			continue
+		}
+		report.Users++
+
+		userType := group(lookup(user, fields.Type))
+		byType[userType]++
+		switch strings.ToLower(userType) {
+		case "guest":
+			report.Guests++
+		case "member":
+			report.Members++
+		}
+		byLocation[group(lookup(user, fields.Location))]++
+
+		enabled, known := lookup(user, fields.Enabled).(bool)
+		switch {
+		case !known:
+			byEnabled[Unknown]++
+		case enabled:
+			report.Enabled++
+			byEnabled["true"]++
+		default:
+			report.Disabled++
+			byEnabled["false"]++
+		}
+	}
+
+	report.ByType = counts(byType)
+	report.ByLocation = counts(byLocation)
+	report.ByEnabled = counts(byEnabled)
+	report.SignInsByMonth = months(output.SignIns)
+	return report
+}
+
+// group returns the value users are grouped by for a field value.
+func group(value interface{}) string {
+	if value == nil || value == "" {
+		return Unknown
+	}
+	return fmt.Sprint(value)
+}
+
+// lookup returns the value at a dot-separated path of a user, nil when missing.
+func lookup(user map[string]interface{}, path string) interface{} {
+	var value interface{} = user
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+func counts(groups map[string]int) []Count {
+	result := make([]Count, 0, len(groups))
+	for value, users := range groups {
+		result = append(result, Count{Value: value, Users: users})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Users != result[j].Users {
+			return result[i].Users > result[j].Users
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// months builds the histogram of sign-ins by month, with Unknown last.
+func months(signIns []storage.SignIn) []Month {
+	byMonth := make(map[string]*Month)
+	for _, signIn := range signIns {
+		key := Unknown
+		if t, err := utils.ParseTimestamp(signIn.TimeStamp); err == nil {
+			key = t.Format("2006-01")
+		}
+		month, exists := byMonth[key]
+		if !exists {
+			month = &Month{Month: key}
+			byMonth[key] = month
+		}
+
+		switch signIn.Type {
+		case interactiveType:
+			month.Interactive++
+		case nonInteractiveType:
+			month.NonInteractive++
+		case successfulType:
+			month.Successful++
+		}
+	}
+
+	result := make([]Month, 0, len(byMonth))
+	for _, month := range byMonth {
+		result = append(result, *month)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Month == Unknown || result[j].Month == Unknown {
+			return result[j].Month == Unknown && result[i].Month != Unknown
+		}
+		return result[i].Month < result[j].Month
+	})
+	return result
+}
+
+// sections are the count tables of a report, in the order they are rendered.
+func (r *Report) sections() []section {
+	return []section{
+		{Title: "Users by type", Counts: r.ByType},
+		{Title: "Users by location", Counts: r.ByLocation},
+		{Title: "Users by enabled state", Counts: r.ByEnabled},
+	}
+}
+
+type section struct {
+	Title  string
+	Counts []Count
+}
+
+// cell escapes a value for a Markdown table cell, where a pipe would end the cell and a line break the row.
+func cell(value string) string {
+	value = strings.ReplaceAll(value, `|`, `\|`)
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ").Replace(value)
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(template.FuncMap{"cell": cell}).Parse(`# User Report
+
+| Users | Enabled | Disabled | Guests | Members |
+|------:|--------:|---------:|-------:|--------:|
+| {{.Users}} | {{.Enabled}} | {{.Disabled}} | {{.Guests}} | {{.Members}} |
+{{range .Sections}}
+## {{.Title}}
+
+| Value | Users |
+|-------|------:|
+{{range .Counts}}| {{cell .Value}} | {{.Users}} |
+{{end}}{{end}}
+## Sign-ins by month
+
+| Month | Interactive | Non-interactive | Successful |
+|-------|------------:|----------------:|-----------:|
+{{range .SignInsByMonth}}| {{.Month}} | {{.Interactive}} | {{.NonInteractive}} | {{.Successful}} |
+{{end}}`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>User Report</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.75em; }
+td.number { text-align: right; }
+</style>
+</head>
+<body>
+<h1>User Report</h1>
+<table>
+<tr><th>Users</th><th>Enabled</th><th>Disabled</th><th>Guests</th><th>Members</th></tr>
+<tr><td class="number">{{.Users}}</td><td class="number">{{.Enabled}}</td><td class="number">{{.Disabled}}</td><td class="number">{{.Guests}}</td><td class="number">{{.Members}}</td></tr>
+</table>
+{{range .Sections}}<h2>{{.Title}}</h2>
+<table>
+<tr><th>Value</th><th>Users</th></tr>
+{{range .Counts}}<tr><td>{{.Value}}</td><td class="number">{{.Users}}</td></tr>
+{{end}}</table>
+{{end}}<h2>Sign-ins by month</h2>
+<table>
+<tr><th>Month</th><th>Interactive</th><th>Non-interactive</th><th>Successful</th></tr>
+{{range .SignInsByMonth}}<tr><td>{{.Month}}</td><td class="number">{{.Interactive}}</td><td class="number">{{.NonInteractive}}</td><td class="number">{{.Successful}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// view is the data the templates render.
+type view struct {
+	*Report
+	Sections []section
+}
+
+// WriteMarkdown writes the report as Markdown tables.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	return markdownTemplate.Execute(w, view{Report: r, Sections: r.sections()})
+}
+
+// WriteHTML writes the report as a standalone HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, view{Report: r, Sections: r.sections()})
+}
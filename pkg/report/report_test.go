@@ -0,0 +1,134 @@
+package report_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/report"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/storage"
+)
+
+func sampleOutput() *storage.Output {
+	return &storage.Output{
+		Users: []map[string]interface{}{
+			{"id": "1", "type": "Member", "location": "US", "is_enabled": true},
+			{"id": "2", "type": "Guest", "location": "US", "is_enabled": false},
+			{"id": "3", "type": "Member", "location": nil, "is_enabled": true},
+			{"id": "4", "op": "deleted"},
+		},
+		SignIns: []storage.SignIn{
+			{UserID: "1", Type: "lastSignInDateTime", TimeStamp: "2024-02-10T08:00:00"},
+			{UserID: "1", Type: "lastSuccessfulSignInDateTime", TimeStamp: "2024-02-10T08:00:00"},
+			{UserID: "2", Type: "lastNonInteractiveSignInDateTime", TimeStamp: "2024-01-31T23:59:59Z"},
+			{UserID: "3", Type: "lastSignInDateTime", TimeStamp: "never"},
+		},
+	}
+}
+
+func TestBuild(t *testing.T) {
+	r := report.Build(sampleOutput(), report.DefaultFields)
+
+	if r.Users != 3 || r.Enabled != 2 || r.Disabled != 1 || r.Guests != 1 || r.Members != 2 {
+		t.Errorf("Unexpected totals: %+v", r)
+	}
+	if len(r.ByType) != 2 || r.ByType[0] != (report.Count{Value: "Member", Users: 2}) {
+		t.Errorf("Unexpected counts by type: %+v", r.ByType)
+	}
+	if len(r.ByLocation) != 2 || r.ByLocation[1] != (report.Count{Value: report.Unknown, Users: 1}) {
+		t.Errorf("Expected users without a location counted as unknown, got %+v", r.ByLocation)
+	}
+
+	expected := []report.Month{
+		{Month: "2024-01", NonInteractive: 1},
+		{Month: "2024-02", Interactive: 1, Successful: 1},
+		{Month: report.Unknown, Interactive: 1},
+	}
+	if len(r.SignInsByMonth) != len(expected) {
+		t.Fatalf("Expected %d months, got %+v", len(expected), r.SignInsByMonth)
+	}
+	for i, month := range expected {
+		if r.SignInsByMonth[i] != month {
+			t.Errorf("Expected month %+v, got %+v", month, r.SignInsByMonth[i])
+		}
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	r := report.Build(sampleOutput(), report.DefaultFields)
+
+	var markdown bytes.Buffer
+	if err := r.WriteMarkdown(&markdown); err != nil {
+		t.Fatalf("Failed to write Markdown: %v", err)
+	}
+	for _, line := range []string{"| 3 | 2 | 1 | 1 | 2 |", "| Member | 2 |", "| 2024-02 | 1 | 0 | 1 |"} {
+		if !strings.Contains(markdown.String(), line) {
+			t.Errorf("Expected Markdown to contain %q, got:\n%s", line, markdown.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := r.WriteHTML(&html); err != nil {
+		t.Fatalf("Failed to write HTML: %v", err)
+	}
+	if !strings.Contains(html.String(), "<td>(unknown)</td>") || !strings.HasPrefix(html.String(), "<!DOCTYPE html>") {
+		t.Errorf("Unexpected HTML report:\n%s", html.String())
+	}
+}
+
+func TestBuild_Fields(t *testing.T) {
+	output := &storage.Output{Users: []map[string]interface{}{
+		{"id": "1", "kind": "Guest", "address": map[string]interface{}{"country": "US | CA"}, "active": true},
+		{"id": "2", "kind": "Member", "address": map[string]interface{}{"country": "FR\nDE"}, "active": false},
+	}}
+	r := report.Build(output, report.Fields{Type: "kind", Location: "address.country", Enabled: "active"})
+
+	if r.Guests != 1 || r.Members != 1 || r.Enabled != 1 || r.Disabled != 1 {
+		t.Errorf("Expected users grouped by the given fields, got %+v", r)
+	}
+
+	var markdown bytes.Buffer
+	if err := r.WriteMarkdown(&markdown); err != nil {
+		t.Fatalf("Failed to write Markdown: %v", err)
+	}
+	for _, line := range []string{`| US \| CA | 1 |`, "| FR DE | 1 |"} {
+		if !strings.Contains(markdown.String(), line) {
+			t.Errorf("Expected Markdown to contain the escaped cell %q, got:\n%s", line, markdown.String())
+		}
+	}
+}
+
+func TestFields_Check(t *testing.T) {
+	ruleSet, err := rules.Parse([]byte(`{
+		"id": "id",
+		"type": {"$path": "userType", "$protect": "tokenize"},
+		"is_enabled": {"$path": "accountEnabled", "$encrypt": true}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	warnings := report.DefaultFields.Check(ruleSet)
+	expected := []string{
+		`report field "type" is sealed with $protect tokenize`,
+		`report field "location" is not produced by the rules`,
+		`report field "is_enabled" is sealed with $encrypt`,
+	}
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %q", len(expected), warnings)
+	}
+	for i, prefix := range expected {
+		if !strings.HasPrefix(warnings[i], prefix) {
+			t.Errorf("Expected a warning starting with %q, got %q", prefix, warnings[i])
+		}
+	}
+
+	defaults, err := rules.Load("../../configs/default_mapping_config.json")
+	if err != nil {
+		t.Fatalf("Failed to load the default rules: %v", err)
+	}
+	if warnings := report.DefaultFields.Check(defaults); len(warnings) != 0 {
+		t.Errorf("Expected no warning with the default rules, got %q", warnings)
+	}
+}
@@ -0,0 +1,244 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// envPattern matches ${NAME} and ${NAME:-default} references, and the escaped form $${...}.
+var envPattern = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// loadDocument reads a rules file and composes it with the files it extends and includes.
+// The chain holds the files currently being loaded, to detect cycles.
+func loadDocument(path string, chain []string) (*document, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, loading := range chain {
+		if loading == absPath {
+			return nil, fmt.Errorf("rules file %s extends or includes itself: %s", path, strings.Join(append(chain, absPath), " -> "))
+		}
+	}
+	chain = append(chain, absPath)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading rules file: %w", err)
+	}
+
+	doc, err := decodeFile(data, path)
+	if err != nil {
+		return nil, err
+	}
+	doc.interpolate(os.LookupEnv)
+	doc.resolveLookupFiles(filepath.Dir(path))
+	doc.resolvePrivacyKeyFile(filepath.Dir(path))
+
+	composed := newDocument()
+	composed.positions[""] = doc.positions[""]
+
+	for _, base := range doc.references(ExtendsDirective, filepath.Dir(path)) {
+		baseDoc, err := loadDocument(base, chain)
+		if err != nil {
+			return nil, err
+		}
+		composed.merge(baseDoc, false)
+	}
+
+	for _, include := range doc.references(IncludeDirective, filepath.Dir(path)) {
+		includedDoc, err := loadDocument(include, chain)
+		if err != nil {
+			return nil, err
+		}
+		composed.merge(includedDoc, true)
+	}
+
+	composed.merge(doc, false)
+	return composed, nil
+}
+
+// references removes a composition directive from the document and returns the files it lists,
+// relative to the given directory.
+func (doc *document) references(directive, dir string) []string {
+	value, exists := doc.root[directive]
+	if !exists {
+		return nil
+	}
+	pointer := "/" + escapePointer(directive)
+	defer func() {
+		delete(doc.root, directive)
+		doc.removeKey("", directive)
+	}()
+
+	var files []string
+	switch v := value.(type) {
+	case string:
+		files = append(files, v)
+	case []interface{}:
+		for i, item := range v {
+			if s, ok := item.(string); ok {
+				files = append(files, s)
+			} else {
+				doc.report(fmt.Sprintf("%s/%d", pointer, i), fmt.Sprintf("expected a rules file path, got %s", kindOf(item)))
+			}
+		}
+	default:
+		doc.report(pointer, fmt.Sprintf("expected a rules file path or a list of paths, got %s", kindOf(value)))
+	}
+
+	for i, file := range files {
+		if !filepath.IsAbs(file) {
+			files[i] = filepath.Join(dir, file)
+		}
+	}
+	return files
+}
+
+// merge overlays src onto the document. Groups are merged field by field, every other rule is
+// replaced as a whole, and a null value removes an inherited field.
+// In strict mode (includes) a field defined on both sides is reported as a conflict instead.
+func (doc *document) merge(src *document, strict bool) {
+	doc.problems = append(doc.problems, src.problems...)
+	doc.mergeObject(src, "", doc.root, src.root, strict)
+}
+
+func (doc *document) mergeObject(src *document, pointer string, dst, overlay map[string]interface{}, strict bool) {
+	for _, key := range src.keyOrder[pointer] {
+		childPointer := pointer + "/" + escapePointer(key)
+		value := overlay[key]
+		existing, exists := dst[key]
+
+		existingGroup, existingIsGroup := existing.(map[string]interface{})
+		valueGroup, valueIsGroup := value.(map[string]interface{})
+		mergeable := existingIsGroup && valueIsGroup && !isFieldRule(existingGroup) && !isFieldRule(valueGroup) &&
+			pointer != "/"+escapePointer(LookupsDirective) && childPointer != "/"+escapePointer(PrivacyDirective)
+
+		switch {
+		case mergeable:
+			doc.mergeObject(src, childPointer, existingGroup, valueGroup, strict)
+			continue
+		case exists && strict:
+			doc.problems = append(doc.problems, Issue{
+				Pos:     src.positions[childPointer],
+				Pointer: childPointer,
+				Message: fmt.Sprintf("conflicting include, the field is already defined in %s", doc.positions[childPointer].File),
+			})
+			continue
+		case exists && value == nil && !strict:
+			delete(dst, key)
+			doc.removeKey(pointer, key)
+			continue
+		}
+
+		if !exists {
+			doc.keyOrder[pointer] = append(doc.keyOrder[pointer], key)
+		}
+		dst[key] = value
+		src.copyPositions(doc, childPointer)
+	}
+	if _, exists := doc.positions[pointer]; !exists {
+		doc.positions[pointer] = src.positions[pointer]
+	}
+}
+
+// removeKey deletes a key from the recorded key order of an object.
+func (doc *document) removeKey(pointer, key string) {
+	order := doc.keyOrder[pointer][:0]
+	for _, k := range doc.keyOrder[pointer] {
+		if k != key {
+			order = append(order, k)
+		}
+	}
+	doc.keyOrder[pointer] = order
+}
+
+// copyPositions copies the positions and key order of a subtree into another document.
+func (doc *document) copyPositions(dst *document, pointer string) {
+	for p, pos := range doc.positions {
+		if p == pointer || strings.HasPrefix(p, pointer+"/") {
+			dst.positions[p] = pos
+		}
+	}
+	for p, keys := range doc.keyOrder {
+		if p == pointer || strings.HasPrefix(p, pointer+"/") {
+			dst.keyOrder[p] = append([]string(nil), keys...)
+		}
+	}
+}
+
+// interpolate replaces ${NAME} and ${NAME:-default} references to environment variables in every string value.
+// $${NAME} is kept literally as ${NAME}.
+func (doc *document) interpolate(lookupEnv func(string) (string, bool)) {
+	var visit func(pointer string, value interface{}) interface{}
+	visit = func(pointer string, value interface{}) interface{} {
+		switch v := value.(type) {
+		case string:
+			return envPattern.ReplaceAllStringFunc(v, func(match string) string {
+				if strings.HasPrefix(match, "$$") {
+					return match[1:]
+				}
+				groups := envPattern.FindStringSubmatch(match)
+				if val, ok := lookupEnv(groups[1]); ok {
+					return val
+				}
+				if groups[2] != "" {
+					return groups[3]
+				}
+				doc.report(pointer, fmt.Sprintf("environment variable %s is not set", groups[1]))
+				return match
+			})
+		case []interface{}:
+			for i, item := range v {
+				v[i] = visit(fmt.Sprintf("%s/%d", pointer, i), item)
+			}
+		case map[string]interface{}:
+			for key, item := range v {
+				v[key] = visit(pointer+"/"+escapePointer(key), item)
+			}
+		}
+		return value
+	}
+	visit("", doc.root)
+}
+
+// resolveLookupFiles makes the relative file paths of lookup declarations relative to the given directory,
+// so tables are found regardless of the working directory or the file that includes the rules.
+func (doc *document) resolveLookupFiles(dir string) {
+	declarations, ok := doc.root[LookupsDirective].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	resolve := func(file string) string {
+		if file == "" || filepath.IsAbs(file) {
+			return file
+		}
+		return filepath.Join(dir, file)
+	}
+
+	for name, declaration := range declarations {
+		switch d := declaration.(type) {
+		case string:
+			declarations[name] = resolve(d)
+		case map[string]interface{}:
+			if file, ok := d["file"].(string); ok {
+				d["file"] = resolve(file)
+			}
+		}
+	}
+}
+
+// resolvePrivacyKeyFile makes a relative privacy key file path relative to the given directory.
+func (doc *document) resolvePrivacyKeyFile(dir string) {
+	declaration, ok := doc.root[PrivacyDirective].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if file, ok := declaration["key_file"].(string); ok && file != "" && !filepath.IsAbs(file) {
+		declaration["key_file"] = filepath.Join(dir, file)
+	}
+}
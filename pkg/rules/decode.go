@@ -0,0 +1,227 @@
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Position is a location in a rules file. Line and column are 1-based, and are zero for
+// formats that do not report positions (TOML).
+type Position struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+}
+
+// String formats the position as file:line:column, leaving out the unknown parts.
+func (p Position) String() string {
+	var parts []string
+	if p.File != "" {
+		parts = append(parts, p.File)
+	}
+	if p.Line > 0 {
+		parts = append(parts, fmt.Sprintf("%d:%d", p.Line, p.Column))
+	}
+	return strings.Join(parts, ":")
+}
+
+// document is a decoded rules file together with the source position and key order of every value.
+// Values are addressed by JSON pointer, e.g. "/sign_in_activity/lastSignInDateTime".
+type document struct {
+	root      map[string]interface{}
+	positions map[string]Position
+	keyOrder  map[string][]string
+	// problems holds issues found while decoding and composing, before the rules themselves are validated.
+	problems []Issue
+}
+
+func newDocument() *document {
+	return &document{
+		root:      make(map[string]interface{}),
+		positions: make(map[string]Position),
+		keyOrder:  make(map[string][]string),
+	}
+}
+
+// indexKeys records the key order of every object below the given pointer, sorted by name.
+func (doc *document) indexKeys(pointer string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		doc.keyOrder[pointer] = keys
+		for _, key := range keys {
+			doc.indexKeys(pointer+"/"+escapePointer(key), v[key])
+		}
+	case []interface{}:
+		for i, item := range v {
+			doc.indexKeys(fmt.Sprintf("%s/%d", pointer, i), item)
+		}
+	}
+}
+
+// report records an issue at the position of the given pointer.
+func (doc *document) report(pointer, message string) {
+	doc.problems = append(doc.problems, Issue{Pos: doc.positions[pointer], Pointer: pointer, Message: message})
+}
+
+// decode parses JSON rules, recording the position of each value so validation issues can point at the source.
+// The file name, if any, is only used to annotate positions.
+func decode(data []byte, file string) (*document, error) {
+	d := &positionDecoder{
+		dec:   json.NewDecoder(bytes.NewReader(data)),
+		data:  data,
+		file:  file,
+		lines: lineOffsets(data),
+		doc:   newDocument(),
+	}
+
+	value, err := d.value("")
+	if err != nil {
+		return nil, d.syntaxError(err)
+	}
+	if _, err := d.dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("%s: unexpected data after the rules object", d.position(int(d.dec.InputOffset())))
+	}
+
+	root, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: rules must be a JSON object, got %s", d.position(0), kindOf(value))
+	}
+	d.doc.root = root
+	return d.doc, nil
+}
+
+type positionDecoder struct {
+	dec   *json.Decoder
+	data  []byte
+	file  string
+	lines []int
+	doc   *document
+}
+
+// value decodes the next JSON value, registering its position under the given pointer.
+func (d *positionDecoder) value(pointer string) (interface{}, error) {
+	pos := d.position(d.nextOffset())
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	d.doc.positions[pointer] = pos
+
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+
+	switch delim {
+	case '{':
+		object := make(map[string]interface{})
+		for d.dec.More() {
+			keyTok, err := d.dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyTok.(string)
+			childPointer := pointer + "/" + escapePointer(key)
+
+			_, duplicate := object[key]
+			if !duplicate {
+				d.doc.keyOrder[pointer] = append(d.doc.keyOrder[pointer], key)
+			}
+
+			if object[key], err = d.value(childPointer); err != nil {
+				return nil, err
+			}
+			if duplicate {
+				d.doc.report(childPointer, "duplicate field, this definition overrides an earlier one which is unreachable")
+			}
+		}
+		_, err = d.dec.Token()
+		return object, err
+
+	case '[':
+		list := []interface{}{}
+		for i := 0; d.dec.More(); i++ {
+			item, err := d.value(fmt.Sprintf("%s/%d", pointer, i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		_, err = d.dec.Token()
+		return list, err
+	}
+
+	return nil, fmt.Errorf("unexpected delimiter %q", delim)
+}
+
+// nextOffset returns the offset of the next value, skipping whitespace and separators after the last token.
+func (d *positionDecoder) nextOffset() int {
+	offset := int(d.dec.InputOffset())
+	for offset < len(d.data) && strings.ContainsRune(" \t\r\n,:", rune(d.data[offset])) {
+		offset++
+	}
+	return offset
+}
+
+// position converts a byte offset into a line and column.
+func (d *positionDecoder) position(offset int) Position {
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset })
+	return Position{File: d.file, Line: line, Column: offset - d.lines[line-1] + 1}
+}
+
+// syntaxError annotates JSON syntax errors with the line and column they occurred at.
+func (d *positionDecoder) syntaxError(err error) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("%s: %v", d.position(int(syntaxErr.Offset)), syntaxErr)
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%s: unexpected end of rules file", d.position(len(d.data)))
+	}
+	return fmt.Errorf("%s: %v", d.position(int(d.dec.InputOffset())), err)
+}
+
+// lineOffsets returns the byte offset at which every line starts.
+func lineOffsets(data []byte) []int {
+	offsets := []int{0}
+	for i, b := range data {
+		if b == '\n' {
+			offsets = append(offsets, i+1)
+		}
+	}
+	return offsets
+}
+
+// escapePointer escapes a key for use in a JSON pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// kindOf describes a decoded JSON value for error messages.
+func kindOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", value)
+}
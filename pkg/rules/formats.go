@@ -0,0 +1,209 @@
+package rules
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// decodeFile decodes rules in the format given by the file extension: JSON (default), YAML or TOML.
+func decodeFile(data []byte, file string) (*document, error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return decodeYAML(data, file)
+	case ".toml":
+		return decodeTOML(data, file)
+	}
+	return decode(data, file)
+}
+
+// decodeYAML decodes YAML rules, keeping the line and column of every node.
+func decodeYAML(data []byte, file string) (*document, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	doc := newDocument()
+	if len(root.Content) == 0 {
+		return doc, nil
+	}
+
+	value, err := doc.fromYAML(root.Content[0], "", file)
+	if err != nil {
+		return nil, err
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: rules must be a mapping, got %s", doc.positions[""], kindOf(value))
+	}
+	doc.root = object
+	return doc, nil
+}
+
+// fromYAML converts a YAML node into the generic values produced by encoding/json.
+func (doc *document) fromYAML(node *yaml.Node, pointer, file string) (interface{}, error) {
+	pos := Position{File: file, Line: node.Line, Column: node.Column}
+	doc.positions[pointer] = pos
+
+	switch node.Kind {
+	case yaml.AliasNode:
+		return doc.fromYAML(node.Alias, pointer, file)
+
+	case yaml.MappingNode:
+		object := make(map[string]interface{})
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			childPointer := pointer + "/" + escapePointer(key)
+
+			_, duplicate := object[key]
+			if !duplicate {
+				doc.keyOrder[pointer] = append(doc.keyOrder[pointer], key)
+			}
+
+			value, err := doc.fromYAML(node.Content[i+1], childPointer, file)
+			if err != nil {
+				return nil, err
+			}
+			object[key] = value
+			if duplicate {
+				doc.report(childPointer, "duplicate field, this definition overrides an earlier one which is unreachable")
+			}
+		}
+		return object, nil
+
+	case yaml.SequenceNode:
+		list := make([]interface{}, 0, len(node.Content))
+		for i, item := range node.Content {
+			value, err := doc.fromYAML(item, pointer+"/"+strconv.Itoa(i), file)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		return list, nil
+	}
+
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, fmt.Errorf("%s: %w", pos, err)
+	}
+	return normalize(value), nil
+}
+
+// decodeTOML decodes TOML rules. TOML does not report value positions, so issues only name the file.
+func decodeTOML(data []byte, file string) (*document, error) {
+	var root map[string]interface{}
+	meta, err := toml.Decode(string(data), &root)
+	if err != nil {
+		if parseErr, ok := err.(toml.ParseError); ok {
+			pos := Position{File: file, Line: parseErr.Position.Line, Column: parseErr.Position.Col}
+			return nil, fmt.Errorf("%s: %s", pos, parseErr.Message)
+		}
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+
+	doc := newDocument()
+	doc.root = normalize(root).(map[string]interface{})
+
+	// Keys are reported in document order, which is kept for the fields. Their paths hold no array indexes,
+	// so the order is looked up by path for the objects of every array, such as alternatives.
+	rank := make(map[string]int)
+	for i, key := range meta.Keys() {
+		path := ""
+		for _, part := range key {
+			path += "/" + escapePointer(part)
+		}
+		if _, exists := rank[path]; !exists {
+			rank[path] = i
+		}
+	}
+	doc.indexTOML("", "", doc.root, rank, file)
+	return doc, nil
+}
+
+// indexTOML records the key order and position of every object below the given pointer. Path is the pointer
+// without array indexes; keys missing from rank, such as those of inline tables in arrays, come last by name.
+func (doc *document) indexTOML(pointer, path string, value interface{}, rank map[string]int, file string) {
+	doc.positions[pointer] = Position{File: file}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, aRanked := rank[path+"/"+escapePointer(keys[i])]
+			b, bRanked := rank[path+"/"+escapePointer(keys[j])]
+			if aRanked != bRanked {
+				return aRanked
+			}
+			if aRanked && a != b {
+				return a < b
+			}
+			return keys[i] < keys[j]
+		})
+		doc.keyOrder[pointer] = keys
+		for _, key := range keys {
+			doc.indexTOML(pointer+"/"+escapePointer(key), path+"/"+escapePointer(key), v[key], rank, file)
+		}
+	case []interface{}:
+		for i, item := range v {
+			doc.indexTOML(pointer+"/"+strconv.Itoa(i), path, item, rank, file)
+		}
+	}
+}
+
+// normalize converts decoded YAML and TOML values into the types produced by encoding/json,
+// so every format yields identical rules. TOML dates and times become strings in the layout they were
+// written in.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Time:
+		switch v.Location().String() {
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[fmt.Sprint(key)] = normalize(item)
+		}
+		return object
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = normalize(item)
+		}
+		return list
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	}
+	return value
+}
@@ -0,0 +1,271 @@
+package rules
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"pathid_assignment/configs"
+	"pathid_assignment/pkg/coerce"
+	"pathid_assignment/pkg/lookup"
+	"pathid_assignment/pkg/privacy"
+)
+
+// Rule directives are rule keys prefixed with "$". At the top level of the rules they configure the
+// transformation itself; inside a rule object they turn the object into a single field rule instead
+// of a nested group of target fields.
+const (
+	// FilterDirective holds one or more conditions a record must satisfy to be transformed at all.
+	FilterDirective = "$filter"
+	// LookupsDirective declares the lookup tables available to field rules, keyed by name.
+	LookupsDirective = "$lookups"
+	// ExtendsDirective names the rules files this file inherits from and overrides.
+	ExtendsDirective = "$extends"
+	// IncludeDirective names rules files whose fields are added to this file; conflicting fields are errors.
+	IncludeDirective = "$include"
+	// WhenDirective holds the condition under which a field rule applies.
+	WhenDirective = "$when"
+	// PathDirective holds the dot-separated source path of a field rule.
+	PathDirective = "$path"
+	// ValueDirective holds a constant value for a field rule.
+	ValueDirective = "$value"
+	// LookupDirective names the lookup table a field rule maps its value through.
+	LookupDirective = "$lookup"
+	// UnmatchedDirective selects how values missing from the lookup table are handled: keep, default or fail.
+	UnmatchedDirective = "$unmatched"
+	// DefaultDirective holds the value used for unmatched lookups in "default" mode.
+	DefaultDirective = "$default"
+	// TypeDirective declares the type a field rule's value is coerced to, e.g. "bool" or "timestamp".
+	TypeDirective = "$type"
+	// ProtectDirective selects how a field rule's value is protected: redact, mask, hmac or tokenize.
+	ProtectDirective = "$protect"
+	// EncryptDirective marks a field rule whose value is encrypted at rest when storage encryption is enabled.
+	EncryptDirective = "$encrypt"
+	// PrivacyDirective declares where the key of the hmac and tokenize protections is read from.
+	PrivacyDirective = "$privacy"
+)
+
+// Kind identifies how a rule produces its value.
+type Kind string
+
+const (
+	// KindPath copies the value found at a dot-separated source path.
+	KindPath Kind = "path"
+	// KindField is a field rule object built from directives.
+	KindField Kind = "field"
+	// KindAlternatives applies the first of several rules whose condition holds.
+	KindAlternatives Kind = "alternatives"
+	// KindGroup produces a nested object from a mapping of target keys to rules.
+	KindGroup Kind = "group"
+)
+
+//go:embed schema.json
+var schema []byte
+
+// Rules is the typed model of a rules file.
+type Rules struct {
+	// Filter holds the conditions a record must satisfy to be transformed.
+	Filter []string
+	// Lookups holds the declared lookup tables, keyed by name.
+	Lookups map[string]lookup.Spec
+	// Privacy tells where the key of keyed protections is read from.
+	Privacy privacy.KeySpec
+	// Fields holds the target field rules in the order they are declared.
+	Fields []*Rule
+	// Document is the decoded rules file, as consumed by map-based transformers.
+	Document map[string]interface{}
+}
+
+// Rule is a single rule producing one target field, or one alternative of a field.
+type Rule struct {
+	Target       string
+	Kind         Kind
+	Path         string
+	Value        interface{}
+	HasValue     bool
+	When         []string
+	Lookup       string
+	Unmatched    string
+	Default      interface{}
+	Type         coerce.Type
+	Protect      privacy.Method
+	Encrypt      bool
+	Alternatives []*Rule
+	Fields       []*Rule
+	Pos          Position
+}
+
+// Sealed tells why the values of the field cannot be read in plain text from the outputs, the directive
+// protecting or encrypting it, or returns "" when they can. A field with alternatives is sealed when any
+// of its alternatives is.
+func (r *Rule) Sealed() string {
+	switch {
+	case r.Protect != "":
+		return fmt.Sprintf("%s %s", ProtectDirective, r.Protect)
+	case r.Encrypt:
+		return EncryptDirective
+	}
+	for _, alternative := range r.Alternatives {
+		if sealed := alternative.Sealed(); sealed != "" {
+			return sealed
+		}
+	}
+	return ""
+}
+
+// Schema returns the JSON Schema describing the rules file format.
+func Schema() []byte {
+	return schema
+}
+
+// Default returns the built-in default rules, embedded from configs/default_mapping_config.json.
+func Default() (*Rules, error) {
+	return Parse(configs.DefaultMapping)
+}
+
+// Load reads, composes and validates a rules file. The format is chosen by the file extension:
+// .yaml/.yml for YAML, .toml for TOML and JSON otherwise. Files named by "$extends" and "$include"
+// are resolved relative to the file referencing them, as are the files of lookup tables and privacy keys.
+// Invalid rules are reported as a *ValidationError listing every issue found with its position.
+func Load(path string) (*Rules, error) {
+	doc, err := loadDocument(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return build(doc)
+}
+
+// Parse parses and validates standalone JSON rules. Environment variables are interpolated,
+// but "$extends" and "$include" are only supported by Load.
+func Parse(data []byte) (*Rules, error) {
+	doc, err := decode(data, "")
+	if err != nil {
+		return nil, err
+	}
+	doc.interpolate(os.LookupEnv)
+	return build(doc)
+}
+
+// FromMap validates rules that were already decoded into generic values, such as the rules maps
+// passed to GenericTransformer.Transform. Fields are ordered by name, and issues carry no positions.
+func FromMap(rulesMap map[string]interface{}) (*Rules, error) {
+	doc := newDocument()
+	doc.root = rulesMap
+	doc.indexKeys("", rulesMap)
+	return build(doc)
+}
+
+// build validates a decoded document and converts it into the typed model.
+func build(doc *document) (*Rules, error) {
+	v := &validator{doc: doc, issues: doc.problems}
+	rules := &Rules{
+		Lookups:  make(map[string]lookup.Spec),
+		Document: doc.root,
+	}
+
+	// Lookups are read first so field rules can be checked against the declared tables.
+	if declarations, exists := doc.root[LookupsDirective]; exists {
+		rules.Lookups = v.lookups("/"+escapePointer(LookupsDirective), declarations)
+	}
+
+	for _, key := range doc.keyOrder[""] {
+		pointer := "/" + escapePointer(key)
+		value := doc.root[key]
+
+		switch {
+		case key == FilterDirective:
+			rules.Filter = v.conditions(pointer, value)
+		case key == LookupsDirective:
+		case key == PrivacyDirective:
+			rules.Privacy = v.privacy(pointer, value)
+		case key == ExtendsDirective || key == IncludeDirective:
+			v.report(pointer, fmt.Sprintf("%s is only supported when loading rules from a file", key))
+		case strings.HasPrefix(key, "$"):
+			v.report(pointer, fmt.Sprintf("unknown directive %q", key))
+		default:
+			if rule := v.rule(pointer, key, value, rules.Lookups); rule != nil {
+				rules.Fields = append(rules.Fields, rule)
+			}
+		}
+	}
+
+	if len(v.issues) > 0 {
+		return nil, v.err()
+	}
+	return rules, nil
+}
+
+// Targets returns the dot-separated target paths of every field the rules can produce,
+// e.g. "sign_in_activity.lastSignInDateTime".
+func (r *Rules) Targets() []string {
+	var targets []string
+	var walk func(prefix string, fields []*Rule)
+	walk = func(prefix string, fields []*Rule) {
+		for _, field := range fields {
+			if field.Kind == KindGroup {
+				walk(prefix+field.Target+".", field.Fields)
+				continue
+			}
+			targets = append(targets, prefix+field.Target)
+		}
+	}
+	walk("", r.Fields)
+	return targets
+}
+
+// Field returns the rule producing the field at a dot-separated target path, nil when the rules do
+// not produce it.
+func (r *Rules) Field(target string) *Rule {
+	fields := r.Fields
+	keys := strings.Split(target, ".")
+	for i, key := range keys {
+		var found *Rule
+		for _, field := range fields {
+			if field.Target == key {
+				found = field
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		if i == len(keys)-1 {
+			return found
+		}
+		if found.Kind != KindGroup {
+			return nil
+		}
+		fields = found.Fields
+	}
+	return nil
+}
+
+// Encrypted returns the dot-separated target paths of the fields marked with "$encrypt". A field with
+// alternatives is encrypted when any of its alternatives is marked.
+func (r *Rules) Encrypted() []string {
+	var encrypted []string
+	var walk func(prefix string, fields []*Rule)
+	walk = func(prefix string, fields []*Rule) {
+		for _, field := range fields {
+			switch field.Kind {
+			case KindGroup:
+				walk(prefix+field.Target+".", field.Fields)
+				continue
+			case KindAlternatives:
+				for _, alternative := range field.Alternatives {
+					if alternative.Encrypt {
+						encrypted = append(encrypted, prefix+field.Target)
+						break
+					}
+				}
+				continue
+			}
+			if field.Encrypt {
+				encrypted = append(encrypted, prefix+field.Target)
+			}
+		}
+	}
+	walk("", r.Fields)
+	return encrypted
+}
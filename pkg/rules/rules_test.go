@@ -0,0 +1,368 @@
+package rules_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pathid_assignment/pkg/coerce"
+	"pathid_assignment/pkg/privacy"
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/schema"
+)
+
+func TestParse_DefaultRules(t *testing.T) {
+	ruleSet, err := rules.Load("../../configs/default_mapping_config.json")
+	if err != nil {
+		t.Fatalf("Expected default rules to be valid, got %v", err)
+	}
+
+	if len(ruleSet.Fields) != 9 {
+		t.Errorf("Expected 9 top-level fields, got %d", len(ruleSet.Fields))
+	}
+	if ruleSet.Fields[0].Target != "id" || ruleSet.Fields[0].Kind != rules.KindPath {
+		t.Errorf("Expected first field to be the 'id' path rule, got %+v", ruleSet.Fields[0])
+	}
+
+	signIn := ruleSet.Fields[8]
+	if signIn.Kind != rules.KindGroup || len(signIn.Fields) != 6 {
+		t.Errorf("Expected sign_in_activity to be a group of 6 fields, got %+v", signIn)
+	}
+}
+
+func TestParse_ConditionalRules(t *testing.T) {
+	ruleSet, err := rules.Parse([]byte(`{
+		"$filter": "accountEnabled == true",
+		"$lookups": {"roles": "roles.json"},
+		"type": [
+			{"$when": "userType == 'Guest'", "$value": "external"},
+			{"$path": "userType", "$lookup": "roles", "$unmatched": "default", "$default": "unknown"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(ruleSet.Filter) != 1 || ruleSet.Lookups["roles"].File != "roles.json" {
+		t.Errorf("Unexpected directives: %+v", ruleSet)
+	}
+
+	alternatives := ruleSet.Fields[0].Alternatives
+	if len(alternatives) != 2 || alternatives[0].Value != "external" || alternatives[1].Lookup != "roles" {
+		t.Errorf("Unexpected alternatives: %+v", alternatives)
+	}
+}
+
+func TestParse_ValidationIssues(t *testing.T) {
+	_, err := rules.Parse([]byte(`{
+  "$filtr": "accountEnabled",
+  "mail": 5,
+  "type": ["userType", {"$when": "userType == 'Guest'", "$value": "guest"}],
+  "location": {"$path": "usage..Location", "$lookup": "countries"},
+  "nested": {"a": {"b": {}}},
+  "enabled": {"$when": "accountEnabled ==", "$path": "accountEnabled"},
+  "id": {"$path": "id", "$type": "guid"},
+  "phone": {"$path": "mobilePhone", "$protect": "encrypt"}
+}`))
+
+	var validationErr *rules.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+
+	expected := []string{
+		`2:13: /$filtr: unknown directive`,
+		`3:11: /mail: unknown rule kind`,
+		`4:24: /type/1: unreachable alternative`,
+		`5:25: /location/$path: invalid path`,
+		`5:55: /location/$lookup: lookup table "countries" is not declared`,
+		`6:25: /nested/a/b: empty group`,
+		`7:24: /enabled/$when: invalid expression`,
+		`8:34: /id/$type: unknown type guid`,
+		`9:49: /phone/$protect: unknown protection encrypt`,
+	}
+
+	if len(validationErr.Issues) != len(expected) {
+		t.Fatalf("Expected %d issues, got %d:\n%v", len(expected), len(validationErr.Issues), err)
+	}
+	for i, prefix := range expected {
+		if got := validationErr.Issues[i].String(); !strings.HasPrefix(got, prefix) {
+			t.Errorf("Issue %d: expected prefix %q, got %q", i, prefix, got)
+		}
+	}
+}
+
+func TestParse_SyntaxError(t *testing.T) {
+	_, err := rules.Parse([]byte("{\n  \"id\": \"id\",\n  \"mail\" \"mail\"\n}"))
+	if err == nil || !strings.HasPrefix(err.Error(), "3:") {
+		t.Errorf("Expected a syntax error on line 3, got %v", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	var document map[string]interface{}
+	if err := json.Unmarshal(rules.Schema(), &document); err != nil {
+		t.Fatalf("Expected the schema to be valid JSON, got %v", err)
+	}
+	if _, exists := document["$defs"]; !exists {
+		t.Errorf("Expected the schema to define $defs")
+	}
+}
+
+// TestSchema_MatchesValidator runs the fixtures of the validator through the published schema, so the two
+// cannot drift apart. Only the validator checks what a schema cannot express: expressions, declared lookup
+// tables and unreachable rules.
+func TestSchema_MatchesValidator(t *testing.T) {
+	compiled, err := schema.Compile(rules.Schema())
+	if err != nil {
+		t.Fatalf("Failed to compile the rules schema: %v", err)
+	}
+
+	type fixture struct {
+		rules    string
+		valid    bool
+		semantic bool // Invalid rules that only the validator rejects.
+	}
+	fixtures := []fixture{
+		{rules: `{"id": "id", "mail": "mail"}`, valid: true},
+		{rules: `{"sign_in_activity": {"last": "signInActivity.lastSignInDateTime"}}`, valid: true},
+		{rules: `{"type": [{"$when": "userType != null", "$path": "userType"}, {"$value": "member"}]}`, valid: true},
+		{rules: `{"id": "id", "mail": null}`},
+		{rules: `{"type": [{"$when": "userType == 'Guest'", "$value": "external"}, "userType"]}`, valid: true},
+		{rules: `{"$filter": ["accountEnabled == true"], "id": "id"}`, valid: true},
+		{rules: `{"$lookups": {"roles": "roles.json", "countries": {"file": "c.csv", "key": "code", "value": "name"}}, "role": {"$path": "userType", "$lookup": "roles", "$unmatched": "default", "$default": "x"}}`, valid: true},
+		{rules: `{"$privacy": {"key_env": "KEY"}, "mail": {"$path": "mail", "$encrypt": true}}`, valid: true},
+		{rules: `{"$filtr": "accountEnabled"}`},
+		{rules: `{"mail": 5}`},
+		{rules: `{"mail": true}`},
+		{rules: `{"mail": "usage..Location"}`},
+		{rules: `{"mail": "mail address"}`},
+		{rules: `{"nested": {"a": {}}}`},
+		{rules: `{"type": []}`},
+		{rules: `{"type": [["userType"]]}`},
+		{rules: `{"mail": {"$path": 5}}`},
+		{rules: `{"mail": {"$when": "mail != null"}}`},
+		{rules: `{"mail": {"$path": "mail", "target": "mail"}}`},
+		{rules: `{"mail": {"$path": "mail", "$format": "lower"}}`},
+		{rules: `{"mail": {"$path": "mail", "$encrypt": "yes"}}`},
+		{rules: `{"mail": {"$path": "mail", "$when": 5}}`},
+		{rules: `{"$lookups": {"roles": {"key": "code"}}, "id": "id"}`},
+		{rules: `{"$lookups": {"roles": {"file": "r.json", "column": "x"}}, "id": "id"}`},
+		{rules: `{"$lookups": {"roles": 5}, "id": "id"}`},
+		{rules: `{"$privacy": {"key_env": "KEY", "key_file": "key"}, "id": "id"}`},
+		{rules: `{"$privacy": {"key_env": ""}, "id": "id"}`},
+		{rules: `{"$privacy": {"key": "KEY"}, "id": "id"}`},
+		{rules: `{"role": {"$path": "userType", "$unmatched": "fail"}}`, semantic: true},
+		{rules: `{"role": {"$path": "userType", "$lookup": "roles"}}`, semantic: true},
+		{rules: `{"role": {"$path": "userType", "$value": "x"}}`},
+		{rules: `{"type": ["userType", "mail"]}`, semantic: true},
+		{rules: `{"mail": {"$when": "mail ==", "$path": "mail"}}`, semantic: true},
+	}
+	// Every type and protection the validator knows is accepted by the schema, and nothing else.
+	for _, typ := range coerce.Types {
+		fixtures = append(fixtures, fixture{rules: `{"id": {"$path": "id", "$type": "` + string(typ) + `"}}`, valid: true})
+	}
+	for _, method := range privacy.Methods {
+		fixtures = append(fixtures, fixture{rules: `{"id": {"$path": "id", "$protect": "` + string(method) + `"}}`, valid: true})
+	}
+	fixtures = append(fixtures,
+		fixture{rules: `{"id": {"$path": "id", "$type": "guid"}}`},
+		fixture{rules: `{"id": {"$path": "id", "$protect": "encrypt"}}`})
+
+	for _, fixture := range fixtures {
+		_, err := rules.Parse([]byte(fixture.rules))
+		if validatorValid := err == nil; validatorValid != fixture.valid {
+			t.Errorf("Validator on %s: expected valid=%v, got %v", fixture.rules, fixture.valid, err)
+		}
+
+		var document map[string]interface{}
+		if err := json.Unmarshal([]byte(fixture.rules), &document); err != nil {
+			t.Fatalf("Invalid fixture %s: %v", fixture.rules, err)
+		}
+		violations := compiled.Validate(document)
+		if schemaValid := len(violations) == 0; schemaValid != (fixture.valid || fixture.semantic) {
+			t.Errorf("Schema on %s: expected valid=%v, got %v", fixture.rules, fixture.valid || fixture.semantic, violations)
+		}
+	}
+
+	// Null removes an inherited field, only in files extending others.
+	override := map[string]interface{}{"$extends": "base.json", "mail": nil, "sign_in_activity": map[string]interface{}{"last": nil}}
+	if violations := compiled.Validate(override); len(violations) > 0 {
+		t.Errorf("Expected null overrides to be valid with $extends, got %v", violations)
+	}
+}
+
+func TestLoad_Composition(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "base.json"), `{
+		"$lookups": {"roles": "roles.json"},
+		"id": "id",
+		"mail": "mail",
+		"mobile": "mobilePhone",
+		"sign_in_activity": {
+			"lastSignInDateTime": "signInActivity.lastSignInDateTime"
+		}
+	}`)
+	writeFile(t, filepath.Join(dir, "fragments", "names.toml"), `
+first_name = "givenName"
+last_name = "surname"
+`)
+	writeFile(t, filepath.Join(dir, "tenant.yaml"), `
+$extends: base.json
+$include: [fragments/names.toml]
+$filter: accountEnabled == ${TENANT_ENABLED:-true}
+mail: ${TENANT_MAIL_FIELD}
+mobile: null
+sign_in_activity:
+  lastSuccessfulSignInDateTime: signInActivity.lastSuccessfulSignInDateTime
+`)
+	t.Setenv("TENANT_MAIL_FIELD", "userPrincipalName")
+
+	ruleSet, err := rules.Load(filepath.Join(dir, "tenant.yaml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []string{"id", "mail", "sign_in_activity.lastSignInDateTime", "sign_in_activity.lastSuccessfulSignInDateTime", "first_name", "last_name"}
+	if targets := ruleSet.Targets(); strings.Join(targets, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected targets %v, got %v", expected, targets)
+	}
+	if ruleSet.Document["mail"] != "userPrincipalName" {
+		t.Errorf("Expected mail to be interpolated, got %v", ruleSet.Document["mail"])
+	}
+	if ruleSet.Filter[0] != "accountEnabled == true" {
+		t.Errorf("Expected the filter default to be used, got %v", ruleSet.Filter)
+	}
+	if ruleSet.Lookups["roles"].File != filepath.Join(dir, "roles.json") {
+		t.Errorf("Expected the lookup file to be relative to base.json, got %s", ruleSet.Lookups["roles"].File)
+	}
+}
+
+func TestLoad_CompositionIssues(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.json"), `{"id": "id"}`)
+	writeFile(t, filepath.Join(dir, "b.yaml"), "$include: a.json\nid: objectId\nmail: ${UNSET_RULES_VARIABLE}\n")
+	writeFile(t, filepath.Join(dir, "c.json"), `{"$include": ["a.json", "b.yaml"]}`)
+	writeFile(t, filepath.Join(dir, "loop.json"), `{"$extends": "loop.json", "id": "id"}`)
+
+	_, err := rules.Load(filepath.Join(dir, "c.json"))
+	var validationErr *rules.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	if len(validationErr.Issues) != 2 {
+		t.Fatalf("Expected 2 issues, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "b.yaml:3:7: /mail: environment variable UNSET_RULES_VARIABLE is not set") {
+		t.Errorf("Expected the unset variable to be reported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "/id: conflicting include") {
+		t.Errorf("Expected the conflicting include to be reported, got %v", err)
+	}
+
+	if _, err := rules.Load(filepath.Join(dir, "loop.json")); err == nil || !strings.Contains(err.Error(), "extends or includes itself") {
+		t.Errorf("Expected a cycle to be reported, got %v", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestRules_Encrypted(t *testing.T) {
+	ruleSet, err := rules.Parse([]byte(`{
+		"id": "id",
+		"mail": {"$path": "mail", "$encrypt": true},
+		"type": [{"$when": "userType == 'Guest'", "$value": "guest", "$encrypt": true}, "userType"],
+		"sign_in_activity": {
+			"lastSignInDateTime": "signInActivity.lastSignInDateTime",
+			"lastSignInRequestId": {"$path": "signInActivity.lastSignInRequestId", "$encrypt": true}
+		}
+	}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if got := strings.Join(ruleSet.Encrypted(), ","); got != "mail,type,sign_in_activity.lastSignInRequestId" {
+		t.Errorf("Unexpected encrypted fields: %s", got)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	builtIn, err := rules.Default()
+	if err != nil {
+		t.Fatalf("Expected built-in rules to be valid, got %v", err)
+	}
+
+	fromFile, err := rules.Load("../../configs/default_mapping_config.json")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if strings.Join(builtIn.Targets(), ",") != strings.Join(fromFile.Targets(), ",") {
+		t.Errorf("Expected built-in rules to match the default rules file")
+	}
+}
+
+func TestLoad_TOML(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.toml")
+	writeFile(t, path, `
+created = { "$value" = 2024-01-01T08:00:00Z }
+day = { "$value" = 2024-01-02 }
+
+[[type]]
+"$when" = "userType == 'Guest'"
+"$value" = "guest"
+"$colour" = "blue"
+
+[[type]]
+"$path" = "userType"
+`)
+	_, err := rules.Load(path)
+	if err == nil || !strings.Contains(err.Error(), "/type/0/$colour: unknown directive") {
+		t.Errorf("Expected the unknown directive of the alternative to be reported, got %v", err)
+	}
+
+	// Dates and times are read as the strings JSON and YAML rules hold.
+	writeFile(t, path, `
+created = { "$value" = 2024-01-01T08:00:00Z }
+day = { "$value" = 2024-01-02 }
+`)
+	ruleSet, err := rules.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	if value := ruleSet.Field("created").Value; value != "2024-01-01T08:00:00Z" {
+		t.Errorf("Expected the datetime as a string, got %#v", value)
+	}
+	if value := ruleSet.Field("day").Value; value != "2024-01-02" {
+		t.Errorf("Expected the date as a string, got %#v", value)
+	}
+
+	// Issues of lookup tables are reported in the same order on every load.
+	writeFile(t, path, `
+id = "id"
+
+["$lookups".roles]
+file = "roles.json"
+colour = "blue"
+size = "large"
+shape = "round"
+`)
+	_, first := rules.Load(path)
+	for i := 0; i < 5; i++ {
+		if _, err := rules.Load(path); first == nil || err == nil || err.Error() != first.Error() {
+			t.Fatalf("Expected the same issues on every load, got %v and %v", first, err)
+		}
+	}
+}
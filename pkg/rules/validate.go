@@ -0,0 +1,373 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"pathid_assignment/pkg/coerce"
+	"pathid_assignment/pkg/expression"
+	"pathid_assignment/pkg/lookup"
+	"pathid_assignment/pkg/privacy"
+)
+
+// pathPattern matches dot-separated paths with non-empty segments and no whitespace.
+var pathPattern = regexp.MustCompile(`^[^.\s]+(\.[^.\s]+)*$`)
+
+// fieldDirectives lists the directives allowed inside a field rule object.
+var fieldDirectives = map[string]bool{
+	WhenDirective:      true,
+	PathDirective:      true,
+	ValueDirective:     true,
+	LookupDirective:    true,
+	UnmatchedDirective: true,
+	DefaultDirective:   true,
+	TypeDirective:      true,
+	ProtectDirective:   true,
+	EncryptDirective:   true,
+}
+
+// Issue is a single problem found while validating a rules file.
+type Issue struct {
+	Pos     Position `json:"position"`
+	Pointer string   `json:"pointer"`
+	Message string   `json:"message"`
+}
+
+// String formats the issue as file:line:column: pointer: message.
+func (i Issue) String() string {
+	if pos := i.Pos.String(); pos != "" {
+		return fmt.Sprintf("%s: %s: %s", pos, i.Pointer, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.Pointer, i.Message)
+}
+
+// ValidationError lists every issue found in a rules file.
+type ValidationError struct {
+	Issues []Issue
+}
+
+// Error formats one issue per line.
+func (e *ValidationError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("invalid rules (%d issues):", len(e.Issues)))
+	for _, issue := range e.Issues {
+		sb.WriteString("\n  ")
+		sb.WriteString(issue.String())
+	}
+	return sb.String()
+}
+
+// validator collects issues while the typed model is built.
+type validator struct {
+	doc    *document
+	issues []Issue
+}
+
+func (v *validator) report(pointer, message string) {
+	v.issues = append(v.issues, Issue{Pos: v.doc.positions[pointer], Pointer: pointer, Message: message})
+}
+
+func (v *validator) err() error {
+	sort.SliceStable(v.issues, func(i, j int) bool {
+		a, b := v.issues[i].Pos, v.issues[j].Pos
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return &ValidationError{Issues: v.issues}
+}
+
+// rule validates a single rule value and converts it into a typed Rule.
+func (v *validator) rule(pointer, target string, value interface{}, lookups map[string]lookup.Spec) *Rule {
+	rule := &Rule{Target: target, Pos: v.doc.positions[pointer]}
+
+	switch val := value.(type) {
+	case string:
+		rule.Kind = KindPath
+		rule.Path = val
+		v.path(pointer, val)
+
+	case []interface{}:
+		rule.Kind = KindAlternatives
+		if len(val) == 0 {
+			v.report(pointer, "empty list of alternatives, the field is never produced")
+		}
+		unconditional := -1
+		for i, item := range val {
+			itemPointer := pointer + "/" + strconv.Itoa(i)
+			if unconditional >= 0 {
+				v.report(itemPointer, fmt.Sprintf("unreachable alternative, alternative %d has no %s condition", unconditional, WhenDirective))
+			}
+
+			object, isObject := item.(map[string]interface{})
+			if _, isList := item.([]interface{}); isList || (isObject && !isFieldRule(object)) {
+				v.report(itemPointer, "alternatives must be paths or field rules")
+				continue
+			}
+
+			alternative := v.rule(itemPointer, target, item, lookups)
+			if alternative == nil {
+				continue
+			}
+			if len(alternative.When) == 0 && unconditional < 0 {
+				unconditional = i
+			}
+			rule.Alternatives = append(rule.Alternatives, alternative)
+		}
+
+	case map[string]interface{}:
+		if isFieldRule(val) {
+			rule.Kind = KindField
+			v.fieldRule(pointer, val, rule, lookups)
+			break
+		}
+
+		rule.Kind = KindGroup
+		if len(val) == 0 {
+			v.report(pointer, "empty group, the field is never produced")
+		}
+		for _, key := range v.doc.keyOrder[pointer] {
+			if field := v.rule(pointer+"/"+escapePointer(key), key, val[key], lookups); field != nil {
+				rule.Fields = append(rule.Fields, field)
+			}
+		}
+
+	default:
+		v.report(pointer, fmt.Sprintf("unknown rule kind: expected a path, a list of alternatives or an object, got %s", kindOf(value)))
+		return nil
+	}
+
+	return rule
+}
+
+// fieldRule validates the directives of a field rule object.
+func (v *validator) fieldRule(pointer string, object map[string]interface{}, rule *Rule, lookups map[string]lookup.Spec) {
+	for _, key := range v.doc.keyOrder[pointer] {
+		keyPointer := pointer + "/" + escapePointer(key)
+		switch {
+		case !strings.HasPrefix(key, "$"):
+			v.report(keyPointer, fmt.Sprintf("target field %q cannot be mixed with directives", key))
+		case !fieldDirectives[key]:
+			v.report(keyPointer, fmt.Sprintf("unknown directive %q", key))
+		}
+	}
+
+	path, hasPath := object[PathDirective]
+	rule.Value, rule.HasValue = object[ValueDirective]
+	switch {
+	case hasPath && rule.HasValue:
+		v.report(pointer+"/"+escapePointer(PathDirective), fmt.Sprintf("unreachable %s, %s takes precedence", PathDirective, ValueDirective))
+	case hasPath:
+		if s, ok := path.(string); ok {
+			rule.Path = s
+			v.path(pointer+"/"+escapePointer(PathDirective), s)
+		} else {
+			v.report(pointer+"/"+escapePointer(PathDirective), fmt.Sprintf("invalid path: expected a string, got %s", kindOf(path)))
+		}
+	case !rule.HasValue:
+		v.report(pointer, fmt.Sprintf("field rule requires %s or %s", PathDirective, ValueDirective))
+	}
+
+	if when, exists := object[WhenDirective]; exists {
+		rule.When = v.conditions(pointer+"/"+escapePointer(WhenDirective), when)
+	}
+
+	v.lookupRule(pointer, object, rule, lookups)
+
+	if typ, exists := object[TypeDirective]; exists {
+		if s, ok := typ.(string); ok && coerce.Known(s) {
+			rule.Type = coerce.Type(s)
+		} else {
+			v.report(pointer+"/"+escapePointer(TypeDirective), fmt.Sprintf("unknown type %v, expected one of %s", typ, typeNames()))
+		}
+	}
+
+	if method, exists := object[ProtectDirective]; exists {
+		if s, ok := method.(string); ok && privacy.Known(s) {
+			rule.Protect = privacy.Method(s)
+		} else {
+			v.report(pointer+"/"+escapePointer(ProtectDirective), fmt.Sprintf("unknown protection %v, expected one of redact, mask, hmac or tokenize", method))
+		}
+	}
+
+	if encrypt, exists := object[EncryptDirective]; exists {
+		if b, ok := encrypt.(bool); ok {
+			rule.Encrypt = b
+		} else {
+			v.report(pointer+"/"+escapePointer(EncryptDirective), fmt.Sprintf("expected a boolean, got %s", kindOf(encrypt)))
+		}
+	}
+}
+
+// typeNames lists the supported field types for error messages.
+func typeNames() string {
+	names := make([]string, len(coerce.Types))
+	for i, t := range coerce.Types {
+		names[i] = string(t)
+	}
+	return strings.Join(names, ", ")
+}
+
+// lookupRule validates the lookup directives of a field rule object.
+func (v *validator) lookupRule(pointer string, object map[string]interface{}, rule *Rule, lookups map[string]lookup.Spec) {
+	if name, exists := object[LookupDirective]; exists {
+		lookupPointer := pointer + "/" + escapePointer(LookupDirective)
+		if s, ok := name.(string); !ok {
+			v.report(lookupPointer, fmt.Sprintf("expected a lookup table name, got %s", kindOf(name)))
+		} else if _, declared := lookups[s]; !declared {
+			v.report(lookupPointer, fmt.Sprintf("lookup table %q is not declared in %s", s, LookupsDirective))
+		} else {
+			rule.Lookup = s
+		}
+	}
+
+	rule.Default = object[DefaultDirective]
+	mode, hasMode := object[UnmatchedDirective]
+	if hasMode {
+		modePointer := pointer + "/" + escapePointer(UnmatchedDirective)
+		rule.Unmatched, _ = mode.(string)
+		switch {
+		case rule.Unmatched != lookup.UnmatchedKeep && rule.Unmatched != lookup.UnmatchedDefault && rule.Unmatched != lookup.UnmatchedFail:
+			v.report(modePointer, fmt.Sprintf("unknown %s mode %v, expected keep, default or fail", UnmatchedDirective, mode))
+		case object[LookupDirective] == nil:
+			v.report(modePointer, fmt.Sprintf("unreachable %s without %s", UnmatchedDirective, LookupDirective))
+		}
+	}
+
+	if _, hasDefault := object[DefaultDirective]; hasDefault && rule.Unmatched != lookup.UnmatchedDefault {
+		v.report(pointer+"/"+escapePointer(DefaultDirective), fmt.Sprintf("unreachable %s, it is only used with %s \"default\"", DefaultDirective, UnmatchedDirective))
+	}
+}
+
+// conditions validates a condition or list of conditions and returns them.
+func (v *validator) conditions(pointer string, value interface{}) []string {
+	var conditions []string
+	switch val := value.(type) {
+	case string:
+		v.expression(pointer, val)
+		conditions = append(conditions, val)
+	case []interface{}:
+		for i, item := range val {
+			itemPointer := pointer + "/" + strconv.Itoa(i)
+			s, ok := item.(string)
+			if !ok {
+				v.report(itemPointer, fmt.Sprintf("expected a condition, got %s", kindOf(item)))
+				continue
+			}
+			v.expression(itemPointer, s)
+			conditions = append(conditions, s)
+		}
+	default:
+		v.report(pointer, fmt.Sprintf("expected a condition or a list of conditions, got %s", kindOf(value)))
+	}
+	return conditions
+}
+
+func (v *validator) expression(pointer, source string) {
+	expr, err := expression.Parse(source)
+	if err != nil {
+		v.report(pointer, err.Error())
+		return
+	}
+	for _, path := range expr.Paths() {
+		v.path(pointer, path)
+	}
+}
+
+// lookups validates the "$lookups" declarations.
+func (v *validator) lookups(pointer string, value interface{}) map[string]lookup.Spec {
+	specs := make(map[string]lookup.Spec)
+	declarations, ok := value.(map[string]interface{})
+	if !ok {
+		v.report(pointer, fmt.Sprintf("expected an object of lookup tables, got %s", kindOf(value)))
+		return specs
+	}
+
+	for _, name := range v.doc.keyOrder[pointer] {
+		namePointer := pointer + "/" + escapePointer(name)
+		switch d := declarations[name].(type) {
+		case string:
+			specs[name] = lookup.Spec{File: d}
+		case map[string]interface{}:
+			spec := lookup.Spec{}
+			keys := make([]string, 0, len(d))
+			for key := range d {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				field := d[key]
+				s, isString := field.(string)
+				switch {
+				case key != "file" && key != "key" && key != "value":
+					v.report(namePointer+"/"+escapePointer(key), fmt.Sprintf("unknown lookup property %q", key))
+				case !isString:
+					v.report(namePointer+"/"+escapePointer(key), fmt.Sprintf("expected a string, got %s", kindOf(field)))
+				case key == "file":
+					spec.File = s
+				case key == "key":
+					spec.Key = s
+				default:
+					spec.Value = s
+				}
+			}
+			if spec.File == "" {
+				v.report(namePointer, "lookup table requires a file")
+			}
+			specs[name] = spec
+		default:
+			v.report(namePointer, fmt.Sprintf("expected a file path or an object, got %s", kindOf(d)))
+		}
+	}
+	return specs
+}
+
+// privacy validates the "$privacy" declaration: an object naming either a key variable or a key file.
+func (v *validator) privacy(pointer string, value interface{}) privacy.KeySpec {
+	var spec privacy.KeySpec
+	declaration, ok := value.(map[string]interface{})
+	if !ok {
+		v.report(pointer, fmt.Sprintf("expected an object with key_env or key_file, got %s", kindOf(value)))
+		return spec
+	}
+
+	for _, key := range v.doc.keyOrder[pointer] {
+		keyPointer := pointer + "/" + escapePointer(key)
+		s, isString := declaration[key].(string)
+		switch {
+		case key != "key_env" && key != "key_file":
+			v.report(keyPointer, fmt.Sprintf("unknown privacy property %q", key))
+		case !isString || s == "":
+			v.report(keyPointer, fmt.Sprintf("expected a non-empty string, got %s", kindOf(declaration[key])))
+		case key == "key_env":
+			spec.Env = s
+		default:
+			spec.File = s
+		}
+	}
+	if spec.Env != "" && spec.File != "" {
+		v.report(pointer, "privacy key requires either key_env or key_file, not both")
+	}
+	return spec
+}
+
+// path reports malformed dot-separated paths.
+func (v *validator) path(pointer, path string) {
+	if !pathPattern.MatchString(path) {
+		v.report(pointer, fmt.Sprintf("invalid path %q", path))
+	}
+}
+
+// isFieldRule reports whether a rule object is a single field rule, i.e. it holds at least one directive.
+func isFieldRule(object map[string]interface{}) bool {
+	for key := range object {
+		if strings.HasPrefix(key, "$") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"pathid_assignment/pkg/coerce"
+)
+
+// FormatTag is the struct tag holding the JSON Schema format of a field for Generate, e.g. `format:"uuid"`.
+const FormatTag = "format"
+
+// upnPattern matches user principal names: a local part, "@" and a dotted domain.
+var upnPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s.]+$`)
+
+// formats are the formats asserted on top of the standard ones. Values must already be in the
+// normalized form produced by the matching "$type", e.g. upper-case country codes.
+var formats = map[string]func(interface{}) bool{
+	"upn":                      isUPN,
+	string(coerce.CountryCode): normalized(coerce.CountryCode),
+}
+
+// Violation is a single constraint a record does not satisfy.
+type Violation struct {
+	Field   string `json:"field"` // JSON pointer to the offending value, e.g. "/sign_in_activity/lastSignInDateTime".
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	if v.Field == "" {
+		return v.Message
+	}
+	return fmt.Sprintf("%s: %s", v.Field, v.Message)
+}
+
+// Schema validates transformed records against a compiled JSON Schema.
+// It holds no mutable state and is safe for concurrent use.
+type Schema struct {
+	compiled *jsonschema.Schema
+}
+
+// Compile compiles a JSON Schema document. Formats are asserted, including the "upn" and
+// "country_code" formats in addition to the standard ones.
+func Compile(data []byte) (*Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	for name, check := range formats {
+		compiler.Formats[name] = check
+	}
+
+	const url = "schema.json"
+	if err := compiler.AddResource(url, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	compiled, err := compiler.Compile(url)
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{compiled: compiled}, nil
+}
+
+// Load reads and compiles a JSON Schema file.
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema file: %w", err)
+	}
+
+	s, err := Compile(data)
+	if err != nil {
+		return nil, fmt.Errorf("compiling schema file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// For compiles the schema generated from the fields of T, see Generate.
+func For[T any]() (*Schema, error) {
+	data, err := Generate(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+	return Compile(data)
+}
+
+// Validate returns every violation of the schema by the record, ordered by field.
+func (s *Schema) Validate(record map[string]interface{}) []Violation {
+	err := s.compiled.Validate(record)
+	if err == nil {
+		return nil
+	}
+
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []Violation{{Message: err.Error()}}
+	}
+
+	var violations []Violation
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			violations = append(violations, Violation{Field: e.InstanceLocation, Message: e.Message})
+			return
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(validationErr)
+
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Field < violations[j].Field })
+	return violations
+}
+
+// Generate builds a JSON Schema from a struct type. Fields are named by their json tag, and are required
+// unless tagged omitempty. Pointer fields are nullable, and the `format` tag sets a field's format.
+func Generate(t reflect.Type) ([]byte, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot generate a schema from %s, expected a struct", t)
+	}
+
+	document := typeSchema(t, "")
+	document["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	document["title"] = t.Name()
+	return json.MarshalIndent(document, "", "  ")
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// typeSchema returns the schema of a Go type in the JSON value model.
+func typeSchema(t reflect.Type, format string) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		s := typeSchema(t.Elem(), format)
+		if typ, ok := s["type"].(string); ok {
+			s["type"] = []string{typ, "null"}
+		}
+		return s
+	}
+
+	s := make(map[string]interface{})
+	if format != "" {
+		s["format"] = format
+	}
+
+	switch {
+	case t == timeType:
+		s["type"] = "string"
+		s["format"] = "date-time"
+	case t.Kind() == reflect.String:
+		s["type"] = "string"
+	case t.Kind() == reflect.Bool:
+		s["type"] = "boolean"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		s["type"] = "integer"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		s["type"] = "number"
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		s["type"] = "array"
+		s["items"] = typeSchema(t.Elem(), "")
+	case t.Kind() == reflect.Map:
+		s["type"] = "object"
+		s["additionalProperties"] = typeSchema(t.Elem(), "")
+	case t.Kind() == reflect.Struct:
+		properties := make(map[string]interface{})
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+
+			properties[name] = typeSchema(field.Type, field.Tag.Get(FormatTag))
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s["type"] = "object"
+		s["properties"] = properties
+		s["required"] = required
+	}
+	return s
+}
+
+func isUPN(v interface{}) bool {
+	s, ok := v.(string)
+	return !ok || upnPattern.MatchString(s)
+}
+
+// normalized returns a format check accepting values that coerce to themselves.
+func normalized(t coerce.Type) func(interface{}) bool {
+	return func(v interface{}) bool {
+		s, ok := v.(string)
+		if !ok {
+			return true // Formats only apply to strings.
+		}
+		coerced, err := coerce.Coerce(t, s)
+		return err == nil && coerced == s
+	}
+}
@@ -0,0 +1,70 @@
+package schema_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"pathid_assignment/pkg/models"
+	"pathid_assignment/pkg/schema"
+)
+
+func TestFor_DefaultStructure(t *testing.T) {
+	s, err := schema.For[models.DefaultStructure]()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	valid := map[string]interface{}{
+		"id":          "0e685562-4a32-4728-a7ec-4d288ed7d3d4",
+		"external_id": "user@example.onmicrosoft.com",
+		"mail":        nil,
+		"type":        "Member",
+		"location":    "US",
+		"is_enabled":  true,
+		"first_name":  "John",
+		"last_name":   "Doe",
+	}
+	if violations := s.Validate(valid); len(violations) != 0 {
+		t.Errorf("Expected no violations, got %v", violations)
+	}
+
+	invalid := map[string]interface{}{
+		"id":          "123",
+		"external_id": "user",
+		"mail":        nil,
+		"type":        "Member",
+		"location":    "us",
+		"is_enabled":  "true",
+		"first_name":  "John",
+	}
+	expected := []string{"", "/external_id", "/id", "/is_enabled", "/location"}
+	violations := s.Validate(invalid)
+	if len(violations) != len(expected) {
+		t.Fatalf("Expected %d violations, got %v", len(expected), violations)
+	}
+	for i, field := range expected {
+		if violations[i].Field != field {
+			t.Errorf("Violation %d: expected field %q, got %q", i, field, violations[i])
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(`{"type": "object", "properties": {"id": {"type": "string", "format": "uuid"}}}`), 0644); err != nil {
+		t.Fatalf("Failed to write schema file: %v", err)
+	}
+
+	s, err := schema.Load(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if violations := s.Validate(map[string]interface{}{"id": "123"}); len(violations) != 1 {
+		t.Errorf("Expected 1 violation, got %v", violations)
+	}
+
+	if _, err := schema.Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Expected an error for a missing schema file")
+	}
+}
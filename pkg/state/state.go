@@ -0,0 +1,156 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Version is the current format version of state files.
+const Version = 1
+
+// Op is the kind of change a record went through since the previous run.
+type Op string
+
+const (
+	// Added records were not in the previous run.
+	Added Op = "added"
+	// Changed records differ from the previous run.
+	Changed Op = "changed"
+	// Deleted records were in the previous run but are gone.
+	Deleted Op = "deleted"
+)
+
+// Change is a record that was added, changed or deleted since the previous run.
+// Deleted records only carry their id.
+type Change struct {
+	ID     string
+	Op     Op
+	Record map[string]interface{}
+}
+
+// State remembers the records of the previous run by id, as hashes of their transformed content.
+type State struct {
+	Version   int               `json:"version"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	DeltaLink string            `json:"delta_link,omitempty"` // Link to request the next delta from, as last received.
+	Records   map[string]string `json:"records"`
+}
+
+// Load reads a state file. A missing file is an empty state, as on the first incremental run.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{Version: Version, Records: make(map[string]string)}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading state file: %w", err)
+	}
+
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+	if s.Version != Version {
+		return nil, fmt.Errorf("state file %s has version %d, expected %d", path, s.Version, Version)
+	}
+	if s.Records == nil {
+		s.Records = make(map[string]string)
+	}
+	return &s, nil
+}
+
+// Save writes the state file atomically, so an interrupted run leaves the previous state in place.
+func (s *State) Save(path string) error {
+	s.Version = Version
+	s.UpdatedAt = time.Now().UTC()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Hash returns a digest of a record's content. Object keys are sorted by encoding/json,
+// so equal records have equal hashes regardless of field order.
+func Hash(record map[string]interface{}) string {
+	data, _ := json.Marshal(record)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Apply compares records with the state, updates the state and returns the changes, added and changed
+// records first in input order, then deleted ids in sorted order. A full snapshot deletes every
+// known id it does not hold; a delta only deletes the removed ids it lists. Skipped ids were read but
+// not transformed, e.g. failed or rejected, and are kept as they were.
+func (s *State) Apply(records []map[string]interface{}, removed []string, snapshot bool, skipped []string) (changes []Change, unchanged int) {
+	seen := make(map[string]bool, len(records)+len(skipped))
+	for _, key := range skipped {
+		seen[key] = true
+	}
+	for _, record := range records {
+		id, exists := record["id"]
+		if !exists || id == nil {
+			continue
+		}
+		key := fmt.Sprint(id)
+		seen[key] = true
+
+		hash := Hash(record)
+		previous, known := s.Records[key]
+		s.Records[key] = hash
+		switch {
+		case !known:
+			changes = append(changes, Change{ID: key, Op: Added, Record: record})
+		case previous != hash:
+			changes = append(changes, Change{ID: key, Op: Changed, Record: record})
+		default:
+			unchanged++
+		}
+	}
+
+	var deleted []string
+	if snapshot {
+		for key := range s.Records {
+			if !seen[key] {
+				deleted = append(deleted, key)
+			}
+		}
+	}
+	for _, key := range removed {
+		if _, known := s.Records[key]; known && !seen[key] {
+			deleted = append(deleted, key)
+		}
+	}
+
+	sort.Strings(deleted)
+	for i, key := range deleted {
+		if i > 0 && deleted[i-1] == key {
+			continue
+		}
+		delete(s.Records, key)
+		changes = append(changes, Change{ID: key, Op: Deleted})
+	}
+	return changes, unchanged
+}
@@ -0,0 +1,77 @@
+package state_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"pathid_assignment/pkg/state"
+)
+
+func TestState_Apply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, err := state.Load(path)
+	if err != nil {
+		t.Fatalf("Expected an empty state for a missing file, got %v", err)
+	}
+
+	first := []map[string]interface{}{
+		{"id": "1", "first_name": "Ann"},
+		{"id": "2", "first_name": "Bob"},
+		{"id": "3", "first_name": "Eve"},
+	}
+	changes, unchanged := s.Apply(first, nil, true, nil)
+	if len(changes) != 3 || unchanged != 0 || changes[0].Op != state.Added {
+		t.Fatalf("Expected 3 added records, got %+v", changes)
+	}
+	if err := s.Save(path); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	s, err = state.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+
+	// A snapshot deletes the users it no longer holds.
+	second := []map[string]interface{}{
+		{"first_name": "Ann", "id": "1"},
+		{"id": "2", "first_name": "Robert"},
+		{"id": "4", "first_name": "Dan"},
+	}
+	changes, unchanged = s.Apply(second, nil, true, nil)
+	expected := []struct {
+		id string
+		op state.Op
+	}{{"2", state.Changed}, {"4", state.Added}, {"3", state.Deleted}}
+	if len(changes) != len(expected) || unchanged != 1 {
+		t.Fatalf("Expected %d changes and 1 unchanged record, got %+v and %d", len(expected), changes, unchanged)
+	}
+	for i, change := range expected {
+		if changes[i].ID != change.id || changes[i].Op != change.op {
+			t.Errorf("Change %d: expected %s %s, got %s %s", i, change.op, change.id, changes[i].Op, changes[i].ID)
+		}
+	}
+
+	// A delta only deletes the users it lists as removed.
+	changes, unchanged = s.Apply([]map[string]interface{}{{"id": "1", "first_name": "Anne"}}, []string{"4"}, false, nil)
+	if len(changes) != 2 || unchanged != 0 || changes[1].ID != "4" || changes[1].Op != state.Deleted {
+		t.Errorf("Expected user 1 changed and user 4 deleted, got %+v", changes)
+	}
+	if len(s.Records) != 2 {
+		t.Errorf("Expected users 1 and 2 to remain, got %v", s.Records)
+	}
+	// Users read but not transformed are not deleted by a snapshot, and keep their state.
+	hash := s.Records["2"]
+	changes, _ = s.Apply([]map[string]interface{}{{"id": "1", "first_name": "Anne"}}, nil, true, []string{"2"})
+	if len(changes) != 0 || s.Records["2"] != hash {
+		t.Errorf("Expected skipped user 2 to be kept, got %+v and %v", changes, s.Records)
+	}
+}
+
+func TestHash(t *testing.T) {
+	a := state.Hash(map[string]interface{}{"id": "1", "sign_in_activity": map[string]interface{}{"a": 1.0, "b": 2.0}})
+	b := state.Hash(map[string]interface{}{"sign_in_activity": map[string]interface{}{"b": 2.0, "a": 1.0}, "id": "1"})
+	if a != b {
+		t.Errorf("Expected equal records to have equal hashes")
+	}
+}
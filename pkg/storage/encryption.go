@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"pathid_assignment/pkg/analytics"
+	"pathid_assignment/pkg/envelope"
+	"pathid_assignment/pkg/merge"
+)
+
+// Encryption encrypts selected fields of the stored records with the data key of a Sealer.
+// The envelope holding the wrapped data key is stored in encryption.json, next to the records.
+type Encryption struct {
+	Sealer *envelope.Sealer
+	Fields []string // Dot-separated target paths, e.g. "sign_in_activity.lastSignInRequestId".
+}
+
+// SaveEnvelope stores the envelope needed to decrypt the encrypted fields into given file path.
+func (s *Storage) SaveEnvelope(envelopeFilePath string) error {
+	if s.Encryption == nil {
+		return nil
+	}
+
+	return s.save(envelopeFilePath, "encryption", s.Encryption.Sealer.Envelope(), 0600)
+}
+
+// encrypts reports whether a field is encrypted.
+func (e *Encryption) encrypts(field string) bool {
+	for _, encrypted := range e.Fields {
+		if encrypted == field {
+			return true
+		}
+	}
+	return false
+}
+
+// records returns copies of the records with their encrypted fields sealed. Without encryption,
+// the records are returned as they are.
+func (e *Encryption) records(records []map[string]interface{}) ([]map[string]interface{}, error) {
+	if e == nil {
+		return records, nil
+	}
+
+	sealed := make([]map[string]interface{}, len(records))
+	for i, record := range records {
+		copied, err := e.record(record)
+		if err != nil {
+			return nil, err
+		}
+		sealed[i] = copied
+	}
+	return sealed, nil
+}
+
+// record returns a copy of a record with its encrypted fields sealed. Objects along the paths are copied,
+// so the caller's record is left untouched. Null values stay null.
+func (e *Encryption) record(record map[string]interface{}) (map[string]interface{}, error) {
+	copied := shallowCopy(record)
+	for _, field := range e.Fields {
+		keys := strings.Split(field, ".")
+
+		current := copied
+		for _, key := range keys[:len(keys)-1] {
+			nested, ok := current[key].(map[string]interface{})
+			if !ok {
+				current = nil
+				break
+			}
+			current[key] = shallowCopy(nested)
+			current = current[key].(map[string]interface{})
+		}
+
+		last := keys[len(keys)-1]
+		if current == nil || current[last] == nil {
+			continue
+		}
+		value, err := e.Sealer.Seal(current[last])
+		if err != nil {
+			return nil, fmt.Errorf("encrypting field %s: %w", field, err)
+		}
+		current[last] = value
+	}
+	return copied, nil
+}
+
+// value seals a single value of a field, when the field is encrypted.
+func (e *Encryption) value(field string, value interface{}) (interface{}, error) {
+	if e == nil || value == nil || !e.encrypts(field) {
+		return value, nil
+	}
+	return e.Sealer.Seal(value)
+}
+
+// rejects seals the encrypted fields of the records held by reject entries.
+func (e *Encryption) rejects(rejects []map[string]interface{}) ([]map[string]interface{}, error) {
+	if e == nil {
+		return rejects, nil
+	}
+
+	sealed := make([]map[string]interface{}, len(rejects))
+	for i, reject := range rejects {
+		sealed[i] = shallowCopy(reject)
+		if record, ok := reject["record"].(map[string]interface{}); ok {
+			copied, err := e.record(record)
+			if err != nil {
+				return nil, err
+			}
+			sealed[i]["record"] = copied
+		}
+	}
+	return sealed, nil
+}
+
+// conflicts seals the values of conflicts on encrypted fields, and the keys when ids are encrypted.
+func (e *Encryption) conflicts(conflicts []merge.Conflict) ([]merge.Conflict, error) {
+	if e == nil {
+		return conflicts, nil
+	}
+
+	sealed := make([]merge.Conflict, len(conflicts))
+	for i, conflict := range conflicts {
+		key, err := e.value("id", conflict.Key)
+		if err != nil {
+			return nil, err
+		}
+		conflict.Key = key.(string)
+
+		if conflict.Kept, err = e.value(conflict.Field, conflict.Kept); err != nil {
+			return nil, err
+		}
+		values := make([]merge.Value, len(conflict.Values))
+		for j, value := range conflict.Values {
+			if value.Value, err = e.value(conflict.Field, value.Value); err != nil {
+				return nil, err
+			}
+			values[j] = value
+		}
+		conflict.Values = values
+		sealed[i] = conflict
+	}
+	return sealed, nil
+}
+
+// staleAccounts seals the fields of stale accounts copied from encrypted user fields, read at the given
+// paths. Display names are sealed when either name they are built from is encrypted.
+func (e *Encryption) staleAccounts(accounts []analytics.StaleAccount, fields analytics.Fields) ([]analytics.StaleAccount, error) {
+	if e == nil {
+		return accounts, nil
+	}
+
+	fields = fields.WithDefaults()
+	sealed := make([]analytics.StaleAccount, len(accounts))
+	for i, account := range accounts {
+		var err error
+		if account.ID, err = e.value(fields.ID, account.ID); err != nil {
+			return nil, err
+		}
+		if account.ExternalID, err = e.value(fields.ExternalID, account.ExternalID); err != nil {
+			return nil, err
+		}
+		if account.Mail, err = e.value(fields.Mail, account.Mail); err != nil {
+			return nil, err
+		}
+		if account.DisplayName != "" && (e.encrypts(fields.FirstName) || e.encrypts(fields.LastName)) {
+			if account.DisplayName, err = e.Sealer.Seal(account.DisplayName); err != nil {
+				return nil, err
+			}
+		}
+		sealed[i] = account
+	}
+	return sealed, nil
+}
+
+// signInAnalytics seals the ids of the sign-in analytics of users, when ids are encrypted.
+func (e *Encryption) signInAnalytics(users []analytics.UserAnalytics, fields analytics.Fields) ([]analytics.UserAnalytics, error) {
+	if e == nil {
+		return users, nil
+	}
+
+	fields = fields.WithDefaults()
+	sealed := make([]analytics.UserAnalytics, len(users))
+	for i, user := range users {
+		var err error
+		if user.ID, err = e.value(fields.ID, user.ID); err != nil {
+			return nil, err
+		}
+		sealed[i] = user
+	}
+	return sealed, nil
+}
+
+func shallowCopy(record map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(record))
+	for key, value := range record {
+		copied[key] = value
+	}
+	return copied
+}
+
+// DecryptDirectory decrypts the stored files of an output directory into another directory, using the
+// envelope in encryption.json. It returns the number of values decrypted.
+func DecryptDirectory(kms envelope.KMS, inputDir, outputDir string) (int, error) {
+	envelopePath := GenerateFilePath(inputDir, "encryption")
+	data, err := os.ReadFile(envelopePath)
+	if err != nil {
+		return 0, fmt.Errorf("reading encryption envelope: %w", err)
+	}
+	var env envelope.Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return 0, fmt.Errorf("parsing encryption envelope %s: %w", envelopePath, err)
+	}
+	opener, err := envelope.NewOpener(kms, env)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return 0, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(inputDir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	decrypted := 0
+	for _, file := range files {
+		if file == envelopePath {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return decrypted, err
+		}
+		var document interface{}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return decrypted, fmt.Errorf("parsing %s: %w", file, err)
+		}
+
+		decrypted += countSealed(document)
+		if document, err = opener.OpenAll(document); err != nil {
+			return decrypted, fmt.Errorf("decrypting %s: %w", file, err)
+		}
+		if data, err = json.MarshalIndent(document, "", "  "); err != nil {
+			return decrypted, err
+		}
+		if err := os.WriteFile(filepath.Join(outputDir, filepath.Base(file)), data, 0600); err != nil {
+			return decrypted, err
+		}
+	}
+	return decrypted, nil
+}
+
+// countSealed counts the encrypted values of a decoded JSON document.
+func countSealed(document interface{}) int {
+	switch v := document.(type) {
+	case []interface{}:
+		count := 0
+		for _, item := range v {
+			count += countSealed(item)
+		}
+		return count
+	case map[string]interface{}:
+		count := 0
+		for _, item := range v {
+			count += countSealed(item)
+		}
+		return count
+	}
+	if envelope.IsSealed(document) {
+		return 1
+	}
+	return 0
+}
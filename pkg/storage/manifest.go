@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Manifest lists the files a run wrote into its output directory, so later runs only ever delete those.
+type Manifest struct {
+	CreatedAt time.Time `json:"created_at"`
+	Files     []string  `json:"files"` // File names, relative to the output directory.
+}
+
+// Files returns the names of the files written so far, sorted.
+func (s *Storage) Files() []string {
+	s.filesMutex.Lock()
+	defer s.filesMutex.Unlock()
+
+	files := append([]string(nil), s.files...)
+	sort.Strings(files)
+	return files
+}
+
+// SaveManifest stores the manifest of the files written so far into given directory. The files listed by
+// the manifest of an earlier run written into the same directory are kept in it while they exist, so that
+// a later clean still deletes them. Nothing is stored when streaming.
+func (s *Storage) SaveManifest(manifestFilePath string) error {
+	if s.Stream != nil {
+		return nil
+	}
+
+	files := s.Files()
+	previous, err := LoadManifest(manifestFilePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if previous != nil {
+		written := make(map[string]bool, len(files))
+		for _, file := range files {
+			written[file] = true
+		}
+		for _, file := range previous.Files {
+			file = filepath.Base(file)
+			if _, err := os.Stat(filepath.Join(manifestFilePath, file)); err == nil && !written[file] {
+				files = append(files, file)
+				written[file] = true
+			}
+		}
+		sort.Strings(files)
+	}
+	manifest := Manifest{CreatedAt: time.Now().UTC(), Files: files}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(GenerateFilePath(manifestFilePath, "manifest"), data, 0644)
+}
+
+// LoadManifest reads the manifest of an output directory. The error wraps os.ErrNotExist when the
+// directory holds no manifest.
+func LoadManifest(dir string) (*Manifest, error) {
+	path := GenerateFilePath(dir, "manifest")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+// CleanDirectory deletes the files listed in the manifest of an output directory, and the manifest itself.
+// Files the manifest does not list were not written by a run and are kept; their names are returned.
+func CleanDirectory(dir string) ([]string, error) {
+	manifest, err := LoadManifest(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if manifest != nil {
+		// Only names are trusted from the manifest, so a tampered one can't delete outside the directory.
+		for _, file := range manifest.Files {
+			if err := os.Remove(filepath.Join(dir, filepath.Base(file))); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+		if err := os.Remove(GenerateFilePath(dir, "manifest")); err != nil {
+			return nil, err
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	kept := make([]string, len(entries))
+	for i, entry := range entries {
+		kept[i] = entry.Name()
+	}
+	return kept, nil
+}
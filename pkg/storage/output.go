@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SignIn is a sign-in activity entry, as stored in signin.json.
+type SignIn struct {
+	UserID    string `json:"userId"`
+	Type      string `json:"type"`
+	TimeStamp string `json:"timeStamp"`
+	RequestID string `json:"requestId"`
+}
+
+// Output is what a run stored: its users and sign-in activities.
+type Output struct {
+	Users   []map[string]interface{}
+	SignIns []SignIn
+}
+
+// LoadOutput reads the users.json and signin.json files of an output directory.
+// A missing signin.json is read as no sign-ins.
+func LoadOutput(dir string) (*Output, error) {
+	output := &Output{}
+	if err := readJSON(GenerateFilePath(dir, "users"), &output.Users); err != nil {
+		return nil, err
+	}
+
+	signInPath := GenerateFilePath(dir, "signInActivity")
+	if _, err := os.Stat(signInPath); err == nil {
+		if err := readJSON(signInPath, &output.SignIns); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
+}
+
+func readJSON(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading output file: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing output file %s: %w", path, err)
+	}
+	return nil
+}
@@ -2,16 +2,45 @@ package storage
 
 import (
 	"encoding/json"
-	"log"
+	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
+
+	"pathid_assignment/pkg/analytics"
+	"pathid_assignment/pkg/merge"
+	"pathid_assignment/pkg/utils"
 )
 
 // Storage holds mutexes for thread-safe access to file operations for users and sign-in activities.
 type Storage struct {
 	userMutex   sync.Mutex
 	signInMutex sync.Mutex
+	rejectMutex sync.Mutex
+	mergeMutex  sync.Mutex
+	staleMutex  sync.Mutex
+	filesMutex  sync.Mutex
+	streamMutex sync.Mutex
+
+	files []string // Names of the files written, recorded in the manifest.
+
+	// Encryption, when set, encrypts the configured fields of every stored record.
+	Encryption *Encryption
+	// Logger receives warnings about records that cannot be stored; nothing is logged when nil.
+	Logger *slog.Logger
+	// Stream, when set, receives the stored entries as they are written, one JSON line per record, instead
+	// of the files of the output directory. See StreamLine.
+	Stream io.Writer
+}
+
+// StreamLine is a line of the stream: an entry of a file, such as a user of users.json, with the stream key
+// of the file, such as "users".
+type StreamLine struct {
+	File   string      `json:"file"`
+	Record interface{} `json:"record"`
 }
 
 // NewStorage initializes a Storage instance with file paths.
@@ -24,12 +53,12 @@ func (s *Storage) SaveUsers(users []map[string]interface{}, usersFilePath string
 	s.userMutex.Lock()
 	defer s.userMutex.Unlock()
 
-	data, err := json.MarshalIndent(users, "", "  ")
+	users, err := s.Encryption.records(users)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(GenerateFilePath(usersFilePath, "users"), data, 0644)
+	return s.save(usersFilePath, "users", users, 0644)
 }
 
 // SaveSignInActivities serializes sign-in activities into JSON format and writes it to a file.
@@ -38,36 +67,34 @@ func (s *Storage) SaveSignInActivities(activities []map[string]interface{}, sign
 	s.signInMutex.Lock()
 	defer s.signInMutex.Unlock()
 
+	// Sign-in fields are encrypted by their path in the user, before they are restructured.
+	activities, err := s.Encryption.records(activities)
+	if err != nil {
+		return err
+	}
+
 	var structuredData []map[string]interface{}
 
 	for _, activity := range activities {
 		// Ensure the activity has an ID
 		userID, userExists := activity["id"].(string)
 		if !userExists {
-			log.Println("Skipping sign-in activity due to missing user ID")
-			return nil
+			utils.LoggerOrDiscard(s.Logger).Warn("skipping the sign-in activities of a user without id")
+			continue
 		}
 
 		// Convert sign_in_activity to map[string]string
 		signInActivity, ok := activity["sign_in_activity"]
 		if !ok {
-			log.Printf("Unexpected sign-in activity format for user %s: %+v", userID, signInActivity)
-			return nil
+			utils.LoggerOrDiscard(s.Logger).Warn("skipping a user without sign-in activities", "user_id", userID)
+			continue
 		}
 
 		// Assert that signInActivity is a map.
 		signInMap, ok := signInActivity.(map[string]interface{})
 		if !ok {
-			log.Printf("Unexpected sign-in activity format for user %s: %+v", userID, signInActivity)
-			return nil
-		}
-
-		// Define mappings between timetamp and the corresponding requestId key.
-		// That is because 'userID' has already been discovered and 'type' will be discovered after crossing keys by the mentioned two keys and signInMap.
-		signInMappings := map[string]string{
-			"lastSignInDateTime":               "lastSignInRequestId",
-			"lastNonInteractiveSignInDateTime": "lastNonInteractiveSignInRequestId",
-			"lastSuccessfulSignInDateTime":     "lastSuccessfulSignInRequestId",
+			utils.LoggerOrDiscard(s.Logger).Warn("skipping the sign-in activities of a user, unexpected format", "user_id", userID, "sign_in_activity", signInActivity)
+			continue
 		}
 
 		// Iterate over each mapping to extract valid timestamp and request ID pairs.
@@ -86,22 +113,174 @@ func (s *Storage) SaveSignInActivities(activities []map[string]interface{}, sign
 		}
 	}
 
-	data, err := json.MarshalIndent(structuredData, "", "  ")
+	return s.save(signInFilePath, "signInActivity", structuredData, 0644)
+}
+
+// RestoreSignInActivities puts sign-in activities read from signin.json back into the users they were split
+// from, as their "sign_in_activity" field, the way users are transformed. Users without any are left unchanged.
+func RestoreSignInActivities(users, activities []map[string]interface{}) {
+	byID := make(map[string]map[string]interface{}, len(users))
+	for _, user := range users {
+		if id, ok := user["id"].(string); ok {
+			byID[id] = user
+		}
+	}
+
+	for _, activity := range activities {
+		userID, _ := activity["userId"].(string)
+		signInType, _ := activity["type"].(string)
+		requestIDKey, known := signInMappings[signInType]
+		user, exists := byID[userID]
+		if !known || !exists {
+			continue
+		}
+		signInMap, ok := user["sign_in_activity"].(map[string]interface{})
+		if !ok {
+			signInMap = make(map[string]interface{})
+			user["sign_in_activity"] = signInMap
+		}
+		signInMap[signInType] = activity["timeStamp"]
+		signInMap[requestIDKey] = activity["requestId"]
+	}
+}
+
+// signInMappings maps the timestamp of every kind of sign-in to the key of its request ID.
+var signInMappings = map[string]string{
+	"lastSignInDateTime":               "lastSignInRequestId",
+	"lastNonInteractiveSignInDateTime": "lastNonInteractiveSignInRequestId",
+	"lastSuccessfulSignInDateTime":     "lastSuccessfulSignInRequestId",
+}
+
+// SaveRejects stores records rejected by schema validation, each with its violations, into given file path.
+func (s *Storage) SaveRejects(rejects []map[string]interface{}, rejectsFilePath string) error {
+	s.rejectMutex.Lock()
+	defer s.rejectMutex.Unlock()
+
+	rejects, err := s.Encryption.rejects(rejects)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(GenerateFilePath(signInFilePath, "signInActivity"), data, 0644)
+	return s.save(rejectsFilePath, "rejects", rejects, 0644)
 }
 
-// GenerateFilePath constructs a valid file path by combining a base directory with predefined file names.
-func GenerateFilePath(baseDir, fileType string) string {
-	// Define hardcoded file names based on type
-	fileNames := map[string]string{
-		"users":          "users.json",
-		"signInActivity": "signin.json",
+// SaveConflicts stores the conflicting field values found while merging duplicate records into given file path.
+func (s *Storage) SaveConflicts(conflicts []merge.Conflict, conflictsFilePath string) error {
+	s.mergeMutex.Lock()
+	defer s.mergeMutex.Unlock()
+
+	conflicts, err := s.Encryption.conflicts(conflicts)
+	if err != nil {
+		return err
+	}
+
+	return s.save(conflictsFilePath, "conflicts", conflicts, 0644)
+}
+
+// SaveStaleAccounts stores the report of enabled but stale accounts into given file path. Fields are the
+// user fields the accounts were read from, telling which ones to seal.
+func (s *Storage) SaveStaleAccounts(accounts []analytics.StaleAccount, fields analytics.Fields, staleFilePath string) error {
+	s.staleMutex.Lock()
+	defer s.staleMutex.Unlock()
+
+	accounts, err := s.Encryption.staleAccounts(accounts, fields)
+	if err != nil {
+		return err
 	}
 
+	return s.save(staleFilePath, "staleAccounts", accounts, 0644)
+}
+
+// SaveSignInAnalytics stores the sign-in analytics of every user into given file path. Fields are the
+// user fields the analytics were read from, telling whether to seal ids.
+func (s *Storage) SaveSignInAnalytics(users []analytics.UserAnalytics, fields analytics.Fields, analyticsFilePath string) error {
+	s.staleMutex.Lock()
+	defer s.staleMutex.Unlock()
+
+	users, err := s.Encryption.signInAnalytics(users, fields)
+	if err != nil {
+		return err
+	}
+
+	return s.save(analyticsFilePath, "signInAnalytics", users, 0644)
+}
+
+// save writes the entries of a file type, a slice or a single value, into its file in a directory, or to
+// the stream when streaming.
+func (s *Storage) save(dir, fileType string, entries interface{}, perm os.FileMode) error {
+	if s.Stream != nil {
+		return s.stream(fileType, entries)
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.writeFile(dir, fileType, data, perm)
+}
+
+// stream writes every entry of a slice, or a single value, as a line of its own.
+func (s *Storage) stream(fileType string, entries interface{}) error {
+	s.streamMutex.Lock()
+	defer s.streamMutex.Unlock()
+
+	encoder := json.NewEncoder(s.Stream)
+	file := StreamKey(fileType)
+	value := reflect.ValueOf(entries)
+	if value.Kind() != reflect.Slice {
+		return encoder.Encode(StreamLine{File: file, Record: entries})
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := encoder.Encode(StreamLine{File: file, Record: value.Index(i).Interface()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamKey returns the key of the entries of a file type in the stream: the file name without extension,
+// e.g. "signin" for sign-in activities.
+func StreamKey(fileType string) string {
+	return strings.TrimSuffix(fileNames[fileType], filepath.Ext(fileNames[fileType]))
+}
+
+// writeFile writes the file of a type into a directory, and records it for the manifest.
+func (s *Storage) writeFile(dir, fileType string, data []byte, perm os.FileMode) error {
+	path := GenerateFilePath(dir, fileType)
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return err
+	}
+
+	s.filesMutex.Lock()
+	defer s.filesMutex.Unlock()
+	s.files = append(s.files, filepath.Base(path))
+	return nil
+}
+
+// fileNames are the names of the files runs write, by file type.
+var fileNames = map[string]string{
+	"users":           "users.json",
+	"signInActivity":  "signin.json",
+	"rejects":         "rejects.json",
+	"conflicts":       "conflicts.json",
+	"staleAccounts":   "stale_accounts.json",
+	"signInAnalytics": "sign_in_analytics.json",
+	"encryption":      "encryption.json",
+	"manifest":        "manifest.json",
+}
+
+// IsOutputFile reports whether a run may write a file of that name into its output directory.
+func IsOutputFile(name string) bool {
+	for _, fileName := range fileNames {
+		if name == fileName {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateFilePath constructs a valid file path by combining a base directory with predefined file names.
+func GenerateFilePath(baseDir, fileType string) string {
 	// Retrieve file name or default to "output.json"
 	fileName, exists := fileNames[fileType]
 	if !exists {
@@ -1,9 +1,15 @@
 package storage_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"os"
+	"path/filepath"
+	"pathid_assignment/pkg/analytics"
+	"pathid_assignment/pkg/envelope"
+	"pathid_assignment/pkg/merge"
 	"pathid_assignment/pkg/storage"
+	"strings"
 	"testing"
 )
 
@@ -95,3 +101,209 @@ func TestStorage(t *testing.T) {
 		t.Errorf("Expected sign-in records, but found none")
 	}
 }
+
+func TestStorage_Encryption(t *testing.T) {
+	outputDir := t.TempDir()
+	kms, err := envelope.NewFileKMS(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("NewFileKMS failed: %v", err)
+	}
+	sealer, err := envelope.NewSealer(kms)
+	if err != nil {
+		t.Fatalf("NewSealer failed: %v", err)
+	}
+
+	store := storage.NewStorage()
+	store.Encryption = &storage.Encryption{Sealer: sealer, Fields: []string{"mail", "sign_in_activity.lastSignInRequestId"}}
+
+	users := []map[string]interface{}{{"id": "user-123", "mail": "user@example.com"}}
+	activities := []map[string]interface{}{{
+		"id": "user-123",
+		"sign_in_activity": map[string]interface{}{
+			"lastSignInDateTime":  "2025-03-15T08:00:00Z",
+			"lastSignInRequestId": "abcd-1234",
+		},
+	}}
+	if err := store.SaveEnvelope(outputDir); err != nil {
+		t.Fatalf("SaveEnvelope failed: %v", err)
+	}
+	if err := store.SaveUsers(users, outputDir); err != nil {
+		t.Fatalf("SaveUsers failed: %v", err)
+	}
+	if err := store.SaveSignInActivities(activities, outputDir); err != nil {
+		t.Fatalf("SaveSignInActivities failed: %v", err)
+	}
+	// Stale accounts seal the fields at the configured paths, not at the default ones.
+	stale := []analytics.StaleAccount{{ID: "user-123", Mail: "user@example.com", ExternalID: "ext-1"}}
+	if err := store.SaveStaleAccounts(stale, analytics.Fields{Mail: "mail", ExternalID: "mail"}, outputDir); err != nil {
+		t.Fatalf("SaveStaleAccounts failed: %v", err)
+	}
+
+	if users[0]["mail"] != "user@example.com" {
+		t.Errorf("Expected the caller's records to be left untouched, got %v", users[0])
+	}
+	for _, file := range []string{"users", "signInActivity", "staleAccounts"} {
+		data, _ := os.ReadFile(storage.GenerateFilePath(outputDir, file))
+		if strings.Contains(string(data), "user@example.com") || strings.Contains(string(data), "abcd-1234") || strings.Contains(string(data), "ext-1") {
+			t.Errorf("Expected encrypted fields in %s, got %s", file, data)
+		}
+	}
+
+	decryptedDir := filepath.Join(t.TempDir(), "decrypted")
+	decrypted, err := storage.DecryptDirectory(kms, outputDir, decryptedDir)
+	if err != nil {
+		t.Fatalf("DecryptDirectory failed: %v", err)
+	}
+	if decrypted != 4 {
+		t.Errorf("Expected 4 decrypted values, got %d", decrypted)
+	}
+
+	var stored []map[string]interface{}
+	data, _ := os.ReadFile(storage.GenerateFilePath(decryptedDir, "users"))
+	if err := json.Unmarshal(data, &stored); err != nil || stored[0]["mail"] != "user@example.com" {
+		t.Errorf("Expected the mail decrypted, got %s (%v)", data, err)
+	}
+}
+
+func TestStorage_Manifest(t *testing.T) {
+	outputDir := t.TempDir()
+	foreign := filepath.Join(outputDir, "notes.txt")
+	if err := os.WriteFile(foreign, []byte("kept"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	store := storage.NewStorage()
+	if err := store.SaveUsers([]map[string]interface{}{{"id": "user-123"}}, outputDir); err != nil {
+		t.Fatalf("SaveUsers failed: %v", err)
+	}
+	if err := store.SaveRejects([]map[string]interface{}{}, outputDir); err != nil {
+		t.Fatalf("SaveRejects failed: %v", err)
+	}
+	if err := store.SaveManifest(outputDir); err != nil {
+		t.Fatalf("SaveManifest failed: %v", err)
+	}
+
+	manifest, err := storage.LoadManifest(outputDir)
+	if err != nil {
+		t.Fatalf("LoadManifest failed: %v", err)
+	}
+	if strings.Join(manifest.Files, ",") != "rejects.json,users.json" {
+		t.Errorf("Expected the written files in the manifest, got %v", manifest.Files)
+	}
+
+	// A later run writing into the same directory keeps the files of the earlier one in the manifest.
+	later := storage.NewStorage()
+	if err := later.SaveConflicts(nil, outputDir); err != nil {
+		t.Fatalf("SaveConflicts failed: %v", err)
+	}
+	if err := later.SaveManifest(outputDir); err != nil {
+		t.Fatalf("SaveManifest failed: %v", err)
+	}
+	if manifest, err = storage.LoadManifest(outputDir); err != nil || strings.Join(manifest.Files, ",") != "conflicts.json,rejects.json,users.json" {
+		t.Errorf("Expected the files of both runs in the manifest, got %v (%v)", manifest, err)
+	}
+
+	kept, err := storage.CleanDirectory(outputDir)
+	if err != nil {
+		t.Fatalf("CleanDirectory failed: %v", err)
+	}
+	if len(kept) != 1 || kept[0] != "notes.txt" {
+		t.Errorf("Expected only the file not written by the run to be kept, got %v", kept)
+	}
+	if _, err := os.Stat(foreign); err != nil {
+		t.Errorf("Expected %s to be kept, got %v", foreign, err)
+	}
+}
+
+func TestRestoreSignInActivities(t *testing.T) {
+	outputDir := t.TempDir()
+	signIn := map[string]interface{}{"lastSignInDateTime": "2024-01-01T00:00:00", "lastSignInRequestId": "req-1"}
+	store := storage.NewStorage()
+	if err := store.SaveSignInActivities([]map[string]interface{}{{"id": "user-1", "sign_in_activity": signIn}}, outputDir); err != nil {
+		t.Fatalf("SaveSignInActivities failed: %v", err)
+	}
+
+	data, err := os.ReadFile(storage.GenerateFilePath(outputDir, "signInActivity"))
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	var activities []map[string]interface{}
+	if err := json.Unmarshal(data, &activities); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	users := []map[string]interface{}{{"id": "user-1"}, {"id": "user-2"}}
+	storage.RestoreSignInActivities(users, activities)
+	if restored, _ := json.Marshal(users[0]["sign_in_activity"]); string(restored) != `{"lastSignInDateTime":"2024-01-01T00:00:00","lastSignInRequestId":"req-1"}` {
+		t.Errorf("Expected the sign-ins to be restored, got %s", restored)
+	}
+	if _, exists := users[1]["sign_in_activity"]; exists {
+		t.Errorf("Expected a user without sign-ins to be left unchanged, got %v", users[1])
+	}
+}
+
+func TestLoadOutput(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "users.json"), []byte(`[{"id": "1"}]`), 0644); err != nil {
+		t.Fatalf("Failed to write users file: %v", err)
+	}
+
+	output, err := storage.LoadOutput(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(output.Users) != 1 || len(output.SignIns) != 0 {
+		t.Errorf("Unexpected output: %+v", output)
+	}
+
+	if _, err := storage.LoadOutput(t.TempDir()); err == nil {
+		t.Errorf("Expected an error for a directory without users.json")
+	}
+}
+
+func TestSaveSignInActivities_SkipsBadEntries(t *testing.T) {
+	outputDir := t.TempDir()
+	activities := []map[string]interface{}{
+		{"sign_in_activity": map[string]interface{}{"lastSignInDateTime": "2025-03-15T08:00:00Z", "lastSignInRequestId": "no-id"}},
+		{"id": "user-1"},
+		{"id": "user-2", "sign_in_activity": "unexpected"},
+		{"id": "user-3", "sign_in_activity": map[string]interface{}{"lastSignInDateTime": "2025-03-15T08:00:00Z", "lastSignInRequestId": "abcd-1234"}},
+	}
+	if err := storage.NewStorage().SaveSignInActivities(activities, outputDir); err != nil {
+		t.Fatalf("SaveSignInActivities failed: %v", err)
+	}
+
+	var signIns []storage.SignIn
+	data, err := os.ReadFile(storage.GenerateFilePath(outputDir, "signInActivity"))
+	if err != nil || json.Unmarshal(data, &signIns) != nil {
+		t.Fatalf("Failed to read signin.json: %s (%v)", data, err)
+	}
+	if len(signIns) != 1 || signIns[0].UserID != "user-3" || signIns[0].RequestID != "abcd-1234" {
+		t.Errorf("Expected the sign-ins of user-3 to be kept, got %+v", signIns)
+	}
+}
+
+func TestStorage_Stream(t *testing.T) {
+	var stream bytes.Buffer
+	store := storage.NewStorage()
+	store.Stream = &stream
+	dir := t.TempDir()
+
+	if err := store.SaveUsers([]map[string]interface{}{{"id": "user-1"}, {"id": "user-2"}}, dir); err != nil {
+		t.Fatalf("SaveUsers failed: %v", err)
+	}
+	if err := store.SaveConflicts([]merge.Conflict{{Key: "user-1", Field: "mail"}}, dir); err != nil {
+		t.Fatalf("SaveConflicts failed: %v", err)
+	}
+	if err := store.SaveManifest(dir); err != nil {
+		t.Fatalf("SaveManifest failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stream.String()), "\n")
+	if len(lines) != 3 || lines[0] != `{"file":"users","record":{"id":"user-1"}}` || !strings.HasPrefix(lines[2], `{"file":"conflicts","record":{`) {
+		t.Errorf("Expected a line per record, got:\n%s", stream.String())
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no file to be written when streaming, got %v (%v)", entries, err)
+	}
+}
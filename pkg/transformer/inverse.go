@@ -0,0 +1,199 @@
+package transformer
+
+import (
+	"fmt"
+	"strings"
+
+	"pathid_assignment/pkg/coerce"
+	"pathid_assignment/pkg/lookup"
+	"pathid_assignment/pkg/rules"
+)
+
+// NotInvertibleError lists the rules that prevent building source records from target records.
+type NotInvertibleError struct {
+	Issues []rules.Issue
+}
+
+func (e *NotInvertibleError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("rules are not invertible (%d issues):", len(e.Issues)))
+	for _, issue := range e.Issues {
+		sb.WriteString("\n  ")
+		sb.WriteString(issue.String())
+	}
+	return sb.String()
+}
+
+// InversePlan builds source-shaped records from target records, reversing a set of rules.
+// Like Plan, it holds no mutable state and is safe for concurrent use.
+type InversePlan struct {
+	fields []*inverseField
+	lossy  []rules.Issue
+}
+
+// inverseField copies one target field back to its source path.
+type inverseField struct {
+	target     string
+	targetKeys []string
+	sourceKeys []string
+	lookupName string
+	reverse    *lookup.Table
+	unmatched  string
+}
+
+// Invert compiles rules into an InversePlan. Only rules that map a target field from exactly one
+// source path can be reversed: constants, conditions, alternatives, protected values and lookups with
+// a default value lose information, as do lookup tables mapping several keys to the same value. A source path
+// mapped by several target fields is ambiguous. Every such rule is reported in a *NotInvertibleError.
+// Fields whose values are normalized by "$type" are reversed, but reported by Lossy.
+func (kt *KeywordTransformer) Invert(ruleSet *rules.Rules) (*InversePlan, error) {
+	inv := &inverter{kt: kt, lookups: ruleSet.Lookups, sources: make(map[string]string)}
+	plan := &InversePlan{}
+	inv.fields(plan, ruleSet.Fields, nil)
+
+	if len(inv.issues) > 0 {
+		return nil, &NotInvertibleError{Issues: inv.issues}
+	}
+	return plan, nil
+}
+
+// Lossy lists the reversed fields whose values may differ from those of the source records, such as
+// timestamps normalized to UTC by "$type", with the reason.
+func (p *InversePlan) Lossy() []rules.Issue {
+	return p.lossy
+}
+
+// Transform builds a source record from a target record. Target fields missing from the record
+// are left out of the source record.
+func (p *InversePlan) Transform(targetData map[string]interface{}) (map[string]interface{}, error) {
+	source := make(map[string]interface{})
+
+	for _, field := range p.fields {
+		val, found := extractValue(targetData, field.targetKeys)
+		if !found {
+			continue
+		}
+
+		if field.reverse != nil && val != nil {
+			original, exists := field.reverse.Get(val)
+			switch {
+			case exists:
+				val = original
+			case field.unmatched == lookup.UnmatchedFail:
+				return nil, fmt.Errorf("field %s: value %v not found in lookup table %q", field.target, val, field.lookupName)
+			}
+		}
+
+		if err := setValue(source, field.sourceKeys, val); err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.target, err)
+		}
+	}
+
+	return source, nil
+}
+
+// inverter collects the reversed fields of a rule tree, and the reasons rules cannot be reversed.
+type inverter struct {
+	kt      *KeywordTransformer
+	lookups map[string]lookup.Spec
+	sources map[string]string // Source path -> target path mapping it, to detect ambiguity.
+	issues  []rules.Issue
+}
+
+func (inv *inverter) report(rule *rules.Rule, targetKeys []string, message string) {
+	inv.issues = append(inv.issues, rules.Issue{Pos: rule.Pos, Pointer: "/" + strings.Join(targetKeys, "/"), Message: message})
+}
+
+func (inv *inverter) fields(plan *InversePlan, ruleSet []*rules.Rule, parent []string) {
+	for _, rule := range ruleSet {
+		targetKeys := append(append([]string(nil), parent...), rule.Target)
+
+		switch {
+		case rule.Kind == rules.KindGroup:
+			inv.fields(plan, rule.Fields, targetKeys)
+			continue
+		case rule.Kind == rules.KindAlternatives:
+			inv.report(rule, targetKeys, "not invertible: alternatives depend on conditions over the source record")
+			continue
+		case len(rule.When) > 0:
+			inv.report(rule, targetKeys, fmt.Sprintf("not invertible: %s depends on the source record", rules.WhenDirective))
+			continue
+		case rule.HasValue:
+			inv.report(rule, targetKeys, fmt.Sprintf("not invertible: %s has no source path", rules.ValueDirective))
+			continue
+		case rule.Protect != "":
+			inv.report(rule, targetKeys, fmt.Sprintf("not invertible: %s %s discards the original value", rules.ProtectDirective, rule.Protect))
+			continue
+		}
+
+		field := &inverseField{
+			target:     strings.Join(targetKeys, "."),
+			targetKeys: targetKeys,
+			sourceKeys: strings.Split(rule.Path, "."),
+			lookupName: rule.Lookup,
+			unmatched:  rule.Unmatched,
+		}
+
+		if !inv.claim(rule, targetKeys, rule.Path) {
+			continue
+		}
+
+		if rule.Lookup != "" {
+			if rule.Unmatched == lookup.UnmatchedDefault {
+				inv.report(rule, targetKeys, fmt.Sprintf("not invertible: unmatched values are replaced by %s", rules.DefaultDirective))
+				continue
+			}
+			table, err := inv.kt.tables.Load(inv.lookups[rule.Lookup])
+			if err == nil {
+				field.reverse, err = table.Invert()
+			}
+			if err != nil {
+				inv.report(rule, targetKeys, fmt.Sprintf("not invertible: lookup table %q: %v", rule.Lookup, err))
+				continue
+			}
+		}
+
+		if rule.Type != "" {
+			plan.lossy = append(plan.lossy, rules.Issue{Pos: rule.Pos, Pointer: "/" + strings.Join(targetKeys, "/"),
+				Message: fmt.Sprintf("lossy: %s %s, %s", rules.TypeDirective, rule.Type, coerce.Normalization(rule.Type))})
+		}
+		plan.fields = append(plan.fields, field)
+	}
+}
+
+// claim registers the source path of a target field, reporting paths that are already mapped,
+// or that are nested inside (or contain) another mapped path.
+func (inv *inverter) claim(rule *rules.Rule, targetKeys []string, path string) bool {
+	target := strings.Join(targetKeys, ".")
+	for claimed, claimedBy := range inv.sources {
+		if claimed == path || strings.HasPrefix(claimed, path+".") || strings.HasPrefix(path, claimed+".") {
+			inv.report(rule, targetKeys, fmt.Sprintf("not invertible: source path %q overlaps %q, which is mapped by %s", path, claimed, claimedBy))
+			return false
+		}
+	}
+	inv.sources[path] = target
+	return true
+}
+
+// setValue stores a value at a dot-separated path, creating intermediate objects as needed.
+func setValue(data map[string]interface{}, keys []string, value interface{}) error {
+	current := data
+	for _, key := range keys[:len(keys)-1] {
+		next, exists := current[key]
+		if !exists {
+			nested := make(map[string]interface{})
+			current[key] = nested
+			current = nested
+			continue
+		}
+
+		nested, ok := next.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot set %s: %s is not an object", strings.Join(keys, "."), key)
+		}
+		current = nested
+	}
+
+	current[keys[len(keys)-1]] = value
+	return nil
+}
@@ -0,0 +1,291 @@
+package transformer
+
+import (
+	"fmt"
+	"strings"
+
+	"pathid_assignment/pkg/coerce"
+	"pathid_assignment/pkg/expression"
+	"pathid_assignment/pkg/lookup"
+	"pathid_assignment/pkg/privacy"
+	"pathid_assignment/pkg/rules"
+)
+
+// Plan is a set of rules compiled for repeated execution: paths are pre-split, expressions parsed
+// and lookup tables loaded. A Plan holds no mutable state and is safe for concurrent use.
+type Plan struct {
+	filters []*expression.Expression
+	fields  []*fieldPlan
+}
+
+// ValueError describes a field whose value does not fit the type declared with "$type".
+type ValueError struct {
+	Field string // Dot-separated target path, e.g. "sign_in_activity.lastSignInDateTime".
+	Err   error
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("field %s: %v", e.Field, e.Err)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidRecordError lists every field of a record whose value does not fit its declared type.
+type InvalidRecordError struct {
+	Fields []*ValueError
+}
+
+func (e *InvalidRecordError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Error()
+	}
+	return "invalid record: " + strings.Join(messages, "; ")
+}
+
+// fieldPlan is the compiled form of a single rules.Rule.
+type fieldPlan struct {
+	target       string
+	path         string // Dot-separated target path, for error reporting.
+	kind         rules.Kind
+	keys         []string
+	value        interface{}
+	hasValue     bool
+	when         []*expression.Expression
+	lookupName   string
+	table        *lookup.Table
+	unmatched    string
+	defaultValue interface{}
+	typ          coerce.Type
+	protect      privacy.Method
+	protector    *privacy.Protector
+	alternatives []*fieldPlan
+	fields       []*fieldPlan
+}
+
+// Transform applies the compiled rules to the input data and returns the transformed result.
+// It returns ErrFiltered when the record does not satisfy the rules' filters, and an *InvalidRecordError
+// listing every field whose value does not fit its declared type.
+func (p *Plan) Transform(inputData map[string]interface{}) (map[string]interface{}, error) {
+	matched, err := allHold(p.filters, inputData)
+	if err != nil {
+		return nil, err
+	}
+	if !matched {
+		return nil, ErrFiltered
+	}
+
+	var invalid []*ValueError
+	result := make(map[string]interface{}, len(p.fields))
+	for _, field := range p.fields {
+		val, found, err := field.resolve(inputData, &invalid)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.target, err)
+		}
+		if found {
+			result[field.target] = val
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, &InvalidRecordError{Fields: invalid}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no matching fields found")
+	}
+
+	return result, nil
+}
+
+// resolve evaluates the compiled rule against the input data. Values that do not fit their declared
+// type are appended to invalid rather than failing the rest of the record.
+func (f *fieldPlan) resolve(inputData map[string]interface{}, invalid *[]*ValueError) (interface{}, bool, error) {
+	switch f.kind {
+	case rules.KindPath:
+		val, found := extractValue(inputData, f.keys)
+		return val, found, nil
+
+	case rules.KindAlternatives:
+		for _, alternative := range f.alternatives {
+			applies, err := allHold(alternative.when, inputData)
+			if err != nil {
+				return nil, false, err
+			}
+			if applies {
+				return alternative.resolve(inputData, invalid)
+			}
+		}
+		return nil, false, nil
+
+	case rules.KindGroup:
+		nestedMap := make(map[string]interface{}, len(f.fields))
+		for _, field := range f.fields {
+			val, found, err := field.resolve(inputData, invalid)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", field.target, err)
+			}
+			if found {
+				nestedMap[field.target] = val
+			}
+		}
+		return nestedMap, len(nestedMap) > 0, nil
+	}
+
+	val, found, err := f.resolveField(inputData)
+	if err != nil || !found {
+		return val, found, err
+	}
+
+	if f.typ != "" {
+		if val, err = coerce.Coerce(f.typ, val); err != nil {
+			*invalid = append(*invalid, &ValueError{Field: f.path, Err: err})
+			return nil, false, nil
+		}
+	}
+
+	// Values are protected last, so lookups and types apply to the original value.
+	if f.protect != "" {
+		if val, err = f.protector.Protect(f.protect, val); err != nil {
+			return nil, false, err
+		}
+	}
+	return val, true, nil
+}
+
+// resolveField evaluates a field rule: its condition first, then its constant value or source path,
+// and finally its lookup table, if any.
+func (f *fieldPlan) resolveField(inputData map[string]interface{}) (interface{}, bool, error) {
+	applies, err := allHold(f.when, inputData)
+	if err != nil || !applies {
+		return nil, false, err
+	}
+
+	val, found := f.value, f.hasValue
+	if !f.hasValue {
+		val, found = extractValue(inputData, f.keys)
+	}
+
+	// Missing and null values are passed through rather than looked up.
+	if !found || val == nil || f.table == nil {
+		return val, found, nil
+	}
+
+	if mapped, exists := f.table.Get(val); exists {
+		return mapped, true, nil
+	}
+
+	switch f.unmatched {
+	case lookup.UnmatchedDefault:
+		return f.defaultValue, true, nil
+	case lookup.UnmatchedFail:
+		return nil, false, fmt.Errorf("value %v not found in lookup table %q", val, f.lookupName)
+	}
+	return val, true, nil
+}
+
+// allHold reports whether every condition holds for the input data.
+func allHold(conditions []*expression.Expression, inputData map[string]interface{}) (bool, error) {
+	for _, condition := range conditions {
+		matched, err := condition.EvalBool(inputData)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// compiler turns typed rules into a Plan, sharing parsed expressions and loaded tables through the transformer.
+type compiler struct {
+	kt        *KeywordTransformer
+	lookups   map[string]lookup.Spec
+	keySpec   privacy.KeySpec
+	protector *privacy.Protector // Loaded on the first rule protecting its value.
+}
+
+func (c *compiler) fields(ruleSet []*rules.Rule, parent string) ([]*fieldPlan, error) {
+	fields := make([]*fieldPlan, 0, len(ruleSet))
+	for _, rule := range ruleSet {
+		field, err := c.field(rule, joinField(parent, rule.Target))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", rule.Target, err)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func (c *compiler) field(rule *rules.Rule, path string) (*fieldPlan, error) {
+	field := &fieldPlan{
+		target:       rule.Target,
+		path:         path,
+		kind:         rule.Kind,
+		value:        rule.Value,
+		hasValue:     rule.HasValue,
+		lookupName:   rule.Lookup,
+		unmatched:    rule.Unmatched,
+		defaultValue: rule.Default,
+		typ:          rule.Type,
+		protect:      rule.Protect,
+	}
+	if rule.Path != "" {
+		field.keys = strings.Split(rule.Path, ".")
+	}
+
+	var err error
+	if field.when, err = c.expressions(rule.When); err != nil {
+		return nil, err
+	}
+
+	if rule.Lookup != "" {
+		if field.table, err = c.kt.tables.Load(c.lookups[rule.Lookup]); err != nil {
+			return nil, fmt.Errorf("lookup table %q: %w", rule.Lookup, err)
+		}
+	}
+
+	if rule.Protect != "" {
+		if field.protector, err = c.privacy(rule.Protect); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, alternative := range rule.Alternatives {
+		compiled, err := c.field(alternative, path)
+		if err != nil {
+			return nil, err
+		}
+		field.alternatives = append(field.alternatives, compiled)
+	}
+
+	if field.fields, err = c.fields(rule.Fields, path); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
+
+// privacy returns the protector of the rules, reading the key the first time a keyed method needs it.
+func (c *compiler) privacy(method privacy.Method) (*privacy.Protector, error) {
+	if c.protector != nil || !method.Keyed() {
+		return c.protector, nil
+	}
+	key, err := c.keySpec.Load()
+	if err != nil {
+		return nil, err
+	}
+	c.protector = privacy.NewProtector(key)
+	return c.protector, nil
+}
+
+func (c *compiler) expressions(sources []string) ([]*expression.Expression, error) {
+	expressions := make([]*expression.Expression, 0, len(sources))
+	for _, source := range sources {
+		expr, err := c.kt.expression(source)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, expr)
+	}
+	return expressions, nil
+}
@@ -1,10 +1,19 @@
 package transformer
 
 import (
-	"fmt"
-	"strings"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"pathid_assignment/pkg/expression"
+	"pathid_assignment/pkg/lookup"
+	"pathid_assignment/pkg/rules"
 )
 
+// ErrFiltered is returned by Transform when a record does not satisfy the "$filter" conditions.
+var ErrFiltered = errors.New("record filtered out")
+
 // GenericTransformer is an interface that defines a method to transform input data based on given rules.
 type GenericTransformer interface {
 	// Transform takes an input data map and a set of transformation rules,
@@ -12,52 +21,94 @@ type GenericTransformer interface {
 	Transform(inputData map[string]interface{}, rules map[string]interface{}) (map[string]interface{}, error)
 }
 
+// Compiler is implemented by transformers that can compile rules once per run into a reusable Plan,
+// instead of interpreting the rules map for every record.
+type Compiler interface {
+	Compile(ruleSet *rules.Rules) (*Plan, error)
+}
+
 // KeywordTransformer is a concrete implementation of the GenericTransformer interface.
-type KeywordTransformer struct{}
+type KeywordTransformer struct {
+	expressions sync.Map     // Parsed expressions keyed by their source, shared by all compiled plans.
+	tables      lookup.Cache // Lookup tables, loaded once on first use.
+
+	last atomic.Pointer[compiledRules] // Plan of the rules last given to Transform.
+}
+
+// compiledRules is the plan compiled from a rules map by Transform, keyed by the JSON encoding of the
+// map, so that rules edited in place are compiled again.
+type compiledRules struct {
+	key  string
+	plan *Plan
+}
 
 // NewKeywordTransformer returns a new instance of KeywordTransformer as a GenericTransformer.
 func NewKeywordTransformer() GenericTransformer {
 	return &KeywordTransformer{}
 }
 
+// Compile turns validated rules into a Plan. Lookup tables and the privacy key are loaded here,
+// so a missing table or key fails the run before any record is read.
+func (kt *KeywordTransformer) Compile(ruleSet *rules.Rules) (*Plan, error) {
+	c := &compiler{kt: kt, lookups: ruleSet.Lookups, keySpec: ruleSet.Privacy}
+
+	filters, err := c.expressions(ruleSet.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := c.fields(ruleSet.Fields, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Plan{filters: filters, fields: fields}, nil
+}
+
 // Transform applies the transformation rules to the inputData and returns the transformed result.
-// It iterates over the rules and extracts values from inputData based on source paths.
-func (kt *KeywordTransformer) Transform(inputData map[string]interface{}, rules map[string]interface{}) (map[string]interface{}, error) {
-	result := make(map[string]interface{})
-
-	// Loop over each rule entry where the key is the target field name and the value is the source path or nested mapping.
-	for targetKey, sourcePath := range rules {
-		switch path := sourcePath.(type) {
-		case string: // If the rule is a string, treat it as a dot-separated path to a value in the input data.
-			if val, found := extractValue(inputData, path); found {
-				result[targetKey] = val
-			}
-		case map[string]interface{}: // If the rule is a nested map, process each nested rule.
-			nestedMap := make(map[string]interface{})
-			for nestedTargetKey, nestedSourcePath := range path {
-				if nestedPath, ok := nestedSourcePath.(string); ok {
-					if val, found := extractValue(inputData, nestedPath); found {
-						nestedMap[nestedTargetKey] = val
-					}
-				}
-			}
-			if len(nestedMap) > 0 {
-				result[targetKey] = nestedMap
-			}
-		}
+// The rules are validated and compiled on the first call with their content, and the plan is reused while
+// rules of the same content are given. Comparing the rules costs an encoding per call: callers transforming
+// many records, or alternating between several rules, should Compile them once and use Plan.Transform.
+func (kt *KeywordTransformer) Transform(inputData map[string]interface{}, rulesMap map[string]interface{}) (map[string]interface{}, error) {
+	key, keyErr := json.Marshal(rulesMap)
+	if cached := kt.last.Load(); keyErr == nil && cached != nil && cached.key == string(key) {
+		return cached.plan.Transform(inputData)
+	}
+
+	ruleSet, err := rules.FromMap(rulesMap)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := kt.Compile(ruleSet)
+	if err != nil {
+		return nil, err
+	}
+	if keyErr == nil {
+		kt.last.Store(&compiledRules{key: string(key), plan: plan})
+	}
+
+	return plan.Transform(inputData)
+}
+
+// expression returns the parsed expression for the given source, parsing it only on first use.
+func (kt *KeywordTransformer) expression(source string) (*expression.Expression, error) {
+	if cached, ok := kt.expressions.Load(source); ok {
+		return cached.(*expression.Expression), nil
 	}
 
-	if len(result) == 0 {
-		return nil, fmt.Errorf("no matching fields found")
+	expr, err := expression.Parse(source)
+	if err != nil {
+		return nil, err
 	}
 
-	return result, nil
+	kt.expressions.Store(source, expr)
+	return expr, nil
 }
 
-// extractValue traverses the input data map using a dot-separated path to find the target value.
+// extractValue traverses the input data map along pre-split path keys to find the target value.
 // It returns the value and a boolean indicating whether the value was found.
-func extractValue(data map[string]interface{}, path string) (interface{}, bool) {
-	keys := strings.Split(path, ".")
+func extractValue(data map[string]interface{}, keys []string) (interface{}, bool) {
 	var value interface{} = data
 
 	for _, key := range keys {
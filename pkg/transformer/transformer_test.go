@@ -1,12 +1,20 @@
 package transformer_test
 
 import (
+	"pathid_assignment/pkg/coerce"
+	"pathid_assignment/pkg/models"
+	"pathid_assignment/pkg/rules"
 	"pathid_assignment/pkg/transformer"
 	"pathid_assignment/pkg/unmarshaller"
 	"testing"
 
 	"encoding/json"
+	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 )
 
 func TestKeywordTransformer_JSON(t *testing.T) {
@@ -95,3 +103,584 @@ func TestKeywordTransformer_JSON(t *testing.T) {
 
 	t.Logf("Transformation result written to: %s", outputPath)
 }
+
+func TestKeywordTransformer_ConditionalRules(t *testing.T) {
+	rules := `{
+		"$filter": "accountEnabled == true",
+		"id": "id",
+		"type": [
+			{"$when": "userType == 'Guest'", "$value": "external"},
+			"userType"
+		],
+		"mail": {"$when": "mail != null", "$path": "mail"}
+	}`
+
+	var rulesMap map[string]interface{}
+	if err := json.Unmarshal([]byte(rules), &rulesMap); err != nil {
+		t.Fatalf("Failed to unmarshal rules: %v", err)
+	}
+
+	kt := transformer.NewKeywordTransformer()
+
+	guest, err := kt.Transform(map[string]interface{}{"id": "1", "userType": "Guest", "accountEnabled": true, "mail": nil}, rulesMap)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if guest["type"] != "external" {
+		t.Errorf("Expected type 'external', got '%v'", guest["type"])
+	}
+	if _, exists := guest["mail"]; exists {
+		t.Errorf("Expected mail to be omitted, got '%v'", guest["mail"])
+	}
+
+	member, err := kt.Transform(map[string]interface{}{"id": "2", "userType": "Member", "accountEnabled": true, "mail": "m@example.com"}, rulesMap)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if member["type"] != "Member" || member["mail"] != "m@example.com" {
+		t.Errorf("Unexpected member result: %v", member)
+	}
+
+	_, err = kt.Transform(map[string]interface{}{"id": "3", "userType": "Member", "accountEnabled": false}, rulesMap)
+	if !errors.Is(err, transformer.ErrFiltered) {
+		t.Errorf("Expected ErrFiltered for disabled account, got %v", err)
+	}
+}
+
+func TestKeywordTransformer_Lookups(t *testing.T) {
+	lookupPath := filepath.Join(t.TempDir(), "countries.csv")
+	if err := os.WriteFile(lookupPath, []byte("code,name\nUS,United States\n"), 0644); err != nil {
+		t.Fatalf("Failed to write lookup file: %v", err)
+	}
+
+	rulesMap := map[string]interface{}{
+		"$lookups": map[string]interface{}{
+			"countries": map[string]interface{}{"file": lookupPath, "key": "code", "value": "name"},
+		},
+		"id":      "id",
+		"country": map[string]interface{}{"$path": "usageLocation", "$lookup": "countries", "$unmatched": "default", "$default": "Unknown"},
+		"strict":  map[string]interface{}{"$path": "usageLocation", "$lookup": "countries", "$unmatched": "fail"},
+	}
+
+	kt := transformer.NewKeywordTransformer()
+
+	result, err := kt.Transform(map[string]interface{}{"id": "1", "usageLocation": "US"}, rulesMap)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if result["country"] != "United States" || result["strict"] != "United States" {
+		t.Errorf("Unexpected lookup result: %v", result)
+	}
+
+	if _, err := kt.Transform(map[string]interface{}{"id": "2", "usageLocation": "ZZ"}, rulesMap); err == nil {
+		t.Errorf("Expected unmatched value to fail in strict mode")
+	}
+
+	delete(rulesMap, "strict")
+	result, err = kt.Transform(map[string]interface{}{"id": "2", "usageLocation": "ZZ"}, rulesMap)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if result["country"] != "Unknown" {
+		t.Errorf("Expected default 'Unknown', got '%v'", result["country"])
+	}
+}
+
+// loadSampleRecords reads the records of the first sample input file, for benchmarks.
+func loadSampleRecords(b *testing.B) []map[string]interface{} {
+	b.Helper()
+	fileData, err := os.ReadFile("../../data/input/fake_users_part_1.json")
+	if err != nil {
+		b.Fatalf("Failed to read sample input: %v", err)
+	}
+
+	records, err := unmarshaller.NewJSONUnmarshaller().UnmarshalByProperty(fileData, nil, "value")
+	if err != nil {
+		b.Fatalf("Failed to unmarshal sample input: %v", err)
+	}
+	return records
+}
+
+func loadDefaultRules(b *testing.B) *rules.Rules {
+	b.Helper()
+	ruleSet, err := rules.Load("../../configs/default_mapping_config.json")
+	if err != nil {
+		b.Fatalf("Failed to load rules: %v", err)
+	}
+	return ruleSet
+}
+
+func TestKeywordTransformer_CachedPlan(t *testing.T) {
+	kt := transformer.NewKeywordTransformer()
+	record := map[string]interface{}{"id": "1", "mail": "a@example.com"}
+
+	// The plan of a rules map is reused, and replaced when other rules are given.
+	for _, rulesMap := range []map[string]interface{}{{"id": "id"}, {"id": "id"}, {"email": "mail"}} {
+		result, err := kt.Transform(record, rulesMap)
+		if err != nil {
+			t.Fatalf("Transform failed: %v", err)
+		}
+		for target := range rulesMap {
+			if _, exists := result[target]; !exists || len(result) != 1 {
+				t.Errorf("Expected only %q with rules %v, got %v", target, rulesMap, result)
+			}
+		}
+	}
+
+	// Rules edited in place are compiled again.
+	rulesMap := map[string]interface{}{"id": "id"}
+	if _, err := kt.Transform(record, rulesMap); err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	rulesMap["id"] = "mail"
+	if result, err := kt.Transform(record, rulesMap); err != nil || result["id"] != "a@example.com" {
+		t.Errorf("Expected the edited rules to be applied, got %v (%v)", result, err)
+	}
+
+	if _, err := kt.Transform(record, map[string]interface{}{"id": 5}); err == nil {
+		t.Errorf("Expected invalid rules to be reported after a cached plan")
+	}
+}
+
+// interpret is the transformer the compiled plans replaced: it walks the rules map and splits every path
+// for each record. It is kept as the baseline of the benchmarks.
+func interpret(inputData map[string]interface{}, rulesMap map[string]interface{}) (map[string]interface{}, error) {
+	extract := func(path string) (interface{}, bool) {
+		var value interface{} = inputData
+		for _, key := range strings.Split(path, ".") {
+			m, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			if value, ok = m[key]; !ok {
+				return nil, false
+			}
+		}
+		return value, true
+	}
+
+	result := make(map[string]interface{})
+	for targetKey, sourcePath := range rulesMap {
+		switch path := sourcePath.(type) {
+		case string:
+			if val, found := extract(path); found {
+				result[targetKey] = val
+			}
+		case map[string]interface{}:
+			nestedMap := make(map[string]interface{})
+			for nestedTargetKey, nestedSourcePath := range path {
+				if nestedPath, ok := nestedSourcePath.(string); ok {
+					if val, found := extract(nestedPath); found {
+						nestedMap[nestedTargetKey] = val
+					}
+				}
+			}
+			if len(nestedMap) > 0 {
+				result[targetKey] = nestedMap
+			}
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no matching fields found")
+	}
+	return result, nil
+}
+
+// BenchmarkBaseline_Transform measures the interpreter the plans replaced, as the baseline of the others.
+func BenchmarkBaseline_Transform(b *testing.B) {
+	records := loadSampleRecords(b)
+	rulesMap := loadDefaultRules(b).Document
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := interpret(records[i%len(records)], rulesMap); err != nil {
+			b.Fatalf("Transform failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkKeywordTransformer_Transform measures the GenericTransformer path, which reuses the plan of the rules map.
+func BenchmarkKeywordTransformer_Transform(b *testing.B) {
+	records := loadSampleRecords(b)
+	rulesMap := loadDefaultRules(b).Document
+	kt := transformer.NewKeywordTransformer()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := kt.Transform(records[i%len(records)], rulesMap); err != nil {
+			b.Fatalf("Transform failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkPlan_Transform measures rules compiled once into a plan.
+func BenchmarkPlan_Transform(b *testing.B) {
+	records := loadSampleRecords(b)
+	plan, err := transformer.NewKeywordTransformer().(transformer.Compiler).Compile(loadDefaultRules(b))
+	if err != nil {
+		b.Fatalf("Compile failed: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := plan.Transform(records[i%len(records)]); err != nil {
+			b.Fatalf("Transform failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkPlan_TransformParallel measures a single plan shared by concurrent goroutines.
+func BenchmarkPlan_TransformParallel(b *testing.B) {
+	records := loadSampleRecords(b)
+	plan, err := transformer.NewKeywordTransformer().(transformer.Compiler).Compile(loadDefaultRules(b))
+	if err != nil {
+		b.Fatalf("Compile failed: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := plan.Transform(records[i%len(records)]); err != nil {
+				b.Errorf("Transform failed: %v", err)
+				return
+			}
+		}
+	})
+}
+
+func TestTransformInto(t *testing.T) {
+	ruleSet, err := rules.Load("../../configs/default_mapping_config.json")
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	plan, err := transformer.NewKeywordTransformer().(transformer.Compiler).Compile(ruleSet)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	user, err := transformer.TransformInto[models.DefaultStructure](plan, map[string]interface{}{
+		"id":                "123",
+		"userPrincipalName": "user@example.com",
+		"accountEnabled":    true,
+		"givenName":         "John",
+		"signInActivity": map[string]interface{}{
+			"lastSignInDateTime":  "2017-12-27T04:06:12",
+			"lastSignInRequestId": "f4c4580f-fb0b-4d9d-8ed5-46b8e5af13b7",
+		},
+	})
+	if err != nil {
+		t.Fatalf("TransformInto failed: %v", err)
+	}
+	if user.Id != "123" || !user.IsEnabled || user.FirstName != "John" {
+		t.Errorf("Unexpected user: %+v", user)
+	}
+	if user.SignInActivity == nil || user.SignInActivity.LastSignInDateTime != "2017-12-27T04:06:12" {
+		t.Errorf("Unexpected sign-in activity: %+v", user.SignInActivity)
+	}
+
+	_, err = transformer.TransformInto[models.DefaultStructure](plan, map[string]interface{}{
+		"id":             "456",
+		"accountEnabled": "true",
+		"givenName":      42.0,
+	})
+	var mismatchErr *transformer.MismatchError
+	if !errors.As(err, &mismatchErr) {
+		t.Fatalf("Expected a mismatch error, got %v", err)
+	}
+	if len(mismatchErr.Fields) != 2 {
+		t.Errorf("Expected 2 mismatched fields, got %v", mismatchErr)
+	}
+}
+
+func TestPlanFor(t *testing.T) {
+	type signIn struct {
+		Last time.Time `json:"last" transform:"signInActivity.lastSignInDateTime"`
+	}
+	type user struct {
+		ID      string  `json:"id" transform:"id"`
+		Enabled bool    `json:"enabled" transform:"accountEnabled"`
+		SignIn  *signIn `json:"sign_in"`
+		Ignored string  `json:"ignored"`
+	}
+
+	plan, err := transformer.PlanFor[user]()
+	if err != nil {
+		t.Fatalf("PlanFor failed: %v", err)
+	}
+
+	u, err := transformer.TransformInto[user](plan, map[string]interface{}{
+		"id":             "789",
+		"accountEnabled": false,
+		"signInActivity": map[string]interface{}{"lastSignInDateTime": "2017-12-27T04:06:12"},
+	})
+	if err != nil {
+		t.Fatalf("TransformInto failed: %v", err)
+	}
+	if u.ID != "789" || u.SignIn == nil || u.SignIn.Last.Year() != 2017 {
+		t.Errorf("Unexpected result: %+v", u)
+	}
+}
+
+func TestKeywordTransformer_Invert(t *testing.T) {
+	lookupPath := filepath.Join(t.TempDir(), "countries.csv")
+	if err := os.WriteFile(lookupPath, []byte("code,name\nUS,United States\nIL,Israel\n"), 0644); err != nil {
+		t.Fatalf("Failed to write lookup file: %v", err)
+	}
+
+	ruleSet, err := rules.FromMap(map[string]interface{}{
+		"$filter": []interface{}{"accountEnabled"},
+		"$lookups": map[string]interface{}{
+			"countries": map[string]interface{}{"file": lookupPath, "key": "code", "value": "name"},
+		},
+		"id":      "id",
+		"country": map[string]interface{}{"$path": "usageLocation", "$lookup": "countries"},
+		"sign_in_activity": map[string]interface{}{
+			"lastSignInDateTime": "signInActivity.lastSignInDateTime",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build rules: %v", err)
+	}
+
+	kt := &transformer.KeywordTransformer{}
+	inverse, err := kt.Invert(ruleSet)
+	if err != nil {
+		t.Fatalf("Invert failed: %v", err)
+	}
+
+	source, err := inverse.Transform(map[string]interface{}{
+		"id":               "1",
+		"country":          "Israel",
+		"sign_in_activity": map[string]interface{}{"lastSignInDateTime": "2017-12-27T04:06:12"},
+	})
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"id":             "1",
+		"usageLocation":  "IL",
+		"signInActivity": map[string]interface{}{"lastSignInDateTime": "2017-12-27T04:06:12"},
+	}
+	if !reflect.DeepEqual(source, expected) {
+		t.Errorf("Expected %v, got %v", expected, source)
+	}
+
+	// Transforming the source record again yields the original target record.
+	plan, err := kt.Compile(ruleSet)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	source["accountEnabled"] = true
+	target, err := plan.Transform(source)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if target["country"] != "Israel" {
+		t.Errorf("Expected round trip to keep 'Israel', got '%v'", target["country"])
+	}
+}
+
+func TestKeywordTransformer_InvertNotInvertible(t *testing.T) {
+	ruleSet, err := rules.FromMap(map[string]interface{}{
+		"id":     "id",
+		"source": map[string]interface{}{"$value": "graph"},
+		"alias":  "id",
+		"status": []interface{}{
+			map[string]interface{}{"$when": "accountEnabled", "$value": "active"},
+			map[string]interface{}{"$value": "disabled"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build rules: %v", err)
+	}
+
+	_, err = (&transformer.KeywordTransformer{}).Invert(ruleSet)
+	var notInvertible *transformer.NotInvertibleError
+	if !errors.As(err, &notInvertible) {
+		t.Fatalf("Expected a NotInvertibleError, got %v", err)
+	}
+	if len(notInvertible.Issues) != 3 {
+		t.Errorf("Expected 3 issues, got %d: %v", len(notInvertible.Issues), err)
+	}
+}
+
+func TestKeywordTransformer_InvertLossy(t *testing.T) {
+	ruleSet, err := rules.FromMap(map[string]interface{}{
+		"id":   "id",
+		"mail": map[string]interface{}{"$path": "mail", "$type": "email"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build rules: %v", err)
+	}
+
+	inverse, err := (&transformer.KeywordTransformer{}).Invert(ruleSet)
+	if err != nil {
+		t.Fatalf("Invert failed: %v", err)
+	}
+	lossy := inverse.Lossy()
+	if len(lossy) != 1 || lossy[0].Pointer != "/mail" {
+		t.Errorf("Expected the coerced mail to be lossy, got %v", lossy)
+	}
+}
+
+// TestKeywordTransformer_InvertTypes runs a round trip through every type: values already in normal form
+// are restored, others come back normalized, as reported by Lossy.
+func TestKeywordTransformer_InvertTypes(t *testing.T) {
+	cases := map[coerce.Type]struct{ normal, other interface{} }{
+		coerce.Bool:        {normal: true, other: "yes"},
+		coerce.Int:         {normal: 42.0, other: "42"},
+		coerce.Float:       {normal: 1.5, other: " 1.5"},
+		coerce.Timestamp:   {normal: "2017-12-27T04:06:12Z", other: "2017-12-27T04:06:12"},
+		coerce.Email:       {normal: "a@example.com", other: "A@Example.com"},
+		coerce.UUID:        {normal: "f4c4580f-fb0b-4d9d-8ed5-46b8e5af13b7", other: "F4C4580F-FB0B-4D9D-8ED5-46B8E5AF13B7"},
+		coerce.CountryCode: {normal: "IL", other: "il"},
+	}
+
+	kt := &transformer.KeywordTransformer{}
+	for _, typ := range coerce.Types {
+		c, ok := cases[typ]
+		if !ok {
+			t.Errorf("No round trip case for type %s", typ)
+			continue
+		}
+
+		ruleSet, err := rules.FromMap(map[string]interface{}{"value": map[string]interface{}{"$path": "source", "$type": string(typ)}})
+		if err != nil {
+			t.Fatalf("Failed to build rules: %v", err)
+		}
+		plan, err := kt.Compile(ruleSet)
+		if err != nil {
+			t.Fatalf("Compile failed: %v", err)
+		}
+		inverse, err := kt.Invert(ruleSet)
+		if err != nil {
+			t.Fatalf("Invert failed: %v", err)
+		}
+
+		lossy := inverse.Lossy()
+		if len(lossy) != 1 || !strings.Contains(lossy[0].Message, coerce.Normalization(typ)) || coerce.Normalization(typ) == "" {
+			t.Errorf("%s: expected a lossy field with its normalization, got %v", typ, lossy)
+		}
+
+		for _, value := range []interface{}{c.normal, c.other} {
+			target, err := plan.Transform(map[string]interface{}{"source": value})
+			if err != nil {
+				t.Fatalf("%s: Transform of %v failed: %v", typ, value, err)
+			}
+			source, err := inverse.Transform(target)
+			if err != nil {
+				t.Fatalf("%s: inverse Transform failed: %v", typ, err)
+			}
+			if restored := source["source"]; restored != c.normal {
+				t.Errorf("%s: expected %v to come back as %v, got %v", typ, value, c.normal, restored)
+			}
+		}
+	}
+}
+
+func TestPlan_TypeCoercion(t *testing.T) {
+	ruleSet, err := rules.FromMap(map[string]interface{}{
+		"id":         map[string]interface{}{"$path": "id", "$type": "uuid"},
+		"mail":       map[string]interface{}{"$path": "mail", "$type": "email"},
+		"location":   map[string]interface{}{"$path": "usageLocation", "$type": "country_code"},
+		"is_enabled": map[string]interface{}{"$path": "accountEnabled", "$type": "bool"},
+		"sign_in_activity": map[string]interface{}{
+			"lastSignInDateTime": map[string]interface{}{"$path": "signInActivity.lastSignInDateTime", "$type": "timestamp"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build rules: %v", err)
+	}
+
+	plan, err := (&transformer.KeywordTransformer{}).Compile(ruleSet)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	result, err := plan.Transform(map[string]interface{}{
+		"id":             "0E685562-4A32-4728-A7EC-4D288ED7D3D4",
+		"mail":           nil,
+		"usageLocation":  "us",
+		"accountEnabled": "true",
+		"signInActivity": map[string]interface{}{"lastSignInDateTime": "2017-12-27T04:06:12"},
+	})
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if result["is_enabled"] != true || result["location"] != "US" || result["mail"] != nil {
+		t.Errorf("Unexpected coerced values: %v", result)
+	}
+	if signIn := result["sign_in_activity"].(map[string]interface{}); signIn["lastSignInDateTime"] != "2017-12-27T04:06:12Z" {
+		t.Errorf("Expected a UTC timestamp, got %v", signIn["lastSignInDateTime"])
+	}
+
+	// Every invalid field is reported, not only the first one.
+	_, err = plan.Transform(map[string]interface{}{
+		"id":             "123",
+		"accountEnabled": "maybe",
+		"signInActivity": map[string]interface{}{"lastSignInDateTime": "yesterday"},
+	})
+	var invalid *transformer.InvalidRecordError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Expected an InvalidRecordError, got %v", err)
+	}
+	fields := make(map[string]bool)
+	for _, field := range invalid.Fields {
+		fields[field.Field] = true
+	}
+	if len(fields) != 3 || !fields["id"] || !fields["is_enabled"] || !fields["sign_in_activity.lastSignInDateTime"] {
+		t.Errorf("Unexpected invalid fields: %v", err)
+	}
+}
+
+func TestPlan_Protect(t *testing.T) {
+	t.Setenv("TEST_PRIVACY_KEY", "secret")
+	ruleSet, err := rules.Parse([]byte(`{
+		"$privacy": {"key_env": "TEST_PRIVACY_KEY"},
+		"id": {"$path": "id", "$type": "uuid", "$protect": "tokenize"},
+		"mail": {"$path": "mail", "$protect": "mask"},
+		"external_id": {"$path": "userPrincipalName", "$protect": "hmac"},
+		"last_name": {"$path": "surname", "$protect": "redact"}
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to parse rules: %v", err)
+	}
+
+	plan, err := (&transformer.KeywordTransformer{}).Compile(ruleSet)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	input := map[string]interface{}{
+		"id":                "0E685562-4A32-4728-A7EC-4D288ED7D3D4",
+		"mail":              "ann@example.com",
+		"userPrincipalName": "ann@example.com",
+		"surname":           "Lee",
+	}
+	result, err := plan.Transform(input)
+	if err != nil {
+		t.Fatalf("Transform failed: %v", err)
+	}
+	if result["mail"] != "a***@example.com" || result["last_name"] != "[REDACTED]" {
+		t.Errorf("Unexpected protected values: %v", result)
+	}
+	if id := result["id"].(string); id == "0e685562-4a32-4728-a7ec-4d288ed7d3d4" || len(id) != 36 {
+		t.Errorf("Expected the coerced id to be tokenized, got %q", id)
+	}
+
+	again, _ := plan.Transform(input)
+	if !reflect.DeepEqual(result, again) {
+		t.Errorf("Expected protected values to be consistent across records: %v and %v", result, again)
+	}
+
+	// Keyed protections fail the compilation when the key is missing.
+	ruleSet.Privacy.Env = "MISSING_PRIVACY_KEY"
+	if _, err := (&transformer.KeywordTransformer{}).Compile(ruleSet); err == nil {
+		t.Errorf("Expected an error for a missing privacy key")
+	}
+}
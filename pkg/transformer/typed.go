@@ -0,0 +1,292 @@
+package transformer
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"pathid_assignment/pkg/rules"
+	"pathid_assignment/pkg/utils"
+)
+
+// TransformTag is the struct tag holding the source path of a field for PlanFor, e.g. `transform:"givenName"`.
+const TransformTag = "transform"
+
+// FieldError describes a transformed value that does not fit the struct field it maps to.
+type FieldError struct {
+	Field    string // Dot-separated target path, e.g. "sign_in_activity.lastSignInDateTime".
+	Expected string // Go type of the struct field.
+	Got      string // Type of the transformed value.
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s: cannot assign %s to %s", e.Field, e.Got, e.Expected)
+}
+
+// MismatchError lists every field of a record whose value could not be assigned.
+type MismatchError struct {
+	Fields []*FieldError
+}
+
+func (e *MismatchError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Error()
+	}
+	return "type mismatch: " + strings.Join(messages, "; ")
+}
+
+// TransformInto transforms the input data with the plan and assigns the result to a new T.
+// Target fields are matched to struct fields by their json tag, or by field name without one.
+// Values that do not fit their field are reported together in a *MismatchError, in which case
+// the returned T holds every field that could be assigned.
+func TransformInto[T any](plan *Plan, inputData map[string]interface{}) (T, error) {
+	var target T
+
+	data, err := plan.Transform(inputData)
+	if err != nil {
+		return target, err
+	}
+
+	d := &structDecoder{}
+	d.assign(reflect.ValueOf(&target).Elem(), data, "")
+	if len(d.mismatches) > 0 {
+		return target, &MismatchError{Fields: d.mismatches}
+	}
+	return target, nil
+}
+
+// PlanFor compiles a plan from the `transform` tags of T, so a struct can describe its own mapping
+// without a rules file. Nested struct fields without a tag become groups of their own tagged fields.
+func PlanFor[T any]() (*Plan, error) {
+	rulesMap := tagRules(reflect.TypeOf((*T)(nil)).Elem())
+	if len(rulesMap) == 0 {
+		return nil, fmt.Errorf("type %T has no fields with a %q tag", *new(T), TransformTag)
+	}
+
+	ruleSet, err := rules.FromMap(rulesMap)
+	if err != nil {
+		return nil, err
+	}
+	return (&KeywordTransformer{}).Compile(ruleSet)
+}
+
+// tagRules builds a rules map from the transform tags of a struct type.
+func tagRules(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	rulesMap := make(map[string]interface{})
+	if t.Kind() != reflect.Struct {
+		return rulesMap
+	}
+
+	for _, field := range fieldsOf(t) {
+		if path := field.sourcePath; path != "" {
+			rulesMap[field.name] = path
+			continue
+		}
+		if nested := tagRules(field.typ); len(nested) > 0 {
+			rulesMap[field.name] = nested
+		}
+	}
+	return rulesMap
+}
+
+// structField is the cached metadata of an exported struct field.
+type structField struct {
+	index      int
+	name       string
+	sourcePath string
+	typ        reflect.Type
+}
+
+var structFields sync.Map // reflect.Type -> []structField
+
+// fieldsOf returns the exported fields of a struct type with their target names.
+func fieldsOf(t reflect.Type) []structField {
+	if cached, ok := structFields.Load(t); ok {
+		return cached.([]structField)
+	}
+
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		fields = append(fields, structField{index: i, name: name, sourcePath: field.Tag.Get(TransformTag), typ: field.Type})
+	}
+
+	structFields.Store(t, fields)
+	return fields
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// structDecoder assigns generic transformed values to typed Go values, collecting every mismatch.
+type structDecoder struct {
+	mismatches []*FieldError
+}
+
+func (d *structDecoder) mismatch(field string, target reflect.Value, value interface{}) {
+	d.mismatches = append(d.mismatches, &FieldError{Field: field, Expected: target.Type().String(), Got: describe(value)})
+}
+
+// assign stores value into target, converting between the JSON-like value model and the target type.
+func (d *structDecoder) assign(target reflect.Value, value interface{}, field string) {
+	if value == nil {
+		return // Null and missing values leave the zero value in place.
+	}
+
+	if target.Type() == timeType {
+		s, ok := value.(string)
+		if !ok {
+			d.mismatch(field, target, value)
+			return
+		}
+		t, err := utils.ParseTimestamp(s)
+		if err != nil {
+			d.mismatch(field, target, value)
+			return
+		}
+		target.Set(reflect.ValueOf(t))
+		return
+	}
+
+	switch target.Kind() {
+	case reflect.Pointer:
+		elem := reflect.New(target.Type().Elem())
+		before := len(d.mismatches)
+		d.assign(elem.Elem(), value, field)
+		if len(d.mismatches) == before {
+			target.Set(elem)
+		}
+
+	case reflect.Interface:
+		if target.NumMethod() == 0 {
+			target.Set(reflect.ValueOf(value))
+			return
+		}
+		d.mismatch(field, target, value)
+
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			d.mismatch(field, target, value)
+			return
+		}
+		for _, f := range fieldsOf(target.Type()) {
+			if fieldValue, exists := object[f.name]; exists {
+				d.assign(target.Field(f.index), fieldValue, joinField(field, f.name))
+			}
+		}
+
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok || target.Type().Key().Kind() != reflect.String {
+			d.mismatch(field, target, value)
+			return
+		}
+		m := reflect.MakeMapWithSize(target.Type(), len(object))
+		for key, item := range object {
+			elem := reflect.New(target.Type().Elem()).Elem()
+			d.assign(elem, item, joinField(field, key))
+			m.SetMapIndex(reflect.ValueOf(key).Convert(target.Type().Key()), elem)
+		}
+		target.Set(m)
+
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			d.mismatch(field, target, value)
+			return
+		}
+		s := reflect.MakeSlice(target.Type(), len(list), len(list))
+		for i, item := range list {
+			d.assign(s.Index(i), item, fmt.Sprintf("%s[%d]", field, i))
+		}
+		target.Set(s)
+
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			d.mismatch(field, target, value)
+			return
+		}
+		target.SetString(s)
+
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			d.mismatch(field, target, value)
+			return
+		}
+		target.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f, ok := value.(float64)
+		if !ok || f != math.Trunc(f) || target.OverflowInt(int64(f)) {
+			d.mismatch(field, target, value)
+			return
+		}
+		target.SetInt(int64(f))
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		f, ok := value.(float64)
+		if !ok || f < 0 || f != math.Trunc(f) || target.OverflowUint(uint64(f)) {
+			d.mismatch(field, target, value)
+			return
+		}
+		target.SetUint(uint64(f))
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := value.(float64)
+		if !ok {
+			d.mismatch(field, target, value)
+			return
+		}
+		target.SetFloat(f)
+
+	default:
+		d.mismatch(field, target, value)
+	}
+}
+
+// describe names the type of a transformed value in the terms of the input format.
+func describe(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case bool:
+		return fmt.Sprintf("bool %v", v)
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"pathid_assignment/pkg/utils"
 )
 
@@ -14,8 +15,22 @@ type Unmarshaller interface {
 	UnmarshalByProperty(data []byte, rules map[string]interface{}, prop string) ([]map[string]interface{}, error)
 }
 
+// Logging is implemented by unmarshallers that log warnings, so their messages can carry the context
+// of the caller, such as the file being read.
+type Logging interface {
+	WithLogger(logger *slog.Logger) Unmarshaller
+}
+
 // JSONUnmarshaller implements the Unmarshaller interface for JSON format.
-type JSONUnmarshaller struct{}
+type JSONUnmarshaller struct {
+	// Logger receives warnings about unexpected input structures; nothing is logged when nil.
+	Logger *slog.Logger
+}
+
+// WithLogger returns a copy of the unmarshaller logging to the given logger.
+func (u *JSONUnmarshaller) WithLogger(logger *slog.Logger) Unmarshaller {
+	return &JSONUnmarshaller{Logger: logger}
+}
 
 // NewJSONUnmarshaller creates a new instance of JSONUnmarshaller.
 func NewJSONUnmarshaller() Unmarshaller {
@@ -39,7 +54,7 @@ func (u *JSONUnmarshaller) UnmarshalByProperty(data []byte, rules map[string]int
 			}
 			return u.Unmarshal(extractedData, rules)
 		} else {
-			fmt.Printf("Warning: '%s' field is not an array\n", prop)
+			utils.LoggerOrDiscard(u.Logger).Warn("property is not an array, reading the whole document", "property", prop)
 		}
 	}
 
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops every log record, for components given no logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// LoggerOrDiscard returns the logger, or a logger dropping everything when it is nil, so library
+// components never write to the global loggers.
+func LoggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger != nil {
+		return logger
+	}
+	return slog.New(discardHandler{})
+}
@@ -1,6 +1,19 @@
 package utils
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// timestampLayouts lists the timestamp formats accepted by ParseTimestamp.
+// Graph exports omit the timezone (e.g. 2017-12-27T04:06:12), which is interpreted as UTC.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
 
 // GetJSONKeys extracts top-level keys from JSON data
 func GetJSONKeys(data []byte) []string {
@@ -15,6 +28,9 @@ func GetJSONKeys(data []byte) []string {
 }
 
 // FindMappedKey finds the correct key mapping from a string keys map.
+//
+// Deprecated: it only handles flat rules mapping a single source path; use (*transformer.KeywordTransformer).Invert
+// to map target records back to their source paths.
 func FindMappedKey(targetKey string, rulesMap map[string]interface{}) string {
 	for k, v := range rulesMap {
 		if mappedKey, ok := v.(string); ok && mappedKey == targetKey {
@@ -23,3 +39,13 @@ func FindMappedKey(targetKey string, rulesMap map[string]interface{}) string {
 	}
 	return targetKey // Default fallback
 }
+
+// ParseTimestamp parses a timestamp in any of the supported layouts, defaulting to UTC when no zone is given.
+func ParseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}